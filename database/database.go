@@ -2,6 +2,7 @@ package database
 
 import (
     // standard library
+    "fmt"
     "log"
     "os"
 
@@ -45,6 +46,218 @@ CREATE TABLE tags (
 );
 `
 
+// Schema changes made after the initial release. Each entry is applied once, in
+// order, and the number of applied entries is tracked in `PRAGMA user_version`.
+// Only ever append to this list!
+var migrations = []string{
+    // recurring todos
+    `
+    ALTER TABLE todos ADD COLUMN rrule varchar DEFAULT '';
+    ALTER TABLE todos ADD COLUMN rrule_start datetime DEFAULT '0001-01-01 00:00:00+00:00';
+    ALTER TABLE todos ADD COLUMN timezone varchar DEFAULT '';
+    `,
+    // priority, estimates and manual ordering
+    `
+    ALTER TABLE todos ADD COLUMN priority varchar DEFAULT '';
+    ALTER TABLE todos ADD COLUMN estimate integer DEFAULT 0;
+    ALTER TABLE todos ADD COLUMN rank varchar DEFAULT '';
+    UPDATE todos SET rank = printf('%010di', id);
+    CREATE INDEX todos_state_rank ON todos(state, rank);
+    `,
+    // multiple tags per todo
+    `
+    CREATE TABLE todo_tags (
+        todo_id integer,
+        tag_id integer,
+        position integer,
+        PRIMARY KEY (todo_id, tag_id)
+    );
+    CREATE INDEX todo_tags_tag ON todo_tags(tag_id);
+    INSERT INTO todo_tags(todo_id, tag_id, position) SELECT id, tag_id, 0 FROM todos WHERE tag_id > 0;
+    `,
+    // tag management, existing tags go to the first user
+    `
+    ALTER TABLE tags ADD COLUMN color varchar DEFAULT '';
+    ALTER TABLE tags ADD COLUMN description text DEFAULT '';
+    ALTER TABLE tags ADD COLUMN owner_id integer DEFAULT 0;
+    UPDATE tags SET owner_id = IFNULL((SELECT MIN(id) FROM users), 0);
+    UPDATE tags SET color = CASE id % 7
+        WHEN 0 THEN '#07457E' WHEN 1 THEN '#FFC914' WHEN 2 THEN '#7C077E' WHEN 3 THEN '#17BEBB'
+        WHEN 4 THEN '#F17300' WHEN 5 THEN '#76B041' ELSE '#8C0000' END;
+    `,
+    // hierarchical tags
+    `
+    ALTER TABLE tags ADD COLUMN parent_id integer DEFAULT 0;
+    `,
+    // todo history
+    `
+    CREATE TABLE todo_events (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        todo_id integer,
+        kind varchar,
+        user_id integer,
+        token_id integer,
+        time datetime,
+        changes text,
+        snapshot text
+    );
+    CREATE INDEX todo_events_todo ON todo_events(todo_id);
+    `,
+    // trash
+    `
+    ALTER TABLE todos ADD COLUMN deleted_at datetime DEFAULT NULL;
+    `,
+    // optimistic concurrency
+    `
+    ALTER TABLE todos ADD COLUMN version integer DEFAULT 1;
+    `,
+    // idempotency keys
+    `
+    CREATE TABLE idempotency_keys (
+        token_id integer,
+        key varchar,
+        request_hash varchar,
+        status integer,
+        response text,
+        created datetime,
+        PRIMARY KEY (token_id, key)
+    );
+    CREATE INDEX idempotency_keys_created ON idempotency_keys(created);
+    `,
+    // comments
+    `
+    CREATE TABLE comments (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        todo_id integer,
+        author_id integer,
+        body text,
+        created datetime,
+        edited datetime DEFAULT NULL
+    );
+    CREATE INDEX comments_todo ON comments(todo_id);
+    `,
+    // attachments
+    `
+    CREATE TABLE attachments (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        todo_id integer,
+        uploader_id integer,
+        name varchar,
+        mime_type varchar,
+        size integer,
+        storage_key varchar,
+        created datetime
+    );
+    CREATE INDEX attachments_todo ON attachments(todo_id);
+    `,
+    // dependencies between todos
+    `
+    CREATE TABLE todo_dependencies (
+        todo_id integer,
+        blocker_id integer,
+        PRIMARY KEY (todo_id, blocker_id)
+    );
+    CREATE INDEX todo_dependencies_blocker ON todo_dependencies(blocker_id);
+    `,
+    // people responsible for todos besides their owner
+    `
+    CREATE TABLE todo_assignees (
+        todo_id integer,
+        user_id integer,
+        PRIMARY KEY (todo_id, user_id)
+    );
+    CREATE INDEX todo_assignees_user ON todo_assignees(user_id);
+    `,
+    // todos shared with other users
+    `
+    CREATE TABLE todo_shares (
+        todo_id integer,
+        user_id integer,
+        level text,
+        PRIMARY KEY (todo_id, user_id)
+    );
+    CREATE INDEX todo_shares_user ON todo_shares(user_id);
+    `,
+    // boards shared by teams, owning todos and tags
+    `
+    CREATE TABLE boards (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        name varchar,
+        created datetime
+    );
+    CREATE TABLE board_members (
+        board_id integer,
+        user_id integer,
+        role text,
+        PRIMARY KEY (board_id, user_id)
+    );
+    CREATE INDEX board_members_user ON board_members(user_id);
+    ALTER TABLE todos ADD COLUMN board_id integer DEFAULT 0;
+    ALTER TABLE tags ADD COLUMN board_id integer DEFAULT 0;
+    ALTER TABLE tokens ADD COLUMN board_id integer DEFAULT 0;
+    `,
+    // read-only links to todos for people without an account
+    `
+    CREATE TABLE share_links (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        slug varchar UNIQUE,
+        owner_id integer,
+        todo_id integer DEFAULT 0,
+        filter text,
+        password varchar DEFAULT '',
+        expires_at datetime DEFAULT NULL,
+        created datetime
+    );
+    CREATE INDEX share_links_owner ON share_links(owner_id);
+    `,
+    // public profile pages listing a user's public todos
+    `
+    ALTER TABLE users ADD COLUMN display_name varchar DEFAULT '';
+    ALTER TABLE users ADD COLUMN profile_public integer DEFAULT 1;
+    `,
+    // outgoing webhooks on changes to todos, and their deliveries
+    `
+    CREATE TABLE webhooks (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        owner_id integer,
+        board_id integer DEFAULT 0,
+        url varchar,
+        events varchar DEFAULT '',
+        tag_id integer DEFAULT 0,
+        secret varchar,
+        active integer DEFAULT 1,
+        last_event_id integer DEFAULT 0,
+        created datetime
+    );
+    CREATE INDEX webhooks_owner ON webhooks(owner_id);
+    CREATE TABLE webhook_deliveries (
+        id integer PRIMARY KEY AUTOINCREMENT,
+        webhook_id integer,
+        event_id integer,
+        event varchar,
+        payload text,
+        status varchar,
+        attempts integer DEFAULT 0,
+        next_attempt datetime DEFAULT NULL,
+        response_status integer DEFAULT 0,
+        error varchar DEFAULT '',
+        created datetime,
+        last_attempt datetime DEFAULT NULL
+    );
+    CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries(webhook_id);
+    CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+    `,
+    // secret addresses mail can be sent to for it to become a todo
+    `
+    ALTER TABLE users ADD COLUMN mail_key varchar DEFAULT '';
+    CREATE INDEX users_mail_key ON users(mail_key);
+    `,
+    // entity tags of responses to requests with idempotency keys
+    `
+    ALTER TABLE idempotency_keys ADD COLUMN etag varchar DEFAULT '';
+    `,
+}
+
 var (
     // connection handle
     conn *sql.DB
@@ -110,6 +323,40 @@ func InitializeDatabase(filename string) {
     stmt.Close()
 }
 
+func migrate() {
+    // Check how many migrations have already been applied
+    var version int
+    err := conn.QueryRow("PRAGMA user_version").Scan(&version)
+    if err != nil {
+        log.Fatalf("Failed to read database version: %s", err)
+    }
+
+    for ; version < len(migrations); version++ {
+        log.Printf("Info: Migrating database to version %d...", version + 1)
+
+        // Apply the migration and bump the version together
+        tx, err := conn.Begin()
+        if err != nil {
+            log.Fatalf("Failed to migrate database: %s", err)
+        }
+        _, err = tx.Exec(migrations[version])
+        if err != nil {
+            tx.Rollback()
+            log.Fatalf("Failed to migrate database: %s", err)
+        }
+        // PRAGMA does not accept bound parameters
+        _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version + 1))
+        if err != nil {
+            tx.Rollback()
+            log.Fatalf("Failed to migrate database: %s", err)
+        }
+        err = tx.Commit()
+        if err != nil {
+            log.Fatalf("Failed to migrate database: %s", err)
+        }
+    }
+}
+
 func Hash(str string) string {
     // SHA512 is the password hash being used
     hashalgo := sha512.New()
@@ -124,6 +371,9 @@ func Connect(filename string) {
     }
 
     connect(filename)
+
+    // Bring the schema up to date
+    migrate()
 }
 
 func GetConnection() *sql.DB {
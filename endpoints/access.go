@@ -0,0 +1,118 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+// Response carrying nothing but an error, for checks shared between endpoints
+type errorResponse struct {
+    Error   string          `json:"error,omitempty"`
+}
+
+// Check that a todo can be seen with a token, the same way as for its info:
+// public todos can be seen by anyone, others only by their owner, the members
+// of their board and the users they are assigned to or shared with. auth may be
+// a token that failed to read. Writes the error response and returns false if
+// the todo can't be seen.
+func readVisibleTodo(w http.ResponseWriter, todo *models.Todo, auth *models.Token) bool {
+    if !todo.ReadPermissions() || (!todo.Public && auth.Id == 0) {
+        // Unknown, or fake a not known error
+        resp := errorResponse{
+            Error: "Todo not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if todo.Public {
+        return true
+    }
+    if auth.Type > 2 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if todo.AccessOf(auth) < models.AccessRead {
+        // Todo doesn't belong to the right owner
+        resp := errorResponse{
+            Error: "User does not own todo",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    return true
+}
+
+// Check that a todo can be changed with a token: it has to be able to modify
+// todos and belong to the todo's owner. Writes the error response and returns
+// false if not.
+func readOwnTodo(w http.ResponseWriter, todo *models.Todo, auth *models.Token) bool {
+    return readTodoWithAccess(w, todo, auth, models.AccessOwner)
+}
+
+// Check that a todo can be changed with a token, like readOwnTodo, but also
+// letting the users it is shared with for writing do so
+func readWritableTodo(w http.ResponseWriter, todo *models.Todo, auth *models.Token) bool {
+    return readTodoWithAccess(w, todo, auth, models.AccessWrite)
+}
+
+// Check that a token can modify todos, and that its owner has at least the given
+// access to a todo. Writes the error response and returns false if not.
+func readTodoWithAccess(w http.ResponseWriter, todo *models.Todo, auth *models.Token, access int) bool {
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if !todo.ReadPermissions() {
+        resp := errorResponse{
+            Error: "Todo not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if todo.AccessOf(auth) < access {
+        // Todo doesn't belong to the right owner
+        resp := errorResponse{
+            Error: "User does not own todo",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    return true
+}
@@ -0,0 +1,367 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // AttachmentEndpoint represents the controller for operating on the Attachment resource
+    AttachmentEndpoint struct {}
+
+    // List endpoint
+    AttachmentEndpointListRequest struct {
+        Todo        models.Todo         `json:"todo"`
+        Auth        string              `json:"authority"`
+    }
+    AttachmentEndpointListResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Attachments []models.Attachment `json:"attachments"`
+    }
+
+    // Upload endpoint, the request is a multipart form
+    AttachmentEndpointUploadResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Attachment  *models.Attachment  `json:"attachment,omitempty"`
+    }
+
+    // Download endpoint
+    AttachmentEndpointDownloadRequest struct {
+        Attachment  models.Attachment   `json:"attachment"`
+        Auth        string              `json:"authority"`
+    }
+
+    // Remove endpoint
+    AttachmentEndpointRemoveRequest struct {
+        Attachment  models.Attachment   `json:"attachment"`
+        Auth        string              `json:"authority"`
+    }
+    AttachmentEndpointRemoveResponse struct {
+        Error       string              `json:"error,omitempty"`
+    }
+)
+
+// Room for the rest of an upload form besides the file
+const attachmentFormOverhead = 1 << 20
+
+func NewAttachmentEndpoint() *AttachmentEndpoint {
+    return &AttachmentEndpoint{}
+}
+
+func (ae AttachmentEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var aelr AttachmentEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&aelr)
+
+    // The todo may also be given in the query string
+    if id := r.URL.Query().Get("todo_id"); len(id) != 0 {
+        var err error
+        aelr.Todo.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+
+    // Public todos don't need a token
+    auth := models.Token{
+        Value:  aelr.Auth,
+    }
+    auth.ReadValues()
+
+    // Same checks as for the information on the todo
+    if !readVisibleTodo(w, &aelr.Todo, &auth) {
+        return
+    }
+
+    // Read all the attachments
+    resp := AttachmentEndpointListResponse{
+        Attachments: models.ListAttachments(aelr.Todo.Id),
+    }
+    if resp.Attachments == nil {
+        resp.Attachments = []models.Attachment{}
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (ae AttachmentEndpoint) Upload(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Don't even read uploads that are too big
+    r.Body = http.MaxBytesReader(w, r.Body, models.MaxAttachmentSize + attachmentFormOverhead)
+    err := r.ParseMultipartForm(attachmentFormOverhead)
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        resp := AttachmentEndpointUploadResponse{
+            Error: fmt.Sprintf("Attachments can be at most %d bytes", models.MaxAttachmentSize),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(413)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    if err != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+    defer r.MultipartForm.RemoveAll()
+
+    // Which todo, and the file to attach
+    todoId, err := strconv.Atoi(r.FormValue("todo_id"))
+    file, header, ferr := r.FormFile("file")
+    if err != nil || ferr != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+    defer file.Close()
+
+    // Attaching is modifying, so only the owner and the users the todo is
+    // shared with for writing can do it
+    auth := models.Token{
+        Value:  r.FormValue("authority"),
+    }
+    todo := models.Todo{
+        Id:     todoId,
+    }
+    if !readWritableTodo(w, &todo, &auth) {
+        return
+    }
+
+    // Check the size, and the type of what is actually in the file
+    if header.Size > models.MaxAttachmentSize {
+        resp := AttachmentEndpointUploadResponse{
+            Error: fmt.Sprintf("Attachments can be at most %d bytes", models.MaxAttachmentSize),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(413)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    sniff := make([]byte, 512)
+    n, _ := io.ReadFull(file, sniff)
+    mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+    if !models.AttachmentTypeAllowed(mimeType) {
+        resp := AttachmentEndpointUploadResponse{
+            Error: fmt.Sprintf("Attachments of type %s are not allowed", mimeType),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(415)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    _, err = file.Seek(0, io.SeekStart)
+
+    // Everything looks good! Store the file
+    attachment := models.Attachment{
+        TodoId:     todo.Id,
+        UploaderId: auth.OwnerId,
+        Name:       filepath.Base(header.Filename),
+        MimeType:   mimeType,
+        Size:       header.Size,
+    }
+    if err != nil || !attachment.InsertValues(file) {
+        // Storage or database error
+        resp := AttachmentEndpointUploadResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := AttachmentEndpointUploadResponse{
+        Attachment: &attachment,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (ae AttachmentEndpoint) Download(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var aedr AttachmentEndpointDownloadRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&aedr)
+
+    // Links can't send a body, so both may also be given in the query string
+    query := r.URL.Query()
+    if id := query.Get("id"); len(id) != 0 {
+        var err error
+        aedr.Attachment.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+    if authority := query.Get("authority"); len(authority) != 0 {
+        aedr.Auth = authority
+    }
+
+    attachment := models.Attachment{
+        Id:     aedr.Attachment.Id,
+    }
+    if !attachment.ReadValues() {
+        resp := AttachmentEndpointRemoveResponse{
+            Error: "Attachment not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Same checks as for the information on the todo
+    auth := models.Token{
+        Value:  aedr.Auth,
+    }
+    auth.ReadValues()
+    todo := models.Todo{
+        Id:     attachment.TodoId,
+    }
+    if !readVisibleTodo(w, &todo, &auth) {
+        return
+    }
+
+    content := attachment.Open()
+    if content == nil {
+        // Storage error
+        resp := AttachmentEndpointRemoveResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    defer content.Close()
+
+    // Images can be shown right away, everything else is downloaded
+    disposition := "attachment"
+    if strings.HasPrefix(attachment.MimeType, "image/") {
+        disposition = "inline"
+    }
+    w.Header().Set("Content-Type", attachment.MimeType)
+    w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+    w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(200)
+    io.Copy(w, content)
+}
+
+func (ae AttachmentEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var aerr AttachmentEndpointRemoveRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&aerr)
+
+    // Check for errors
+    if err != nil || len(aerr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    attachment := models.Attachment{
+        Id:     aerr.Attachment.Id,
+    }
+    if !attachment.ReadValues() {
+        resp := AttachmentEndpointRemoveResponse{
+            Error: "Attachment not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Removing is modifying, so only the owner of the todo and the users it is
+    // shared with for writing can do it
+    auth := models.Token{
+        Value:  aerr.Auth,
+    }
+    todo := models.Todo{
+        Id:     attachment.TodoId,
+    }
+    if !readWritableTodo(w, &todo, &auth) {
+        return
+    }
+
+    if !attachment.Remove() {
+        // Database error
+        resp := AttachmentEndpointRemoveResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := AttachmentEndpointRemoveResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
@@ -0,0 +1,553 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // BoardEndpoint represents the controller for operating on the Board resource
+    BoardEndpoint struct {}
+
+    // List endpoint
+    BoardEndpointListRequest struct {
+        Auth    string                  `json:"authority"`
+    }
+    BoardEndpointListResponse struct {
+        Error   string                  `json:"error,omitempty"`
+        Boards  []models.Board          `json:"boards"`
+    }
+
+    // Update endpoint
+    BoardEndpointUpdateRequest struct {
+        Board   models.Board            `json:"board"`
+        Auth    string                  `json:"authority"`
+    }
+    BoardEndpointUpdateResponse struct {
+        Error   string                  `json:"error,omitempty"`
+        Board   *models.Board           `json:"board,omitempty"`
+    }
+
+    // Remove endpoint
+    BoardEndpointRemoveRequest struct {
+        Board   models.Board            `json:"board"`
+        Auth    string                  `json:"authority"`
+    }
+
+    // Members endpoint
+    BoardEndpointMembersRequest struct {
+        Board   models.Board            `json:"board"`
+        Auth    string                  `json:"authority"`
+    }
+    BoardEndpointMembersResponse struct {
+        Error   string                  `json:"error,omitempty"`
+        Members []models.BoardMember    `json:"members"`
+    }
+
+    // Member endpoint
+    BoardEndpointMemberRequest struct {
+        Board   models.Board            `json:"board"`
+        Auth    string                  `json:"authority"`
+        // Who to add to the board, by name, and their role. An empty role
+        // takes them off the board.
+        Member  models.BoardMember      `json:"member"`
+    }
+)
+
+func NewBoardEndpoint() *BoardEndpoint {
+    return &BoardEndpoint{}
+}
+
+// Check where new todos and tags of the owner of a token go: a token limited to
+// a board can only put them on that board, and the owner has to be at least a
+// member of the board. Board 0 stands for the owner's personal todos and tags.
+// Returns a friendly error message, or "" if they can go on the board.
+func checkNewOnBoard(board_id *int, auth *models.Token) string {
+    if auth.BoardId > 0 {
+        if *board_id != 0 && *board_id != auth.BoardId {
+            return "Authorization token is limited to another board"
+        }
+        *board_id = auth.BoardId
+    }
+    if *board_id != 0 && !models.RoleAtLeast(models.BoardRoleOf(*board_id, auth.OwnerId), models.RoleMember) {
+        return "User is not a member of board"
+    }
+    return ""
+}
+
+// Check that the owner of a token can manage a tag: personal tags only by their
+// owner, and the tags of a board by the members with at least the given role
+func canManageTag(tag *models.Tag, auth *models.Token, role string) bool {
+    if auth.BoardId > 0 && tag.BoardId != auth.BoardId {
+        return false
+    }
+    if tag.BoardId > 0 {
+        return models.RoleAtLeast(models.BoardRoleOf(tag.BoardId, auth.OwnerId), role)
+    }
+    return tag.OwnerId == auth.OwnerId
+}
+
+// Only list the todos on the board of a token limited to one
+func scopeFilter(filter *models.TodoFilter, auth *models.Token) {
+    if auth.BoardId > 0 {
+        filter.BoardId = &auth.BoardId
+    }
+}
+
+// Read a board and check that the owner of a token has at least the given role
+// on it. Writes the error response and returns false if not.
+func readBoardWithRole(w http.ResponseWriter, board *models.Board, auth *models.Token, role string) bool {
+    // Boards can't be told apart from unknown ones by those who aren't members
+    current := models.BoardRoleOf(board.Id, auth.OwnerId)
+    if (auth.BoardId > 0 && auth.BoardId != board.Id) || !models.ValidRole(current) || !board.ReadValues() {
+        resp := errorResponse{
+            Error: "Board not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if !models.RoleAtLeast(current, role) {
+        resp := errorResponse{
+            Error: fmt.Sprintf("User's role on board is not %s", role),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    board.Role = current
+    return true
+}
+
+func (be BoardEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var belr BoardEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&belr)
+
+    // The token may also be given in the query string
+    if authority := r.URL.Query().Get("authority"); len(authority) != 0 {
+        belr.Auth = authority
+    }
+
+    // Listing boards needs a token that can see private todos
+    auth := models.Token{
+        Value:  belr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := BoardEndpointListResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Read all the boards, or just the one the token is limited to
+    resp := BoardEndpointListResponse{
+        Boards: []models.Board{},
+    }
+    for _, board := range models.ListBoards(auth.OwnerId) {
+        if auth.BoardId == 0 || auth.BoardId == board.Id {
+            resp.Boards = append(resp.Boards, board)
+        }
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (be BoardEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var beur BoardEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&beur)
+
+    // Check for errors
+    if err != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Managing boards needs a primary token
+    auth := models.Token{
+        Value:  beur.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 || (beur.Board.Id <= 0 && auth.BoardId > 0) {
+        // User not authorized
+        resp := BoardEndpointUpdateResponse{
+            Error: "Authorization token lacks board privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Only the owners can rename an existing board
+    board := models.Board{
+        Id:     beur.Board.Id,
+    }
+    if board.Id > 0 && !readBoardWithRole(w, &board, &auth, models.RoleOwner) {
+        return
+    }
+
+    // Check the new name
+    board.Name = beur.Board.Name
+    if msg := board.Validate(); len(msg) != 0 {
+        resp := BoardEndpointUpdateResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Write it out, whoever creates a board owns it
+    var ok bool
+    if board.Id > 0 {
+        ok = board.WriteValues()
+    } else {
+        board.Id = 0
+        board.Role = models.RoleOwner
+        ok = board.InsertValues(auth.OwnerId)
+    }
+    if !ok {
+        // Database error
+        resp := BoardEndpointUpdateResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything is good! Send back the board as it was stored
+    resp := BoardEndpointUpdateResponse{
+        Board:  &board,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (be BoardEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var berr BoardEndpointRemoveRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&berr)
+
+    // Check for errors
+    if err != nil || len(berr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Removing a board needs a primary token...
+    auth := models.Token{
+        Value:  berr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks removal privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // ... of one of the owners of the board
+    board := models.Board{
+        Id:     berr.Board.Id,
+    }
+    if !readBoardWithRole(w, &board, &auth, models.RoleOwner) {
+        return
+    }
+
+    // Todos aren't thrown away along with the board, they have to be purged
+    // first
+    if board.HasTodos() {
+        resp := errorResponse{
+            Error: "Board still has todos",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(409)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if !board.Remove() {
+        // Database error
+        resp := errorResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := errorResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (be BoardEndpoint) Members(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var bemr BoardEndpointMembersRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&bemr)
+
+    // The board may also be given in the query string
+    query := r.URL.Query()
+    if id := query.Get("board_id"); len(id) != 0 {
+        var err error
+        bemr.Board.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+    if authority := query.Get("authority"); len(authority) != 0 {
+        bemr.Auth = authority
+    }
+
+    // Every member can see who else is on the board
+    auth := models.Token{
+        Value:  bemr.Auth,
+    }
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := BoardEndpointMembersResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    if !readBoardWithRole(w, &bemr.Board, &auth, models.RoleViewer) {
+        return
+    }
+
+    // Read all the members
+    resp := BoardEndpointMembersResponse{
+        Members: models.ListBoardMembers(bemr.Board.Id),
+    }
+    if resp.Members == nil {
+        resp.Members = []models.BoardMember{}
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (be BoardEndpoint) Member(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var bemr BoardEndpointMemberRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&bemr)
+
+    // Check for errors
+    if err != nil || len(bemr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Managing members needs a primary token
+    auth := models.Token{
+        Value:  bemr.Auth,
+    }
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks board privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Find out who the member is, and what they are now
+    board := models.Board{
+        Id:     bemr.Board.Id,
+    }
+    if !readBoardWithRole(w, &board, &auth, models.RoleViewer) {
+        return
+    }
+    userIds, unknown := models.UserIdsByName([]string{bemr.Member.Name})
+    if len(unknown) != 0 {
+        resp := errorResponse{
+            Error: fmt.Sprintf("User %s not found", unknown),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    member := models.BoardMember{
+        BoardId:    board.Id,
+        UserId:     userIds[0],
+        Role:       bemr.Member.Role,
+    }
+    current := models.BoardRoleOf(board.Id, member.UserId)
+    owners := 0
+    for _, m := range models.ListBoardMembers(board.Id) {
+        if m.Role == models.RoleOwner {
+            owners++
+        }
+    }
+
+    // Anyone can leave a board, admins manage the other members, and only
+    // owners can make or unmake owners. There is always an owner left.
+    var msg string
+    status := 403
+    leaving := member.UserId == auth.OwnerId && len(member.Role) == 0
+    if len(member.Role) != 0 && !models.ValidRole(member.Role) {
+        msg = "Invalid role"
+        status = 400
+    } else if !leaving && !models.RoleAtLeast(board.Role, models.RoleAdmin) {
+        msg = "User's role on board is not admin"
+    } else if !leaving && (member.Role == models.RoleOwner || current == models.RoleOwner) && board.Role != models.RoleOwner {
+        msg = "User's role on board is not owner"
+    } else if current == models.RoleOwner && member.Role != models.RoleOwner && owners <= 1 {
+        msg = "Board has to keep an owner"
+        status = 409
+    }
+    if len(msg) != 0 {
+        resp := errorResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    member.Editor = &auth
+    var ok bool
+    if len(member.Role) == 0 {
+        ok = member.Remove()
+    } else {
+        ok = member.WriteValues()
+    }
+    if !ok {
+        // Database error
+        resp := errorResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Send back who is on the board now
+    resp := BoardEndpointMembersResponse{
+        Members: models.ListBoardMembers(board.Id),
+    }
+    if resp.Members == nil {
+        resp.Members = []models.BoardMember{}
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
@@ -0,0 +1,298 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // CommentEndpoint represents the controller for operating on the Comment resource
+    CommentEndpoint struct {}
+
+    // List endpoint
+    CommentEndpointListRequest struct {
+        Todo        models.Todo         `json:"todo"`
+        Auth        string              `json:"authority"`
+    }
+    CommentEndpointListResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Comments    []models.Comment    `json:"comments"`
+    }
+
+    // Update endpoint
+    CommentEndpointUpdateRequest struct {
+        Comment     models.Comment      `json:"comment"`
+        Auth        string              `json:"authority"`
+    }
+    CommentEndpointUpdateResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Comment     *models.Comment     `json:"comment,omitempty"`
+    }
+
+    // Remove endpoint
+    CommentEndpointRemoveRequest struct {
+        Comment     models.Comment      `json:"comment"`
+        Auth        string              `json:"authority"`
+    }
+    CommentEndpointRemoveResponse struct {
+        Error       string              `json:"error,omitempty"`
+    }
+)
+
+func NewCommentEndpoint() *CommentEndpoint {
+    return &CommentEndpoint{}
+}
+
+// Read a comment and check that it was written by the owner of a token. Writes
+// the error response and returns false if not.
+func readOwnComment(w http.ResponseWriter, comment *models.Comment, auth *models.Token) bool {
+    if !comment.ReadValues() {
+        resp := CommentEndpointUpdateResponse{
+            Error: "Comment not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if comment.AuthorId != auth.OwnerId {
+        // Comment was written by someone else
+        resp := CommentEndpointUpdateResponse{
+            Error: "User did not write comment",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    return true
+}
+
+func (ce CommentEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var celr CommentEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&celr)
+
+    // The todo may also be given in the query string
+    if id := r.URL.Query().Get("todo_id"); len(id) != 0 {
+        var err error
+        celr.Todo.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+
+    // Public todos don't need a token
+    auth := models.Token{
+        Value:  celr.Auth,
+    }
+    auth.ReadValues()
+
+    // Check that the todo can be seen
+    if !readVisibleTodo(w, &celr.Todo, &auth) {
+        return
+    }
+
+    // Read all the comments
+    resp := CommentEndpointListResponse{
+        Comments: models.ListComments(celr.Todo.Id),
+    }
+    if resp.Comments == nil {
+        resp.Comments = []models.Comment{}
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (ce CommentEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var ceur CommentEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&ceur)
+
+    // Check for errors
+    if err != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Commenting needs a token that can see todos
+    auth := models.Token{
+        Value:  ceur.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := CommentEndpointUpdateResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Existing comments can only be edited by their author
+    comment := models.Comment{
+        Id:     ceur.Comment.Id,
+        TodoId: ceur.Comment.TodoId,
+    }
+    if comment.Id > 0 && !readOwnComment(w, &comment, &auth) {
+        return
+    }
+
+    // The todo has to be one the user can see
+    todo := models.Todo{
+        Id:     comment.TodoId,
+    }
+    if !readVisibleTodo(w, &todo, &auth) {
+        return
+    }
+
+    // Check the new body
+    comment.Body = ceur.Comment.Body
+    if msg := comment.Validate(); len(msg) != 0 {
+        resp := CommentEndpointUpdateResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Write it out
+    var ok bool
+    if comment.Id > 0 {
+        ok = comment.WriteValues()
+    } else {
+        comment.Id = 0
+        comment.AuthorId = auth.OwnerId
+        ok = comment.InsertValues()
+    }
+    if !ok {
+        // Database error
+        resp := CommentEndpointUpdateResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything is good! Send back the comment as it was stored
+    comment.ReadValues()
+    resp := CommentEndpointUpdateResponse{
+        Comment:    &comment,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (ce CommentEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var cerr CommentEndpointRemoveRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&cerr)
+
+    // Check for errors
+    if err != nil || len(cerr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Removing a comment is modifying, check if auth token <= 2
+    auth := models.Token{
+        Value:  cerr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := CommentEndpointRemoveResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Only the author can remove a comment
+    if !readOwnComment(w, &cerr.Comment, &auth) {
+        return
+    }
+
+    if !cerr.Comment.Remove() {
+        // Database error
+        resp := CommentEndpointRemoveResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := CommentEndpointRemoveResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
@@ -0,0 +1,17 @@
+package endpoints
+
+import (
+    // stdlib
+    "path/filepath"
+    "testing"
+
+    // own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// Start a test on a new database of its own, which only has the admin user
+func testDatabase(t *testing.T) {
+    t.Helper()
+    database.Connect(filepath.Join(t.TempDir(), "test.db"))
+    t.Cleanup(database.Disconnect)
+}
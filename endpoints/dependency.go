@@ -0,0 +1,266 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // DependencyEndpoint represents the controller for operating on the Dependency resource
+    DependencyEndpoint struct {}
+
+    // Add and remove endpoints
+    DependencyEndpointUpdateRequest struct {
+        Dependency  models.Dependency   `json:"dependency"`
+        Auth        string              `json:"authority"`
+    }
+    DependencyEndpointUpdateResponse struct {
+        Error       string              `json:"error,omitempty"`
+    }
+
+    // Graph endpoint
+    DependencyEndpointGraphRequest struct {
+        Auth        string              `json:"authority"`
+        // Only include todos with this tag (or one nested under it)
+        TagId       int                 `json:"tag_id"`
+    }
+    DependencyEndpointGraphResponse struct {
+        Nodes       []models.Todo       `json:"nodes"`
+        Edges       []models.Dependency `json:"edges"`
+    }
+
+    // Response when starting a todo that is still blocked
+    blockedResponse struct {
+        Error       string              `json:"error,omitempty"`
+        BlockedBy   []int               `json:"blocked_by,omitempty"`
+    }
+)
+
+func NewDependencyEndpoint() *DependencyEndpoint {
+    return &DependencyEndpoint{}
+}
+
+// Check that a todo isn't started while todos blocking it aren't done yet,
+// unless forced to. Writes the error response and returns false if it is.
+func checkNotBlocked(w http.ResponseWriter, todo_id int, oldState int, newState int, force bool) bool {
+    if force || newState != models.StateInProgress || oldState == models.StateInProgress {
+        return true
+    }
+    blockers := models.ListUnfinishedBlockers(todo_id)
+    if len(blockers) == 0 {
+        return true
+    }
+
+    resp := blockedResponse{
+        Error:      "Todo is blocked by todos that aren't done yet",
+        BlockedBy:  blockers,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write error + payload
+    w.WriteHeader(409)
+    fmt.Fprintf(w, "%s", jresp)
+    return false
+}
+
+func (de DependencyEndpoint) Add(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var deur DependencyEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&deur)
+
+    // Check for errors
+    if err != nil || len(deur.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Only those who can change the blocked todo can say what it waits for
+    auth := models.Token{
+        Value:  deur.Auth,
+    }
+    todo := models.Todo{
+        Id:     deur.Dependency.TodoId,
+    }
+    if !readWritableTodo(w, &todo, &auth) {
+        return
+    }
+
+    // The blocking todo just has to be one the user can see
+    blocker := models.Todo{
+        Id:     deur.Dependency.BlockerId,
+    }
+    if !readVisibleTodo(w, &blocker, &auth) {
+        return
+    }
+
+    // Todos can't end up waiting on themselves
+    if deur.Dependency.CreatesCycle() {
+        resp := DependencyEndpointUpdateResponse{
+            Error: "Dependency would make a todo block itself",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if !deur.Dependency.InsertValues() {
+        // Database error
+        resp := DependencyEndpointUpdateResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := DependencyEndpointUpdateResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (de DependencyEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var deur DependencyEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&deur)
+
+    // Check for errors
+    if err != nil || len(deur.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Only those who can change the blocked todo can say what it waits for
+    auth := models.Token{
+        Value:  deur.Auth,
+    }
+    todo := models.Todo{
+        Id:     deur.Dependency.TodoId,
+    }
+    if !readWritableTodo(w, &todo, &auth) {
+        return
+    }
+
+    if !deur.Dependency.Remove() {
+        // Database error
+        resp := DependencyEndpointUpdateResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := DependencyEndpointUpdateResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (de DependencyEndpoint) Graph(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var degr DependencyEndpointGraphRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&degr)
+
+    // The tag may also be given in the query string
+    if id := r.URL.Query().Get("tag_id"); len(id) != 0 {
+        var err error
+        degr.TagId, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+
+    // The same todos can be seen as in the list
+    var ownerId int = -1
+    var filter models.TodoFilter
+    if len(degr.Auth) != 0 {
+        token := models.Token{
+            Value:  degr.Auth,
+        }
+        token.ReadValues()
+        if token.Type == 1 {
+            ownerId = token.OwnerId
+        }
+        scopeFilter(&filter, &token)
+    }
+    if degr.TagId > 0 {
+        filter.TagIds = []int{degr.TagId}
+    }
+
+    // Only keep the links between todos that are part of the graph
+    resp := DependencyEndpointGraphResponse{
+        Nodes:  models.ListTodos(ownerId, filter),
+        Edges:  []models.Dependency{},
+    }
+    if resp.Nodes == nil {
+        resp.Nodes = []models.Todo{}
+    }
+    nodes := make(map[int]bool)
+    var ids []int
+    for _, todo := range resp.Nodes {
+        nodes[todo.Id] = true
+        ids = append(ids, todo.Id)
+    }
+    for _, dep := range models.ListDependencies(ids) {
+        if nodes[dep.TodoId] && nodes[dep.BlockerId] {
+            resp.Edges = append(resp.Edges, dep)
+        }
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
@@ -0,0 +1,52 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "hash/fnv"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+// Any version will do, for `If-Match: *`
+const anyVersion = -1
+
+// Format the version of a todo as an entity tag
+func versionETag(version int) string {
+    return fmt.Sprintf("\"%d\"", version)
+}
+
+// Make up a weak entity tag for a list of todos, which changes whenever any of
+// them changes or the list itself does
+func listETag(todos []models.Todo) string {
+    h := fnv.New64a()
+    for _, todo := range todos {
+        fmt.Fprintf(h, "%d:%d:%d:%t", todo.Id, todo.Version, todo.CommentCount, todo.Blocked)
+        for _, assignee := range todo.Assignees {
+            fmt.Fprintf(h, ":%d", assignee.Id)
+        }
+        fmt.Fprint(h, ",")
+    }
+    return fmt.Sprintf("W/\"%x\"", h.Sum64())
+}
+
+// Read the version of a todo a request expects from its If-Match header. Returns
+// 0 if there is no usable header, or anyVersion for `*`.
+func ifMatchVersion(r *http.Request) int {
+    header := strings.TrimSpace(r.Header.Get("If-Match"))
+    if header == "*" {
+        return anyVersion
+    }
+
+    // Only a single tag makes sense for a single todo
+    header = strings.TrimPrefix(header, "W/")
+    version, err := strconv.Atoi(strings.Trim(header, "\""))
+    if err != nil || version <= 0 {
+        return 0
+    }
+    return version
+}
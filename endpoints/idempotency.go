@@ -0,0 +1,117 @@
+package endpoints
+
+import (
+    // stdlib
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Keeps a copy of the response to a request with an idempotency key, so it
+    // can be sent again if the request is retried
+    idempotentResponseWriter struct {
+        http.ResponseWriter
+        key     models.IdempotencyKey
+        status  int
+        body    bytes.Buffer
+    }
+)
+
+func (iw *idempotentResponseWriter) WriteHeader(status int) {
+    iw.status = status
+    iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *idempotentResponseWriter) Write(b []byte) (int, error) {
+    if iw.status == 0 {
+        iw.status = 200
+    }
+    iw.body.Write(b)
+    return iw.ResponseWriter.Write(b)
+}
+
+// Remember the response once the request has been handled. Server errors are
+// not remembered, so that the request can be retried.
+func (iw *idempotentResponseWriter) finish() {
+    if iw.status == 0 || iw.status >= 500 {
+        iw.key.Remove()
+        return
+    }
+    iw.key.Status = iw.status
+    iw.key.Response = iw.body.String()
+    iw.key.ETag = iw.Header().Get("ETag")
+    iw.key.WriteResponse()
+}
+
+// Read the idempotency key of a request, preferably from the Idempotency-Key
+// header
+func idempotencyKeyOf(r *http.Request, field string) string {
+    if header := strings.TrimSpace(r.Header.Get("Idempotency-Key")); len(header) != 0 {
+        return header
+    }
+    return field
+}
+
+// Start handling a request with an idempotency key for the owner of a token.
+// payload is what makes up the request, to tell retries apart from different
+// requests reusing the key. If the request was already handled, the original
+// response is sent again; if it can't be handled, an error is sent. In both
+// cases nil is returned. Otherwise, returns a writer to send the response with,
+// which finish must be called on afterwards.
+func beginIdempotentRequest(w http.ResponseWriter, auth *models.Token, key string, payload interface{}) *idempotentResponseWriter {
+    jpayload, _ := json.Marshal(payload)
+    hash := sha256.Sum256(jpayload)
+
+    ikey := models.IdempotencyKey{
+        TokenId:        auth.Id,
+        Key:            key,
+        RequestHash:    hex.EncodeToString(hash[:]),
+    }
+    var resp errorResponse
+    status := 0
+
+    if len(key) > models.MaxIdempotencyKey {
+        resp.Error = fmt.Sprintf("Idempotency key can be at most %d characters", models.MaxIdempotencyKey)
+        status = 400
+    } else if ikey.Reserve() {
+        // First time this key is seen
+        return &idempotentResponseWriter{
+            ResponseWriter: w,
+            key:            ikey,
+        }
+    } else if !ikey.ReadValues() {
+        // Database error
+        resp.Error = "Database error"
+        status = 500
+    } else if ikey.RequestHash != hex.EncodeToString(hash[:]) {
+        resp.Error = "Idempotency key was already used for a different request"
+        status = 422
+    } else if ikey.Status == 0 {
+        resp.Error = "Request with this idempotency key is still being handled"
+        status = 409
+    } else {
+        // Send the original response again, with the version it gave
+        if len(ikey.ETag) != 0 {
+            w.Header().Set("ETag", ikey.ETag)
+        }
+        w.Header().Set("Idempotent-Replayed", "true")
+        w.WriteHeader(ikey.Status)
+        fmt.Fprintf(w, "%s", ikey.Response)
+        return nil
+    }
+
+    jresp, _ := json.Marshal(resp)
+
+    // Write error + payload
+    w.WriteHeader(status)
+    fmt.Fprintf(w, "%s", jresp)
+    return nil
+}
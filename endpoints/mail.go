@@ -0,0 +1,118 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // MailEndpoint represents the controller for the address mail can be sent
+    // to for it to become a todo
+    MailEndpoint struct {}
+
+    // Address endpoint
+    MailEndpointAddressRequest struct {
+        // Make up a new address, so that mail sent to the old one is no longer
+        // accepted
+        Reset       bool        `json:"reset"`
+        Auth        string      `json:"authority"`
+    }
+    MailEndpointAddressResponse struct {
+        Error       string      `json:"error,omitempty"`
+        Address     string      `json:"address,omitempty"`
+    }
+)
+
+// Domain of the addresses mail is received at, "" if mail isn't received
+var MailDomain = ""
+
+func NewMailEndpoint() *MailEndpoint {
+    return &MailEndpoint{}
+}
+
+func (me MailEndpoint) Address(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var mear MailEndpointAddressRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&mear)
+
+    // Check for errors
+    if err != nil || len(mear.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  mear.Auth,
+    }
+
+    // Check the privileges on the auth token; the address is as good as a
+    // token that can create todos
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := MailEndpointAddressResponse{
+            Error: "Authorization token lacks privilege to see the mail address",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if len(MailDomain) == 0 {
+        resp := MailEndpointAddressResponse{
+            Error: "Mail is not enabled on this server",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(404)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Users get an address the first time they ask for one
+    key := models.MailKey{
+        UserId: auth.OwnerId,
+    }
+    if (mear.Reset || !key.ReadValues()) && !key.Reset() {
+        // Database error
+        resp := MailEndpointAddressResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := MailEndpointAddressResponse{
+        Address: key.Key + "@" + MailDomain,
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
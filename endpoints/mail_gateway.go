@@ -0,0 +1,219 @@
+package endpoints
+
+import (
+    // stdlib
+    "bytes"
+    "io"
+    "log"
+    "mime"
+    "net/http"
+    "strings"
+
+    // SMTP server
+    "github.com/emersion/go-smtp"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Receives mail and turns it into todos
+    MailBackend struct{}
+
+    // A conversation with a client sending mail. Mail is either sent by a
+    // user logged in with a token, or to the secret address of users.
+    mailSession struct {
+        auth        *models.Token
+        recipients  []*models.Token
+    }
+)
+
+// Create a new backend for the SMTP server
+func NewMailBackend() *MailBackend {
+    return &MailBackend{}
+}
+
+// Errors told to clients sending mail
+var (
+    mailErrAuth = &smtp.SMTPError{
+        Code:           535,
+        EnhancedCode:   smtp.EnhancedCode{5, 7, 8},
+        Message:        "Authentication failed",
+    }
+    mailErrRecipient = &smtp.SMTPError{
+        Code:           550,
+        EnhancedCode:   smtp.EnhancedCode{5, 1, 1},
+        Message:        "No such recipient",
+    }
+    mailErrNotAllowed = &smtp.SMTPError{
+        Code:           550,
+        EnhancedCode:   smtp.EnhancedCode{5, 7, 1},
+        Message:        "Recipient can't receive todos",
+    }
+    mailErrNoRecipient = &smtp.SMTPError{
+        Code:           554,
+        EnhancedCode:   smtp.EnhancedCode{5, 5, 1},
+        Message:        "No valid recipients",
+    }
+    mailErrMessage = &smtp.SMTPError{
+        Code:           554,
+        EnhancedCode:   smtp.EnhancedCode{5, 6, 0},
+        Message:        "Message could not be read",
+    }
+    mailErrDatabase = &smtp.SMTPError{
+        Code:           451,
+        EnhancedCode:   smtp.EnhancedCode{4, 3, 0},
+        Message:        "Database error, try again later",
+    }
+)
+
+// Log in with the name of a user and one of their tokens as the password. Any
+// token that can create todos will do, tertiary ones being meant for this.
+func (be *MailBackend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+    auth := models.Token{
+        Value:  password,
+    }
+    if !auth.ReadValues() || auth.Type > 3 {
+        return nil, mailErrAuth
+    }
+    profile := models.Profile{
+        UserId: auth.OwnerId,
+    }
+    if !profile.ReadValues() || profile.Name != username {
+        return nil, mailErrAuth
+    }
+
+    return &mailSession{auth: &auth}, nil
+}
+
+// Mail without logging in can only be sent to the secret address of a user
+func (be *MailBackend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+    return &mailSession{}, nil
+}
+
+// Forget about the mail so far
+func (s *mailSession) Reset() {
+    s.recipients = nil
+}
+
+func (s *mailSession) Logout() error {
+    return nil
+}
+
+// Anyone can send mail
+func (s *mailSession) Mail(from string, opts smtp.MailOptions) error {
+    return nil
+}
+
+// Work out whose todo a mail becomes. Mail from a logged in user becomes their
+// own todo, whatever address it is sent to. Recipients who can't be given a
+// todo are refused right away.
+func (s *mailSession) Rcpt(to string) error {
+    var auth *models.Token
+    if s.auth != nil {
+        auth = s.auth
+    } else {
+        at := strings.LastIndex(to, "@")
+        if at <= 0 || !strings.EqualFold(to[at + 1:], MailDomain) {
+            return mailErrRecipient
+        }
+        key := models.MailKey{
+            Key:    strings.ToLower(to[:at]),
+        }
+        if !key.ReadValues() {
+            return mailErrRecipient
+        }
+        // Mail to the secret address is as good as a tertiary token
+        auth = &models.Token{
+            Type:       3,
+            OwnerId:    key.UserId,
+        }
+    }
+
+    board_id := 0
+    if reason := checkNewOnBoard(&board_id, auth); len(reason) != 0 {
+        log.Printf("Info: Mail not accepted for user %d: %s", auth.OwnerId, reason)
+        return mailErrNotAllowed
+    }
+
+    for _, recipient := range s.recipients {
+        if recipient.OwnerId == auth.OwnerId {
+            return nil
+        }
+    }
+    s.recipients = append(s.recipients, auth)
+    return nil
+}
+
+// Turn the mail into a todo for every recipient. The todos of the recipients
+// that went through are there to stay, so the mail is only refused, and sent
+// again, if it went through for none of them.
+func (s *mailSession) Data(r io.Reader) error {
+    if len(s.recipients) == 0 {
+        return mailErrNoRecipient
+    }
+    msg, err := readMailMessage(r)
+    if err != nil {
+        log.Printf("Info: Failed to read mail: %s", err)
+        return mailErrMessage
+    }
+
+    delivered := false
+    for _, auth := range s.recipients {
+        if createMailTodo(msg, auth) {
+            delivered = true
+        } else {
+            log.Printf("Warning: Mail not delivered to user %d", auth.OwnerId)
+        }
+    }
+    if !delivered {
+        return mailErrDatabase
+    }
+    return nil
+}
+
+// Create the todo a mail becomes for the owner of a token, with the files
+// attached to the mail. Returns false if the todo couldn't be created.
+func createMailTodo(msg *mailMessage, auth *models.Token) bool {
+    // New todos go on the board of the token, if it is limited to one
+    board_id := 0
+    if reason := checkNewOnBoard(&board_id, auth); len(reason) != 0 {
+        // Things changed since the recipient was accepted
+        log.Printf("Info: Mail not delivered to user %d: %s", auth.OwnerId, reason)
+        return false
+    }
+
+    todo := mailTodo(msg, auth, board_id)
+    todo.NormalizeTags()
+    if reason := todo.Validate(); len(reason) != 0 {
+        log.Printf("Info: Mail not delivered to user %d: %s", auth.OwnerId, reason)
+        return false
+    }
+    todo.Editor = auth
+    if !todo.InsertValues() {
+        return false
+    }
+
+    // Files that couldn't be uploaded aren't kept either. The todo is there
+    // now, so a file that fails to be saved doesn't fail the mail, which would
+    // have it sent again.
+    for _, file := range msg.Attachments {
+        size := int64(len(file.Data))
+        mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(file.Data))
+        if size > models.MaxAttachmentSize || !models.AttachmentTypeAllowed(mimeType) {
+            log.Printf("Info: Attachment `%s` of type %s not kept for todo %d", file.Name, mimeType, todo.Id)
+            continue
+        }
+        attachment := models.Attachment{
+            TodoId:     todo.Id,
+            UploaderId: auth.OwnerId,
+            Name:       file.Name,
+            MimeType:   mimeType,
+            Size:       size,
+        }
+        if !attachment.InsertValues(bytes.NewReader(file.Data)) {
+            log.Printf("Warning: Failed to save attachment `%s` for todo %d", file.Name, todo.Id)
+        }
+    }
+    return true
+}
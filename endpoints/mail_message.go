@@ -0,0 +1,269 @@
+package endpoints
+
+import (
+    // stdlib
+    "encoding/base64"
+    "html"
+    "io"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net/mail"
+    "net/textproto"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // What matters about a mail that becomes a todo
+    mailMessage struct {
+        From        string
+        Subject     string
+        // The text of the mail, the plain text version if there is one
+        Body        string
+        plain       bool
+        Attachments []mailAttachment
+    }
+
+    // A file attached to a mail
+    mailAttachment struct {
+        Name        string
+        Data        []byte
+    }
+)
+
+// How deep multipart mails are looked into
+const maxMailDepth = 5
+
+// Longest text of a mail kept, in bytes
+const maxMailText = 64 << 10
+
+// What mail clients put in front of the subject of forwarded mail and replies
+var mailSubjectPrefix = regexp.MustCompile(`(?i)^\s*((fwd?|fw|re|aw|wg)\s*:\s*)+`)
+
+// Tags and line breaks in HTML mail
+var (
+    mailHtmlBreak = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/li|/h[1-6]|/tr)\b[^>]*>`)
+    mailHtmlDrop = regexp.MustCompile(`(?is)<\s*(style|script|head)\b.*?<\s*/\s*(style|script|head)\s*>`)
+    mailHtmlTag = regexp.MustCompile(`(?s)<[^>]*>`)
+    mailBlankLines = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// Decodes the encoded words in headers
+var mailWordDecoder = &mime.WordDecoder{
+    CharsetReader: mailCharsetReader,
+}
+
+// Turn text in the charsets mail commonly comes in into UTF-8. Text in other
+// charsets is taken as it is.
+func mailCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+    switch strings.ToLower(charset) {
+    case "iso-8859-1", "latin1", "windows-1252", "us-ascii":
+        data, err := io.ReadAll(input)
+        if err != nil {
+            return nil, err
+        }
+        runes := make([]rune, len(data))
+        for i, b := range data {
+            runes[i] = rune(b)
+        }
+        return strings.NewReader(string(runes)), nil
+    }
+    return input, nil
+}
+
+// Read in a mail, picking out its text and attachments
+func readMailMessage(r io.Reader) (*mailMessage, error) {
+    m, err := mail.ReadMessage(r)
+    if err != nil {
+        return nil, err
+    }
+
+    msg := &mailMessage{}
+    msg.Subject, err = mailWordDecoder.DecodeHeader(m.Header.Get("Subject"))
+    if err != nil {
+        msg.Subject = m.Header.Get("Subject")
+    }
+    if from, err := m.Header.AddressList("From"); err == nil && len(from) > 0 {
+        msg.From = from[0].Name
+        if len(msg.From) == 0 {
+            msg.From = from[0].Address
+        }
+    }
+
+    err = msg.readPart(textproto.MIMEHeader(m.Header), m.Body, 0)
+    if err != nil {
+        return nil, err
+    }
+    msg.Body = strings.TrimSpace(msg.Body)
+    return msg, nil
+}
+
+// Read in a part of a mail, and the parts nested in it
+func (msg *mailMessage) readPart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+    mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+    if err != nil {
+        mediaType = "text/plain"
+    }
+    switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+    case "base64":
+        body = base64.NewDecoder(base64.StdEncoding, body)
+    case "quoted-printable":
+        body = quotedprintable.NewReader(body)
+    }
+
+    if strings.HasPrefix(mediaType, "multipart/") {
+        if depth >= maxMailDepth {
+            return nil
+        }
+        mr := multipart.NewReader(body, params["boundary"])
+        for {
+            part, err := mr.NextRawPart()
+            if err == io.EOF {
+                return nil
+            }
+            if err != nil {
+                return err
+            }
+            err = msg.readPart(part.Header, part, depth + 1)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    // Files are attachments, whatever type they are
+    disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+    name := dparams["filename"]
+    if len(name) == 0 {
+        name = params["name"]
+    }
+    if decoded, err := mailWordDecoder.DecodeHeader(name); err == nil {
+        name = decoded
+    }
+    isText := mediaType == "text/plain" || mediaType == "text/html"
+    if disposition == "attachment" || !isText {
+        data, err := io.ReadAll(io.LimitReader(body, models.MaxAttachmentSize + 1))
+        if err != nil {
+            return err
+        }
+        if len(name) == 0 {
+            name = "attachment"
+        }
+        msg.Attachments = append(msg.Attachments, mailAttachment{
+            Name:   filepath.Base(name),
+            Data:   data,
+        })
+        return nil
+    }
+    if mediaType == "text/html" && len(msg.Body) != 0 {
+        // Already have the text
+        return nil
+    }
+
+    text, err := mailCharsetReader(params["charset"], io.LimitReader(body, maxMailText))
+    if err != nil {
+        return err
+    }
+    data, err := io.ReadAll(text)
+    if err != nil {
+        return err
+    }
+    if mediaType == "text/html" {
+        msg.Body = mailHtmlToText(string(data))
+    } else if msg.plain {
+        // More text after an attachment
+        msg.Body += "\n\n" + string(data)
+    } else {
+        msg.Body = string(data)
+        msg.plain = true
+    }
+    return nil
+}
+
+// Make the text of an HTML mail readable
+func mailHtmlToText(s string) string {
+    s = mailHtmlDrop.ReplaceAllString(s, "")
+    s = mailHtmlBreak.ReplaceAllString(s, "\n")
+    s = mailHtmlTag.ReplaceAllString(s, "")
+    s = html.UnescapeString(s)
+    s = strings.ReplaceAll(s, "\r", "")
+    return mailBlankLines.ReplaceAllString(s, "\n\n")
+}
+
+// Work out when something is due from a due: hint, which is a date, a date and
+// time, today, tomorrow or a day of the week, in the time zone of now. Things
+// without a time are due at 9 in the morning.
+func parseMailDue(value string, now time.Time) (time.Time, bool) {
+    name := strings.ToLower(value)
+    morning := func(t time.Time) time.Time {
+        return time.Date(t.Year(), t.Month(), t.Day(), 9, 0, 0, 0, now.Location())
+    }
+
+    switch name {
+    case "today":
+        return morning(now), true
+    case "tomorrow":
+        return morning(now.AddDate(0, 0, 1)), true
+    }
+    for day := 1; day <= 7; day++ {
+        next := now.AddDate(0, 0, day)
+        weekday := strings.ToLower(next.Weekday().String())
+        if name == weekday || name == weekday[:3] {
+            return morning(next), true
+        }
+    }
+    if t, err := time.ParseInLocation("2006-01-02T15:04", value, now.Location()); err == nil {
+        return t, true
+    }
+    if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+        return morning(t), true
+    }
+    return time.Time{}, false
+}
+
+// Make up the todo a mail becomes for the owner of a token. The subject is its
+// name, without the #tag and due: hints in it, which tag the todo and set when
+// it is due, cut short if it is too long for a name. The text of the mail is its
+// description.
+func mailTodo(msg *mailMessage, auth *models.Token, board_id int) models.Todo {
+    todo := models.Todo{
+        State:      models.StateIdeas,
+        OwnerId:    auth.OwnerId,
+        BoardId:    board_id,
+        Desc:       msg.Body,
+    }
+
+    var words []string
+    for _, word := range strings.Fields(mailSubjectPrefix.ReplaceAllString(msg.Subject, "")) {
+        if strings.HasPrefix(word, "#") && len(word) > 1 {
+            if tag := models.FindTagByPath(auth.OwnerId, board_id, word[1:]); tag != nil {
+                todo.TagIds = append(todo.TagIds, tag.Id)
+                continue
+            }
+        }
+        if strings.HasPrefix(strings.ToLower(word), "due:") {
+            // Users have no time zone of their own, so like todos that recur
+            // without one, hints are taken to be in UTC
+            if due, ok := parseMailDue(word[4:], time.Now().UTC()); ok {
+                todo.DueDate = due
+                continue
+            }
+        }
+        words = append(words, word)
+    }
+    todo.Name = strings.Join(words, " ")
+    if len(todo.Name) == 0 {
+        todo.Name = "Mail from " + msg.From
+    }
+    if utf8.RuneCountInString(todo.Name) > models.MaxTodoName {
+        todo.Name = string([]rune(todo.Name)[:models.MaxTodoName])
+    }
+    return todo
+}
@@ -0,0 +1,98 @@
+package endpoints
+
+import (
+    // stdlib
+    "strings"
+    "testing"
+    "time"
+    "unicode/utf8"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+func TestParseMailDue(t *testing.T) {
+    // A Wednesday afternoon
+    now := time.Date(2023, 4, 5, 15, 0, 0, 0, time.UTC)
+    paris, err := time.LoadLocation("Europe/Paris")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        value   string
+        now     time.Time
+        want    time.Time
+        ok      bool
+    }{
+        {"today", now, time.Date(2023, 4, 5, 9, 0, 0, 0, time.UTC), true},
+        {"Tomorrow", now, time.Date(2023, 4, 6, 9, 0, 0, 0, time.UTC), true},
+        {"friday", now, time.Date(2023, 4, 7, 9, 0, 0, 0, time.UTC), true},
+        {"FRI", now, time.Date(2023, 4, 7, 9, 0, 0, 0, time.UTC), true},
+        {"mon", now, time.Date(2023, 4, 10, 9, 0, 0, 0, time.UTC), true},
+        // The same day of the week is a week away
+        {"wednesday", now, time.Date(2023, 4, 12, 9, 0, 0, 0, time.UTC), true},
+        {"2023-04-01", now, time.Date(2023, 4, 1, 9, 0, 0, 0, time.UTC), true},
+        {"2023-04-01T14:30", now, time.Date(2023, 4, 1, 14, 30, 0, 0, time.UTC), true},
+        // Across the end of the month and the year
+        {"tomorrow", time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), true},
+        // In the time zone of now
+        {"today", now.In(paris), time.Date(2023, 4, 5, 9, 0, 0, 0, paris), true},
+        {"2023-04-01T14:30", now.In(paris), time.Date(2023, 4, 1, 14, 30, 0, 0, paris), true},
+        {"", now, time.Time{}, false},
+        {"soon", now, time.Time{}, false},
+        {"fr", now, time.Time{}, false},
+        {"2023-13-01", now, time.Time{}, false},
+        {"2023-04-01 14:30", now, time.Time{}, false},
+    }
+    for _, test := range tests {
+        got, ok := parseMailDue(test.value, test.now)
+        if ok != test.ok || !got.Equal(test.want) {
+            t.Errorf("parseMailDue(%q, %s) = %s, %v, want %s, %v", test.value, test.now, got, ok, test.want, test.ok)
+        }
+    }
+}
+
+// due: hints in the subject are read in UTC, whatever time zone the server is
+// in
+func TestMailTodoDue(t *testing.T) {
+    local := time.Local
+    defer func() {
+        time.Local = local
+    }()
+    time.Local = time.FixedZone("UTC-8", -8 * 60 * 60)
+
+    msg := mailMessage{
+        From:       "someone@example.com",
+        Subject:    "Re: Fwd: Pay rent due:2030-05-01",
+        Body:       "Before the 1st",
+    }
+    todo := mailTodo(&msg, &models.Token{Type: 3, OwnerId: 1}, 0)
+    if todo.Name != "Pay rent" || todo.Desc != "Before the 1st" || todo.State != models.StateIdeas || todo.OwnerId != 1 {
+        t.Errorf("mail became %+v", todo)
+    }
+    if want := time.Date(2030, 5, 1, 9, 0, 0, 0, time.UTC); !todo.DueDate.Equal(want) {
+        t.Errorf("mail is due %s, want %s", todo.DueDate, want)
+    }
+
+    // Hints that aren't understood stay in the name
+    msg.Subject = "due:someday"
+    todo = mailTodo(&msg, &models.Token{Type: 3, OwnerId: 1}, 0)
+    if todo.Name != "due:someday" || !todo.DueDate.IsZero() {
+        t.Errorf("mail with an unknown hint became %+v", todo)
+    }
+}
+
+// Subjects too long for a name are cut short, without splitting characters
+func TestMailTodoLongSubject(t *testing.T) {
+    msg := mailMessage{
+        Subject:    strings.Repeat("é", models.MaxTodoName + 10),
+    }
+    todo := mailTodo(&msg, &models.Token{Type: 3, OwnerId: 1}, 0)
+    if todo.Name != strings.Repeat("é", models.MaxTodoName) {
+        t.Errorf("name of %d characters, want %d", utf8.RuneCountInString(todo.Name), models.MaxTodoName)
+    }
+    if msg := todo.Validate(); len(msg) != 0 {
+        t.Errorf("mail became an invalid todo: %s", msg)
+    }
+}
@@ -0,0 +1,272 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // ProfileEndpoint represents the controller for operating on the Profile resource
+    ProfileEndpoint struct {}
+
+    // Info endpoint
+    ProfileEndpointInfoRequest struct {
+        Auth    string              `json:"authority"`
+    }
+    ProfileEndpointInfoResponse struct {
+        Error   string              `json:"error,omitempty"`
+        Profile *models.Profile     `json:"profile,omitempty"`
+    }
+
+    // Update endpoint
+    ProfileEndpointUpdateRequest struct {
+        Profile models.Profile      `json:"profile"`
+        Auth    string              `json:"authority"`
+    }
+
+    // Todos endpoint
+    ProfileEndpointTodosRequest struct {
+        Name    string              `json:"name"`
+    }
+    ProfileEndpointTodosResponse struct {
+        Error   string              `json:"error,omitempty"`
+        Profile *models.Profile     `json:"profile,omitempty"`
+        Columns []todoColumn        `json:"columns,omitempty"`
+    }
+)
+
+func NewProfileEndpoint() *ProfileEndpoint {
+    return &ProfileEndpoint{}
+}
+
+// Read the profile of a user that anyone can see. Hidden profiles can't be told
+// apart from unknown users.
+func readPublicProfile(profile *models.Profile) bool {
+    if !profile.ReadValues() || !profile.Public {
+        return false
+    }
+    profile.DisplayName = profile.ShownName()
+    return true
+}
+
+func (pe ProfileEndpoint) Info(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var peir ProfileEndpointInfoRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&peir)
+
+    // Check for errors
+    if err != nil || len(peir.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  peir.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := ProfileEndpointInfoResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    profile := models.Profile{
+        UserId: auth.OwnerId,
+    }
+    if !profile.ReadValues() {
+        // Database error
+        resp := ProfileEndpointInfoResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := ProfileEndpointInfoResponse{
+        Profile:    &profile,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (pe ProfileEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var peur ProfileEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&peur)
+
+    // Check for errors
+    if err != nil || len(peur.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  peur.Auth,
+    }
+
+    // Only the user themselves can change what is published about them
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := ProfileEndpointInfoResponse{
+            Error: "Authorization token lacks privilege to change profile",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    profile := models.Profile{
+        UserId: auth.OwnerId,
+    }
+    if !profile.ReadValues() {
+        // Database error
+        resp := ProfileEndpointInfoResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    profile.DisplayName = peur.Profile.DisplayName
+    profile.Public = peur.Profile.Public
+    if msg := profile.Validate(); len(msg) != 0 {
+        resp := ProfileEndpointInfoResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if !profile.WriteValues() {
+        // Database error
+        resp := ProfileEndpointInfoResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := ProfileEndpointInfoResponse{
+        Profile:    &profile,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (pe ProfileEndpoint) Todos(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var petr ProfileEndpointTodosRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&petr)
+
+    // The username may also be given in the query string
+    if name := r.URL.Query().Get("name"); len(name) != 0 {
+        petr.Name = name
+    }
+
+    profile := models.Profile{
+        Name:   petr.Name,
+    }
+    if len(profile.Name) == 0 || !readPublicProfile(&profile) {
+        resp := ProfileEndpointTodosResponse{
+            Error: "User not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(404)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := ProfileEndpointTodosResponse{
+        Profile:    &profile,
+        Columns:    todoColumns(profile.ListTodos()),
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (pe ProfileEndpoint) Page(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+    profile := models.Profile{
+        Name:   p.ByName("name"),
+    }
+    if !readPublicProfile(&profile) {
+        writeTodoPage(w, 404, &todoPage{
+            Error:  "This page doesn't exist",
+        })
+        return
+    }
+
+    writeTodoPage(w, 200, &todoPage{
+        Title:      fmt.Sprintf("What %s is working on", profile.DisplayName),
+        Columns:    todoColumns(profile.ListTodos()),
+    })
+}
@@ -0,0 +1,298 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // ShareLinkEndpoint represents the controller for operating on the ShareLink resource
+    ShareLinkEndpoint struct {}
+
+    // New endpoint
+    ShareLinkEndpointNewRequest struct {
+        Link    models.ShareLink    `json:"link"`
+        Auth    string              `json:"authority"`
+    }
+    ShareLinkEndpointNewResponse struct {
+        Error   string              `json:"error,omitempty"`
+        Link    *models.ShareLink   `json:"link,omitempty"`
+    }
+
+    // List endpoint
+    ShareLinkEndpointListRequest struct {
+        Auth    string              `json:"authority"`
+    }
+    ShareLinkEndpointListResponse struct {
+        Error   string              `json:"error,omitempty"`
+        Links   []models.ShareLink  `json:"links"`
+    }
+
+    // Revoke endpoint
+    ShareLinkEndpointRevokeRequest struct {
+        Link    models.ShareLink    `json:"link"`
+        Auth    string              `json:"authority"`
+    }
+)
+
+func NewShareLinkEndpoint() *ShareLinkEndpoint {
+    return &ShareLinkEndpoint{}
+}
+
+// Check whether a link can be managed with a token limited to a board: it has
+// to show a todo on that board, or only the todos on that board
+func shareLinkInScope(link *models.ShareLink, auth *models.Token) bool {
+    if auth.BoardId == 0 {
+        return true
+    }
+    if link.TodoId > 0 {
+        todo := models.Todo{
+            Id:     link.TodoId,
+        }
+        return todo.ReadPermissions() && todo.BoardId == auth.BoardId
+    }
+    return link.Filter.BoardId != nil && *link.Filter.BoardId == auth.BoardId
+}
+
+func (sle ShareLinkEndpoint) New(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var slnr ShareLinkEndpointNewRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&slnr)
+
+    // Check for errors
+    if err != nil || len(slnr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  slnr.Auth,
+    }
+    link := models.ShareLink{
+        TodoId:     slnr.Link.TodoId,
+        Password:   slnr.Link.Password,
+        ExpiresAt:  slnr.Link.ExpiresAt,
+    }
+    if link.TodoId > 0 {
+        // Only the owner of a todo can hand out links to it
+        todo := models.Todo{
+            Id:     link.TodoId,
+        }
+        if !readOwnTodo(w, &todo, &auth) {
+            return
+        }
+    } else {
+        // A list of todos needs a token that can see them
+        if !auth.ReadValues() || auth.Type > 2 {
+            // User not authorized
+            resp := ShareLinkEndpointNewResponse{
+                Error: "Authorization token lacks modification privilege",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+        link.TodoId = 0
+        link.Filter = slnr.Link.Filter
+        scopeFilter(&link.Filter, &auth)
+
+        // Only those who run a board can show its todos to anyone
+        board_id := link.Filter.BoardId
+        if board_id != nil && *board_id != 0 && !models.RoleAtLeast(models.BoardRoleOf(*board_id, auth.OwnerId), models.RoleAdmin) {
+            resp := ShareLinkEndpointNewResponse{
+                Error: "User's role on board is not admin",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+    }
+
+    // A link that has already stopped working is of no use
+    if link.Expired() {
+        resp := ShareLinkEndpointNewResponse{
+            Error: "Link would already have expired",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything looks good! Make up the link
+    link.OwnerId = auth.OwnerId
+    if !link.InsertValues() {
+        // Database error
+        resp := ShareLinkEndpointNewResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := ShareLinkEndpointNewResponse{
+        Link:   &link,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (sle ShareLinkEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var sllr ShareLinkEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&sllr)
+
+    // The token may also be given in the query string
+    if authority := r.URL.Query().Get("authority"); len(authority) != 0 {
+        sllr.Auth = authority
+    }
+
+    auth := models.Token{
+        Value:  sllr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := ShareLinkEndpointListResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Read all the links, only the ones about the board of a token limited to
+    // one
+    resp := ShareLinkEndpointListResponse{
+        Links:  []models.ShareLink{},
+    }
+    for _, link := range models.ListShareLinks(auth.OwnerId) {
+        if shareLinkInScope(&link, &auth) {
+            resp.Links = append(resp.Links, link)
+        }
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (sle ShareLinkEndpoint) Revoke(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var slrr ShareLinkEndpointRevokeRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&slrr)
+
+    // Check for errors
+    if err != nil || len(slrr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  slrr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Only the owner of a link can revoke it
+    link := models.ShareLink{
+        Id:     slrr.Link.Id,
+    }
+    if !link.ReadValues() || link.OwnerId != auth.OwnerId || !shareLinkInScope(&link, &auth) {
+        resp := errorResponse{
+            Error: "Link not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if !link.Remove() {
+        // Database error
+        resp := errorResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := errorResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
@@ -0,0 +1,79 @@
+package endpoints
+
+import (
+    // stdlib
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+func (sle ShareLinkEndpoint) View(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    // Keep the page, and the address it was opened from, to whoever opened it
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Robots-Tag", "noindex")
+    w.Header().Set("Referrer-Policy", "no-referrer")
+
+    // Unknown, revoked and expired links all look the same
+    link := models.ShareLink{
+        Slug:   p.ByName("slug"),
+    }
+    if !link.ReadValues() || link.Expired() {
+        writeTodoPage(w, 404, &todoPage{
+            Error:  "This link doesn't exist or has expired",
+        })
+        return
+    }
+
+    // Ask for the password first, if there is one
+    if link.HasPassword {
+        password := ""
+        if r.Method == "POST" {
+            password = r.PostFormValue("password")
+        }
+        if len(password) == 0 {
+            writeTodoPage(w, 401, &todoPage{
+                AskPassword:    true,
+            })
+            return
+        }
+        if !link.CheckPassword(password) {
+            writeTodoPage(w, 403, &todoPage{
+                AskPassword:    true,
+                WrongPassword:  true,
+            })
+            return
+        }
+    }
+
+    // The link shows what its owner can still see
+    owner := models.Token{
+        OwnerId:    link.OwnerId,
+    }
+    if link.TodoId > 0 {
+        todo := models.Todo{
+            Id:     link.TodoId,
+        }
+        if !todo.ReadValues() || todo.DeletedAt != nil || todo.AccessOf(&owner) < models.AccessRead {
+            writeTodoPage(w, 404, &todoPage{
+                Error:  "This link doesn't exist or has expired",
+            })
+            return
+        }
+        writeTodoPage(w, 200, &todoPage{
+            Todo:   &todo,
+        })
+        return
+    }
+
+    // Only the todos the owner owns are listed, not the others they can see
+    filter := link.Filter
+    filter.OwnOnly = true
+    writeTodoPage(w, 200, &todoPage{
+        Columns:    todoColumns(models.ListTodos(link.OwnerId, filter)),
+    })
+}
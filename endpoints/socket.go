@@ -0,0 +1,444 @@
+package endpoints
+
+import (
+    // stdlib
+    "bytes"
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "time"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // WebSockets
+    "github.com/gorilla/websocket"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // SocketEndpoint represents the controller for WebSocket connections
+    SocketEndpoint struct {}
+
+    // A message sent by the client
+    SocketEndpointMessage struct {
+        // Sent back in the response, to tell which message it is for
+        Id          json.RawMessage     `json:"id,omitempty"`
+        // One of subscribe, unsubscribe, presence, lock, unlock or request
+        Type        string              `json:"type"`
+        // subscribe: only todos on this board, 0 for personal todos
+        BoardId     *int                `json:"board_id,omitempty"`
+        // subscribe: resume after this event instead of starting from now
+        LastEventId *int                `json:"last_event_id,omitempty"`
+        // presence, lock and unlock: the todo
+        TodoId      int                 `json:"todo_id,omitempty"`
+        // presence: viewing, editing, or "" when leaving the todo
+        Activity    string              `json:"activity,omitempty"`
+        // request: which HTTP endpoint to call, such as todo/update
+        Method      string              `json:"method,omitempty"`
+        // request: the JSON body for the endpoint, without the authority
+        Body        json.RawMessage     `json:"body,omitempty"`
+        // request: the If-Match and Idempotency-Key headers for the endpoint
+        Headers     map[string]string   `json:"headers,omitempty"`
+    }
+
+    // The answer to a message sent by the client
+    SocketEndpointResponse struct {
+        Id          json.RawMessage     `json:"id,omitempty"`
+        Type        string              `json:"type"`
+        Error       string              `json:"error,omitempty"`
+        // HTTP status code of the answer
+        Status      int                 `json:"status"`
+        // request: the JSON reply of the endpoint
+        Body        json.RawMessage     `json:"body,omitempty"`
+    }
+
+    // A change to a todo, sent to subscribed clients
+    SocketEndpointEvent struct {
+        Type        string              `json:"type"`
+        EventId     int                 `json:"event_id"`
+        // One of the kinds of events of the todo stream
+        Event       string              `json:"event"`
+        StreamEndpointTodoEvent
+    }
+
+    // An open WebSocket connection
+    socketConn struct {
+        ws          *websocket.Conn
+        // Token the connection was opened with, never changed afterwards
+        auth        models.Token
+        // Name of the token's owner, as shown to others
+        name        string
+        // Messages waiting to be written
+        send        chan interface{}
+        done        chan struct{}
+        closeOnce   sync.Once
+
+        // What the client is subscribed to, guarded by lock
+        lock        sync.Mutex
+        subscribed  bool
+        boardId     *int
+        lastEventId int
+    }
+
+    // Collects the reply of an HTTP endpoint called over a connection
+    socketResponseWriter struct {
+        header      http.Header
+        status      int
+        body        bytes.Buffer
+    }
+)
+
+// How long to wait for the client to answer a ping before giving up on it
+var socketPongWait = 60 * time.Second
+
+// How long writing a message may take
+var socketWriteWait = 10 * time.Second
+
+// Largest message accepted from clients, in bytes
+const socketMaxMessage = 1 << 20
+
+// How many messages can be waiting to be written before a client is considered
+// too slow and disconnected
+const socketSendQueue = 256
+
+// The HTTP endpoints that can be called over a connection, which check the
+// token of the connection like they would over HTTP
+var socketMethods = map[string]httprouter.Handle{
+    "todo/update":      TodoEndpoint{}.Update,
+    "todo/patch":       TodoEndpoint{}.Patch,
+    "todo/remove":      TodoEndpoint{}.Remove,
+    "todo/info":        TodoEndpoint{}.Info,
+    "todo/move":        TodoEndpoint{}.Move,
+    "todo/assign":      TodoEndpoint{}.Assign,
+    "todos/list":       TodosEndpoint{}.List,
+    "todos/batch":      TodosEndpoint{}.Batch,
+    "comment/list":     CommentEndpoint{}.List,
+    "comment/update":   CommentEndpoint{}.Update,
+    "comment/remove":   CommentEndpoint{}.Remove,
+}
+
+// The headers of an HTTP endpoint that can be given with a request
+var socketHeaders = []string{"If-Match", "Idempotency-Key"}
+
+var socketUpgrader = websocket.Upgrader{
+    // The web client is served from elsewhere, and the token is given
+    // explicitly rather than by a cookie, so any origin is fine
+    CheckOrigin:    func(r *http.Request) bool {
+        return true
+    },
+}
+
+func NewSocketEndpoint() *SocketEndpoint {
+    return &SocketEndpoint{}
+}
+
+func (w *socketResponseWriter) Header() http.Header {
+    return w.header
+}
+
+func (w *socketResponseWriter) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.status = 200
+    }
+    return w.body.Write(b)
+}
+
+func (w *socketResponseWriter) WriteHeader(status int) {
+    if w.status == 0 {
+        w.status = status
+    }
+}
+
+// Queue a message to be written to the client. Clients that can't keep up are
+// disconnected.
+func (c *socketConn) queue(msg interface{}) {
+    select {
+    case c.send <- msg:
+    case <-c.done:
+    default:
+        c.close()
+    }
+}
+
+// Close the connection, letting go of everything it holds
+func (c *socketConn) close() {
+    c.closeOnce.Do(func() {
+        close(c.done)
+        c.ws.Close()
+    })
+}
+
+// Reply to a message from the client
+func (c *socketConn) reply(msg *SocketEndpointMessage, status int, message string) {
+    c.queue(&SocketEndpointResponse{
+        Id:     msg.Id,
+        Type:   "response",
+        Status: status,
+        Error:  message,
+    })
+}
+
+// Write out the queued messages, and ping the client every now and then
+func (c *socketConn) writeForever() {
+    ping := time.NewTicker(socketPongWait * 9 / 10)
+    defer ping.Stop()
+    defer c.close()
+
+    for {
+        select {
+        case msg := <-c.send:
+            c.ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+            if c.ws.WriteJSON(msg) != nil {
+                return
+            }
+        case <-ping.C:
+            if c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)) != nil {
+                return
+            }
+        case <-c.done:
+            return
+        }
+    }
+}
+
+// Send the changes to todos the client is subscribed to that were recorded
+// since the last ones sent
+func (c *socketConn) sendEvents() {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    if !c.subscribed {
+        return
+    }
+    for {
+        events := models.ListTodoEventsSince(c.lastEventId, streamBatchSize)
+        for i := range events {
+            event := &events[i]
+            c.lastEventId = event.Id
+
+            if c.boardId != nil && event.Todo.BoardId != *c.boardId {
+                continue
+            }
+            kind := streamEventKind(event, &c.auth)
+            if len(kind) == 0 {
+                continue
+            }
+            msg := SocketEndpointEvent{
+                Type:       "event",
+                EventId:    event.Id,
+                Event:      kind,
+                StreamEndpointTodoEvent: StreamEndpointTodoEvent{
+                    TodoId:     event.TodoId,
+                    UserId:     event.UserId,
+                    UserName:   event.UserName,
+                },
+            }
+            if kind != StreamDeleted {
+                msg.Todo = &event.Todo
+            }
+            c.queue(&msg)
+        }
+        if len(events) < streamBatchSize {
+            return
+        }
+    }
+}
+
+// Follow the changes to todos for as long as the connection is open, and close
+// it if its token is invalidated
+func (c *socketConn) followForever() {
+    sub := models.SubscribeTodoEvents()
+    defer sub.Close()
+    keepAlive := time.NewTicker(streamKeepAlive)
+    defer keepAlive.Stop()
+
+    for {
+        select {
+        case <-sub.C:
+            c.sendEvents()
+        case <-keepAlive.C:
+            check := models.Token{
+                Value:  c.auth.Value,
+            }
+            if !check.ReadValues() {
+                c.close()
+                return
+            }
+        case <-c.done:
+            return
+        }
+    }
+}
+
+// Start or change the subscription to changes to todos
+func (c *socketConn) subscribe(msg *SocketEndpointMessage) {
+    board := msg.BoardId
+    if c.auth.BoardId > 0 {
+        // Tokens limited to a board only see the changes on that board
+        if board != nil && *board != c.auth.BoardId {
+            c.reply(msg, 403, "Authorization token is limited to another board")
+            return
+        }
+        board = &c.auth.BoardId
+    }
+    if board != nil && *board > 0 && !models.ValidRole(models.BoardRoleOf(*board, c.auth.OwnerId)) {
+        c.reply(msg, 400, "Board not found in database")
+        return
+    }
+
+    c.lock.Lock()
+    c.subscribed = true
+    c.boardId = board
+    if msg.LastEventId != nil {
+        c.lastEventId = *msg.LastEventId
+    } else {
+        c.lastEventId = models.LatestTodoEventId()
+    }
+    c.lock.Unlock()
+
+    c.reply(msg, 200, "")
+    // Catch up when resuming
+    c.sendEvents()
+}
+
+// Call one of the HTTP endpoints with the token of the connection
+func (c *socketConn) request(msg *SocketEndpointMessage) {
+    handle, ok := socketMethods[msg.Method]
+    if !ok {
+        c.reply(msg, 400, "Unknown method")
+        return
+    }
+
+    // The endpoint gets the token of the connection, whatever the body says
+    body := make(map[string]json.RawMessage)
+    if len(msg.Body) != 0 && json.Unmarshal(msg.Body, &body) != nil {
+        c.reply(msg, 400, "Body is not a JSON object")
+        return
+    }
+    body["authority"], _ = json.Marshal(c.auth.Value)
+    jbody, _ := json.Marshal(body)
+
+    r, err := http.NewRequest("POST", "/api/" + msg.Method, bytes.NewReader(jbody))
+    if err != nil {
+        c.reply(msg, 400, "Unknown method")
+        return
+    }
+    r.Header.Set("Content-Type", "application/json")
+    for _, name := range socketHeaders {
+        if value, ok := msg.Headers[name]; ok {
+            r.Header.Set(name, value)
+        }
+    }
+
+    w := socketResponseWriter{
+        header: make(http.Header),
+    }
+    handle(&w, r, nil)
+    if w.status == 0 {
+        w.status = 200
+    }
+
+    resp := SocketEndpointResponse{
+        Id:     msg.Id,
+        Type:   "response",
+        Status: w.status,
+    }
+    if json.Valid(w.body.Bytes()) {
+        resp.Body = w.body.Bytes()
+    }
+    c.queue(&resp)
+}
+
+// Act on a message from the client
+func (c *socketConn) handle(msg *SocketEndpointMessage) {
+    switch msg.Type {
+    case "subscribe":
+        c.subscribe(msg)
+    case "unsubscribe":
+        c.lock.Lock()
+        c.subscribed = false
+        c.lock.Unlock()
+        c.reply(msg, 200, "")
+    case "presence":
+        c.presence(msg)
+    case "lock":
+        c.softLock(msg)
+    case "unlock":
+        c.softUnlock(msg)
+    case "request":
+        c.request(msg)
+    default:
+        c.reply(msg, 400, "Unknown message type")
+    }
+}
+
+func (se SocketEndpoint) Connect(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    // Browsers can only give the token in the query string
+    auth := models.Token{
+        Value:  r.URL.Query().Get("authority"),
+    }
+
+    // Check the privileges on the auth token
+    if len(auth.Value) == 0 || !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Others see the display name of the user
+    profile := models.Profile{
+        UserId: auth.OwnerId,
+    }
+    profile.ReadValues()
+
+    ws, err := socketUpgrader.Upgrade(w, r, nil)
+    if err != nil {
+        // The upgrader already replied
+        return
+    }
+
+    c := &socketConn{
+        ws:     ws,
+        auth:   auth,
+        name:   profile.ShownName(),
+        send:   make(chan interface{}, socketSendQueue),
+        done:   make(chan struct{}),
+    }
+    socketConnected(c)
+    defer socketDisconnected(c)
+    defer c.close()
+    go c.writeForever()
+    go c.followForever()
+
+    // Read messages until the client goes away
+    ws.SetReadLimit(socketMaxMessage)
+    ws.SetReadDeadline(time.Now().Add(socketPongWait))
+    ws.SetPongHandler(func(string) error {
+        return ws.SetReadDeadline(time.Now().Add(socketPongWait))
+    })
+    for {
+        _, data, err := ws.ReadMessage()
+        if err != nil {
+            return
+        }
+
+        var msg SocketEndpointMessage
+        if json.Unmarshal(data, &msg) != nil {
+            c.reply(&msg, 400, "Message is not valid JSON")
+            continue
+        }
+        c.handle(&msg)
+    }
+}
@@ -0,0 +1,264 @@
+package endpoints
+
+import (
+    // stdlib
+    "sync"
+    "time"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Someone looking at a todo, sent to everyone else who can see it
+    SocketEndpointPresence struct {
+        UserId      int     `json:"user_id"`
+        Name        string  `json:"name"`
+        // viewing or editing
+        Activity    string  `json:"activity"`
+        // Whether they hold the soft lock on the todo
+        Locked      bool    `json:"locked"`
+    }
+
+    // Who is looking at a todo right now
+    SocketEndpointPresenceMessage struct {
+        Type        string                      `json:"type"`
+        TodoId      int                         `json:"todo_id"`
+        Users       []SocketEndpointPresence    `json:"users"`
+    }
+
+    // The soft lock on a todo, held by whoever has it open for editing
+    socketLock struct {
+        conn        *socketConn
+        expires     time.Time
+        // Lets everyone know once the lock has expired
+        timer       *time.Timer
+    }
+)
+
+// What someone can be doing with a todo
+const (
+    ActivityViewing = "viewing"
+    ActivityEditing = "editing"
+)
+
+// How long a soft lock lasts unless it is taken again
+var socketLockLifetime = 2 * time.Minute
+
+var (
+    // All open connections
+    socketConns = make(map[*socketConn]bool)
+    // What each connection is doing with each todo
+    socketActivities = make(map[int]map[*socketConn]string)
+    // The soft locks on todos
+    socketLocks = make(map[int]*socketLock)
+    socketPresenceLock sync.Mutex
+)
+
+// Remember a new connection
+func socketConnected(c *socketConn) {
+    socketPresenceLock.Lock()
+    socketConns[c] = true
+    socketPresenceLock.Unlock()
+}
+
+// Forget a connection, along with what it was doing with todos
+func socketDisconnected(c *socketConn) {
+    socketPresenceLock.Lock()
+    delete(socketConns, c)
+    var changed []int
+    for todo_id, activities := range socketActivities {
+        if _, ok := activities[c]; ok {
+            delete(activities, c)
+            changed = append(changed, todo_id)
+        }
+    }
+    for todo_id, lock := range socketLocks {
+        if lock.conn == c {
+            lock.timer.Stop()
+            delete(socketLocks, todo_id)
+            changed = append(changed, todo_id)
+        }
+    }
+    socketPresenceLock.Unlock()
+
+    for _, todo_id := range changed {
+        broadcastPresence(todo_id)
+    }
+}
+
+// Find out who is looking at a todo, once per user. Must be called with
+// socketPresenceLock held.
+func presenceOf(todo_id int) []SocketEndpointPresence {
+    users := []SocketEndpointPresence{}
+    add := func(c *socketConn, activity string, locked bool) {
+        for i := range users {
+            if users[i].UserId == c.auth.OwnerId {
+                // Editing in one tab beats viewing in another
+                if activity == ActivityEditing {
+                    users[i].Activity = activity
+                }
+                users[i].Locked = users[i].Locked || locked
+                return
+            }
+        }
+        users = append(users, SocketEndpointPresence{
+            UserId:     c.auth.OwnerId,
+            Name:       c.name,
+            Activity:   activity,
+            Locked:     locked,
+        })
+    }
+
+    lock := socketLocks[todo_id]
+    if lock != nil && time.Now().After(lock.expires) {
+        lock = nil
+    }
+    for c, activity := range socketActivities[todo_id] {
+        add(c, activity, lock != nil && lock.conn == c)
+    }
+    if lock != nil {
+        add(lock.conn, ActivityEditing, true)
+    }
+    return users
+}
+
+// Give up a soft lock that wasn't taken again in time, and let everyone know
+func expireSocketLock(todo_id int, lock *socketLock) {
+    socketPresenceLock.Lock()
+    expired := socketLocks[todo_id] == lock
+    if expired {
+        delete(socketLocks, todo_id)
+    }
+    socketPresenceLock.Unlock()
+
+    if expired {
+        broadcastPresence(todo_id)
+    }
+}
+
+// Let everyone who can see a todo know who is looking at it
+func broadcastPresence(todo_id int) {
+    todo := models.Todo{
+        Id:     todo_id,
+    }
+    if !todo.ReadPermissions() {
+        return
+    }
+
+    socketPresenceLock.Lock()
+    msg := SocketEndpointPresenceMessage{
+        Type:   "presence",
+        TodoId: todo_id,
+        Users:  presenceOf(todo_id),
+    }
+    var conns []*socketConn
+    for c := range socketConns {
+        conns = append(conns, c)
+    }
+    socketPresenceLock.Unlock()
+
+    for _, c := range conns {
+        if streamCanSee(&todo, &c.auth) {
+            c.queue(&msg)
+        }
+    }
+}
+
+// Read a todo named in a message, checking that the owner of the connection
+// has at least the given access to it. Replies with an error and returns false
+// if not.
+func (c *socketConn) readTodo(msg *SocketEndpointMessage, access int) bool {
+    todo := models.Todo{
+        Id:     msg.TodoId,
+    }
+    if !todo.ReadPermissions() || !streamCanSee(&todo, &c.auth) {
+        c.reply(msg, 400, "Todo not found in database")
+        return false
+    }
+    if todo.AccessOf(&c.auth) < access {
+        c.reply(msg, 403, "User cannot edit todo")
+        return false
+    }
+    return true
+}
+
+// Tell the others what the client is doing with a todo
+func (c *socketConn) presence(msg *SocketEndpointMessage) {
+    switch msg.Activity {
+    case ActivityViewing, ActivityEditing, "":
+    default:
+        c.reply(msg, 400, "Unknown activity")
+        return
+    }
+    if len(msg.Activity) != 0 && !c.readTodo(msg, models.AccessNone) {
+        return
+    }
+
+    socketPresenceLock.Lock()
+    if len(msg.Activity) == 0 {
+        delete(socketActivities[msg.TodoId], c)
+    } else {
+        if socketActivities[msg.TodoId] == nil {
+            socketActivities[msg.TodoId] = make(map[*socketConn]string)
+        }
+        socketActivities[msg.TodoId][c] = msg.Activity
+    }
+    if len(socketActivities[msg.TodoId]) == 0 {
+        delete(socketActivities, msg.TodoId)
+    }
+    socketPresenceLock.Unlock()
+
+    c.reply(msg, 200, "")
+    broadcastPresence(msg.TodoId)
+}
+
+// Take the soft lock on a todo, or keep holding it. It is only a hint to other
+// clients: changes are still accepted from everyone who may make them.
+func (c *socketConn) softLock(msg *SocketEndpointMessage) {
+    if !c.readTodo(msg, models.AccessWrite) {
+        return
+    }
+
+    socketPresenceLock.Lock()
+    lock := socketLocks[msg.TodoId]
+    if lock != nil && lock.conn != c && time.Now().Before(lock.expires) {
+        holder := lock.conn.name
+        socketPresenceLock.Unlock()
+        c.reply(msg, 409, holder + " is editing todo")
+        return
+    }
+    if lock != nil {
+        lock.timer.Stop()
+    }
+    lock = &socketLock{
+        conn:       c,
+        expires:    time.Now().Add(socketLockLifetime),
+    }
+    todo_id := msg.TodoId
+    lock.timer = time.AfterFunc(socketLockLifetime, func() {
+        expireSocketLock(todo_id, lock)
+    })
+    socketLocks[todo_id] = lock
+    socketPresenceLock.Unlock()
+
+    c.reply(msg, 200, "")
+    broadcastPresence(msg.TodoId)
+}
+
+// Give up the soft lock on a todo
+func (c *socketConn) softUnlock(msg *SocketEndpointMessage) {
+    socketPresenceLock.Lock()
+    lock := socketLocks[msg.TodoId]
+    held := lock != nil && lock.conn == c
+    if held {
+        lock.timer.Stop()
+        delete(socketLocks, msg.TodoId)
+    }
+    socketPresenceLock.Unlock()
+
+    c.reply(msg, 200, "")
+    if held {
+        broadcastPresence(msg.TodoId)
+    }
+}
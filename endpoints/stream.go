@@ -0,0 +1,211 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "time"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // StreamEndpoint represents the controller for streaming changes to clients
+    StreamEndpoint struct {}
+
+    // Payload of an event in the stream of todo changes
+    StreamEndpointTodoEvent struct {
+        TodoId      int             `json:"todo_id"`
+        // The todo after the change, left out when it was deleted, or can no
+        // longer be seen
+        Todo        *models.Todo    `json:"todo,omitempty"`
+        // Who made the change, 0 if done by the server
+        UserId      int             `json:"user_id"`
+        UserName    string          `json:"user_name"`
+    }
+)
+
+// The kinds of events in the stream of todo changes
+const (
+    StreamCreated   = "created"
+    StreamUpdated   = "updated"
+    StreamMoved     = "moved"
+    StreamDeleted   = "deleted"
+)
+
+// How often to send something down an idle stream, so that it isn't closed by
+// proxies and invalidated tokens are noticed
+var streamKeepAlive = 30 * time.Second
+
+// How many changes to read from the database at once
+const streamBatchSize = 100
+
+func NewStreamEndpoint() *StreamEndpoint {
+    return &StreamEndpoint{}
+}
+
+// Check whether the owner of a token can see a todo in the stream: public todos
+// and those they can read, only on the board of a token limited to one
+func streamCanSee(todo *models.Todo, auth *models.Token) bool {
+    if auth.BoardId > 0 && todo.BoardId != auth.BoardId {
+        return false
+    }
+    return todo.Public || todo.AccessOf(auth) >= models.AccessRead
+}
+
+// Work out what a change to a todo looks like to the owner of a token. Todos
+// that become visible are created and those that can no longer be seen are
+// deleted, including when they are shared or assigned, or the board they are
+// on gains or loses a member. Returns "" if they can't see the todo at all.
+func streamEventKind(event *models.TodoEvent, auth *models.Token) string {
+    visible := streamCanSee(&event.Todo, auth)
+    switch event.Kind {
+    case models.EventCreate, models.EventRestore:
+        if visible {
+            return StreamCreated
+        }
+    case models.EventDelete, models.EventPurge:
+        if visible {
+            return StreamDeleted
+        }
+    case models.EventUpdate, models.EventState:
+        before := event.Previous()
+        wasVisible := streamCanSee(&before, auth)
+        switch {
+        case visible && !wasVisible:
+            return StreamCreated
+        case visible && event.Kind == models.EventState:
+            return StreamMoved
+        case visible:
+            return StreamUpdated
+        case wasVisible:
+            return StreamDeleted
+        }
+    case models.EventAccess:
+        // The users it changed for may have gained or lost the todo, everyone
+        // else still sees it the same
+        changed := event.AccessChangedFor(auth.OwnerId)
+        switch {
+        case visible && changed:
+            return StreamCreated
+        case visible:
+            return StreamUpdated
+        case changed:
+            return StreamDeleted
+        }
+    }
+    return ""
+}
+
+// Write out a change to a todo to a stream, if the owner of a token can see it
+func writeStreamEvent(w http.ResponseWriter, event *models.TodoEvent, auth *models.Token) {
+    kind := streamEventKind(event, auth)
+    if len(kind) == 0 {
+        return
+    }
+
+    payload := StreamEndpointTodoEvent{
+        TodoId:     event.TodoId,
+        UserId:     event.UserId,
+        UserName:   event.UserName,
+    }
+    if kind != StreamDeleted {
+        payload.Todo = &event.Todo
+    }
+    jpayload, _ := json.Marshal(payload)
+    fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, kind, jpayload)
+}
+
+func (se StreamEndpoint) Todos(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    // Browsers can only give the token in the query string
+    auth := models.Token{
+        Value:  r.URL.Query().Get("authority"),
+    }
+
+    // Check the privileges on the auth token
+    if len(auth.Value) == 0 || !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        w.WriteHeader(500)
+        return
+    }
+
+    // Carry on after the last event the client saw, or start from now
+    last := -1
+    resume := r.Header.Get("Last-Event-ID")
+    if len(resume) == 0 {
+        resume = r.URL.Query().Get("last_event_id")
+    }
+    if len(resume) != 0 {
+        var err error
+        last, err = strconv.Atoi(resume)
+        if err != nil || last < 0 {
+            w.WriteHeader(400)
+            return
+        }
+    }
+
+    // Listen before reading, so that no change can slip through in between
+    sub := models.SubscribeTodoEvents()
+    defer sub.Close()
+    if last < 0 {
+        last = models.LatestTodoEventId()
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    // Don't let proxies hold back events
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "retry: 3000\n\n")
+    flusher.Flush()
+
+    keepAlive := time.NewTicker(streamKeepAlive)
+    defer keepAlive.Stop()
+    for {
+        // Send everything recorded since the last event sent
+        for {
+            events := models.ListTodoEventsSince(last, streamBatchSize)
+            for i := range events {
+                writeStreamEvent(w, &events[i], &auth)
+                last = events[i].Id
+            }
+            if len(events) < streamBatchSize {
+                break
+            }
+        }
+        flusher.Flush()
+
+        // Wait for more
+        select {
+        case <-r.Context().Done():
+            return
+        case <-sub.C:
+        case <-keepAlive.C:
+            if !auth.ReadValues() {
+                // The token was invalidated in the meantime
+                return
+            }
+            fmt.Fprintf(w, ": keep-alive\n\n")
+        }
+    }
+}
@@ -0,0 +1,286 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // SyncEndpoint represents the controller for keeping clients in sync
+    SyncEndpoint struct {}
+
+    // Changes endpoint
+    SyncEndpointChangesRequest struct {
+        Auth        string          `json:"authority"`
+        // Sync token from the last time, 0 to get everything
+        Since       int             `json:"since"`
+    }
+    SyncEndpointChangesResponse struct {
+        // Todos that were created or changed, or can now be seen
+        Todos       []models.Todo   `json:"todos"`
+        // Todos that were deleted, or can no longer be seen
+        Deleted     []int           `json:"deleted"`
+        // Sync token to ask for the changes after these ones with
+        SyncToken   int             `json:"sync_token"`
+        // Whether there are more changes to ask for right away
+        More        bool            `json:"more"`
+    }
+
+    // Upload endpoint
+    SyncEndpointUploadRequest struct {
+        Auth        string                          `json:"authority"`
+        // Key to retry the upload with if no response came back
+        IdempotencyKey string                       `json:"idempotency_key"`
+        Changes     []TodosEndpointBatchOperation   `json:"changes"`
+    }
+)
+
+// How many recorded changes to look at for a single request
+const syncBatchSize = 500
+
+func NewSyncEndpoint() *SyncEndpoint {
+    return &SyncEndpoint{}
+}
+
+// Read in a todo with everything a client keeps, like the information endpoint.
+// Returns true if the owner of a token can see it.
+func syncReadTodo(todo *models.Todo, auth *models.Token) bool {
+    if !todo.ReadValues() || todo.DeletedAt != nil || !streamCanSee(todo, auth) {
+        return false
+    }
+    todo.BlockedBy = models.ListBlockers(todo.Id)
+    todo.Blocked = len(models.ListUnfinishedBlockers(todo.Id)) > 0
+    todo.ReadAssignees()
+    return true
+}
+
+func (se SyncEndpoint) Changes(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var secr SyncEndpointChangesRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&secr)
+
+    // The token and sync token may also be given in the query string
+    if authority := r.URL.Query().Get("authority"); len(authority) != 0 {
+        secr.Auth = authority
+    }
+    if since := r.URL.Query().Get("since"); len(since) != 0 {
+        var err error
+        secr.Since, err = strconv.Atoi(since)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+    if secr.Since < 0 {
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  secr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    resp := SyncEndpointChangesResponse{
+        Todos:      []models.Todo{},
+        Deleted:    []int{},
+    }
+    var ids []int
+    if secr.Since == 0 {
+        // Everything there is, as of now. Changes made while reading are sent
+        // again the next time.
+        resp.SyncToken = models.LatestTodoEventId()
+        var filter models.TodoFilter
+        scopeFilter(&filter, &auth)
+        for _, todo := range models.ListTodos(auth.OwnerId, filter) {
+            ids = append(ids, todo.Id)
+        }
+    } else {
+        // Only the todos changed since then, once each
+        resp.SyncToken = secr.Since
+        events := models.ListTodoEventsSince(secr.Since, syncBatchSize)
+        resp.More = len(events) == syncBatchSize
+        seen := make(map[int]bool)
+        for i := range events {
+            event := &events[i]
+            resp.SyncToken = event.Id
+
+            // Todos that couldn't be seen at all aren't mentioned, but ones
+            // the user just lost access to are
+            visible := streamCanSee(&event.Todo, &auth) || event.AccessChangedFor(auth.OwnerId)
+            if !visible && (event.Kind == models.EventUpdate || event.Kind == models.EventState) {
+                previous := event.Previous()
+                visible = streamCanSee(&previous, &auth)
+            }
+            if !visible {
+                continue
+            }
+            if !seen[event.TodoId] {
+                seen[event.TodoId] = true
+                ids = append(ids, event.TodoId)
+            }
+        }
+    }
+
+    // Send the todos as they are now
+    for _, id := range ids {
+        todo := models.Todo{
+            Id:     id,
+        }
+        if syncReadTodo(&todo, &auth) {
+            resp.Todos = append(resp.Todos, todo)
+        } else if secr.Since != 0 {
+            resp.Deleted = append(resp.Deleted, id)
+        }
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (se SyncEndpoint) Upload(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var seur SyncEndpointUploadRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&seur)
+
+    // Check for errors
+    if err != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    if len(seur.Changes) > maxBatchOperations {
+        resp := TodosEndpointBatchResponse{
+            Error: fmt.Sprintf("Too many changes, at most %d are allowed", maxBatchOperations),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Every change needs a token, which privileges are checked per change
+    auth := models.Token{
+        Value:  seur.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 3 {
+        // User not authorized
+        resp := TodosEndpointBatchResponse{
+            Error: "Authorization token lacks creation privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // An upload retried over a flaky connection is only done once
+    if key := idempotencyKeyOf(r, seur.IdempotencyKey); len(key) != 0 {
+        iw := beginIdempotentRequest(w, &auth, key, seur.Changes)
+        if iw == nil {
+            return
+        }
+        defer iw.finish()
+        w = iw
+    }
+
+    // All the changes are made in a single transaction, each on its own
+    batch := models.BeginTodoBatch()
+    if batch == nil {
+        // Database error
+        resp := TodosEndpointBatchResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    defer batch.Rollback()
+
+    results := batchIndependently(batch, len(seur.Changes), func(i int) TodosEndpointBatchResult {
+        change := &seur.Changes[i]
+
+        // Offline changes to existing todos have to say which version they
+        // were made to, so that changes made in the meantime aren't lost
+        if change.Op != "create" {
+            var target models.Todo
+            if json.Unmarshal(change.Todo, &target) != nil {
+                return batchError(400, "Invalid todo")
+            }
+            if target.Version <= 0 {
+                return batchError(400, "Todo is missing the version it was changed from")
+            }
+        }
+        return batchOperation(batch, change, &auth)
+    })
+    if results == nil || !batch.Commit() {
+        // Database error
+        resp := TodosEndpointBatchResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything that could be done is done
+    resp := TodosEndpointBatchResponse{
+        Results: results,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
@@ -0,0 +1,109 @@
+package endpoints
+
+import (
+    // stdlib
+    "encoding/json"
+    "fmt"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+
+    // own stuff
+    "github.com/ohnx/gotodo/database"
+    "github.com/ohnx/gotodo/models"
+)
+
+// Ask for the changes since a sync token as the owner of a token
+func testSyncChanges(t *testing.T, auth *models.Token, since int) SyncEndpointChangesResponse {
+    t.Helper()
+    r := httptest.NewRequest("GET", fmt.Sprintf("/api/sync/changes?authority=%s&since=%d", auth.Value, since), nil)
+    w := httptest.NewRecorder()
+    SyncEndpoint{}.Changes(w, r, nil)
+    if w.Code != 200 {
+        t.Fatalf("sync changes since %d: status %d", since, w.Code)
+    }
+
+    var resp SyncEndpointChangesResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+        t.Fatal(err)
+    }
+    return resp
+}
+
+// Todos the user can no longer see are sent as deleted, whichever way they lost
+// sight of them, and todos they never saw aren't mentioned at all
+func TestSyncChangesVisibility(t *testing.T) {
+    testDatabase(t)
+
+    _, err := database.GetConnection().Exec("INSERT INTO users(name, password) values(?,?)", "alice", database.Hash("password"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    owner := models.Token{
+        Type:       1,
+        Value:      "owner",
+        OwnerId:    1,
+    }
+    other := models.Token{
+        Type:       1,
+        Value:      "alice",
+        OwnerId:    2,
+    }
+    if !owner.InsertValues() || !other.InsertValues() {
+        t.Fatal("failed to insert tokens")
+    }
+
+    todo := models.Todo{
+        Name:       "private",
+        State:      models.StateIdeas,
+        OwnerId:    1,
+        Editor:     &owner,
+    }
+    if !todo.InsertValues() {
+        t.Fatal("failed to insert todo")
+    }
+    share := models.Share{
+        TodoId:     todo.Id,
+        UserId:     2,
+        Level:      models.ShareRead,
+        Editor:     &owner,
+    }
+
+    steps := []struct {
+        name    string
+        change  func() bool
+        todos   []int
+        deleted []int
+    }{
+        {"private todo changed", func() bool { todo.Name = "renamed"; return todo.WriteValues() }, nil, nil},
+        {"shared", share.WriteValues, []int{todo.Id}, nil},
+        {"changed while shared", func() bool { todo.Name = "shared"; return todo.WriteValues() }, []int{todo.Id}, nil},
+        {"unshared", share.Remove, nil, []int{todo.Id}},
+        {"changed after unsharing", func() bool { todo.Name = "private again"; return todo.WriteValues() }, nil, nil},
+        {"made public", func() bool { todo.Public = true; return todo.WriteValues() }, []int{todo.Id}, nil},
+        {"made private", func() bool { todo.Public = false; return todo.WriteValues() }, nil, []int{todo.Id}},
+        {"shared again", share.WriteValues, []int{todo.Id}, nil},
+        {"moved to the trash", todo.Remove, nil, []int{todo.Id}},
+    }
+    since := testSyncChanges(t, &other, 0).SyncToken
+    for _, step := range steps {
+        if !step.change() {
+            t.Fatalf("%s: failed to change todo", step.name)
+        }
+
+        resp := testSyncChanges(t, &other, since)
+        // nil when there are none, like in the steps
+        var todos, deleted []int
+        for _, sent := range resp.Todos {
+            todos = append(todos, sent.Id)
+        }
+        deleted = append(deleted, resp.Deleted...)
+        if !reflect.DeepEqual(todos, step.todos) || !reflect.DeepEqual(deleted, step.deleted) {
+            t.Errorf("%s: todos %v and deleted %v, want %v and %v", step.name, todos, deleted, step.todos, step.deleted)
+        }
+        if resp.SyncToken <= since {
+            t.Errorf("%s: sync token %d didn't move on from %d", step.name, resp.SyncToken, since)
+        }
+        since = resp.SyncToken
+    }
+}
@@ -0,0 +1,377 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // TagEndpoint represents the controller for operating on the Tag resource
+    TagEndpoint struct {}
+
+    // Update endpoint
+    TagEndpointUpdateRequest struct {
+        Tag     models.Tag      `json:"tag"`
+        Auth    string          `json:"authority"`
+    }
+    TagEndpointUpdateResponse struct {
+        Error   string          `json:"error,omitempty"`
+        Tag     *models.Tag     `json:"tag,omitempty"`
+    }
+
+    // Merge endpoint
+    TagEndpointMergeRequest struct {
+        Source  models.Tag      `json:"source"`
+        Target  models.Tag      `json:"target"`
+        Auth    string          `json:"authority"`
+    }
+    TagEndpointMergeResponse struct {
+        Error   string          `json:"error,omitempty"`
+    }
+
+    // Remove endpoint
+    TagEndpointRemoveRequest struct {
+        Tag         models.Tag  `json:"tag"`
+        Auth        string      `json:"authority"`
+        // Tag to move the todos over to, 0 to just untag them
+        ReassignTo  int         `json:"reassign_to"`
+    }
+    TagEndpointRemoveResponse struct {
+        Error   string          `json:"error,omitempty"`
+    }
+)
+
+func NewTagEndpoint() *TagEndpoint {
+    return &TagEndpoint{}
+}
+
+func (te TagEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var teur TagEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&teur)
+
+    // Check for errors
+    if err != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Managing tags needs a token that can modify todos
+    auth := models.Token{
+        Value:  teur.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := TagEndpointUpdateResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if teur.Tag.Id > 0 {
+        // Existing tag, does it belong to the right owner (or board)?
+        tag := models.Tag{
+            Id:     teur.Tag.Id,
+        }
+        if !tag.ReadValues() {
+            resp := TagEndpointUpdateResponse{
+                Error: "Tag not found in database",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+        if !canManageTag(&tag, &auth, models.RoleMember) {
+            // Tag doesn't belong to the right owner
+            resp := TagEndpointUpdateResponse{
+                Error: "User does not own tag",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Keep the color unless a new one was given, and the owner and board
+        // as they are
+        if len(teur.Tag.Color) == 0 {
+            teur.Tag.Color = tag.Color
+        }
+        teur.Tag.OwnerId = tag.OwnerId
+        teur.Tag.BoardId = tag.BoardId
+    } else {
+        // New tag, which goes on the board of the token if it is limited to one
+        if msg := checkNewOnBoard(&teur.Tag.BoardId, &auth); len(msg) != 0 {
+            resp := TagEndpointUpdateResponse{
+                Error: msg,
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+        teur.Tag.OwnerId = auth.OwnerId
+    }
+
+    // Check the new values
+    if msg := teur.Tag.Validate(); len(msg) != 0 {
+        resp := TagEndpointUpdateResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Two possiblities - new or update existing
+    var ok bool
+    if teur.Tag.Id > 0 {
+        ok = teur.Tag.WriteValues()
+    } else {
+        teur.Tag.Id = 0
+        ok = teur.Tag.InsertValues()
+    }
+    if !ok {
+        // Database error
+        resp := TagEndpointUpdateResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything is good!
+    resp := TagEndpointUpdateResponse{
+        Tag:    &teur.Tag,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TagEndpoint) Merge(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var temr TagEndpointMergeRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&temr)
+
+    // Check for errors
+    if err != nil || temr.Source.Id == temr.Target.Id {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Merging removes a tag, so it needs a primary token
+    auth := models.Token{
+        Value:  temr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := TagEndpointMergeResponse{
+            Error: "Authorization token lacks removal privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Both tags have to exist...
+    if !temr.Source.ReadValues() || !temr.Target.ReadValues() {
+        resp := TagEndpointMergeResponse{
+            Error: "Tag not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // ... and belong to the user, or the same board the user is an admin of
+    if !canManageTag(&temr.Source, &auth, models.RoleAdmin) || !temr.Source.SameScope(&temr.Target) {
+        resp := TagEndpointMergeResponse{
+            Error: "User does not own tag",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Hand the todos and children of the source to the target
+    temr.Source.Editor = &auth
+    if msg := temr.Source.MergeInto(temr.Target.Id); len(msg) != 0 {
+        resp := TagEndpointMergeResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TagEndpointMergeResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TagEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var terr TagEndpointRemoveRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&terr)
+
+    // Check for errors
+    if err != nil || len(terr.Auth) == 0 || terr.ReassignTo == terr.Tag.Id {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Stub an example token
+    auth := models.Token{
+        Value:  terr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := TagEndpointRemoveResponse{
+            Error: "Authorization token lacks removal privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Check if the tag is owned by the correct person
+    if !terr.Tag.ReadValues() {
+        resp := TagEndpointRemoveResponse{
+            Error: "Tag not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    if !canManageTag(&terr.Tag, &auth, models.RoleAdmin) {
+        resp := TagEndpointRemoveResponse{
+            Error: "User does not own tag",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // The tag the todos are handed to must be the user's (or board's) too, and
+    // not go away along with the removed tag
+    if terr.ReassignTo != 0 && (!models.TagsOwnedBy([]int{terr.ReassignTo}, terr.Tag.OwnerId, terr.Tag.BoardId) || models.TagIsDescendant(terr.ReassignTo, terr.Tag.Id)) {
+        resp := TagEndpointRemoveResponse{
+            Error: "Tag to reassign to not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Ok, looks like we can remove the tag now.
+    terr.Tag.Editor = &auth
+    if !terr.Tag.Remove(terr.ReassignTo) {
+        // Database error
+        resp := TagEndpointRemoveResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TagEndpointRemoveResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
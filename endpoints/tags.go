@@ -18,6 +18,10 @@ type (
     TagsEndpoint struct {}
 
     // List endpoint
+    TagsEndpointListRequest struct {
+        // Nest the tags under their parents
+        Tree    bool            `json:"tree"`
+    }
     TagsEndpointListResponse struct {
         Tags    []models.Tag    `json:"tags"`
     }
@@ -30,11 +34,24 @@ func NewTagsEndpoint() *TagsEndpoint {
 func (te TagsEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
     w.Header().Set("Content-Type", "application/json")
 
-    // No input
+    // Input type
+    var telr TagsEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type, the input is optional
+    decoder.Decode(&telr)
+    if r.URL.Query().Get("tree") == "1" {
+        telr.Tree = true
+    }
 
     // Read all the tags
+    tags := models.ListAllTags()
+    if telr.Tree {
+        tags = models.BuildTagTree(tags)
+    }
     resp := TagsEndpointListResponse{
-        Tags: models.ListAllTags(),
+        Tags: tags,
     }
 
     // Create JSON response
@@ -5,6 +5,7 @@ import (
     "fmt"
     "encoding/json"
     "net/http"
+    "time"
 
     // HTTP router
     "github.com/julienschmidt/httprouter"
@@ -19,11 +20,18 @@ type (
 
     // Update endpoint
     TodoEndpointUpdateRequest struct {
-        Todo    models.Todo     `json:"todo"`
-        Auth    string          `json:"authority"`
+        Todo            models.Todo     `json:"todo"`
+        Auth            string          `json:"authority"`
+        // Makes retrying the creation of a todo safe, if not in a header
+        IdempotencyKey  string          `json:"idempotency_key"`
+        // Start the todo even if it is still blocked
+        Force           bool            `json:"force"`
     }
     TodoEndpointUpdateResponse struct {
         Error   string          `json:"error,omitempty"`
+        // The stored todo, or the current todo when it was changed by someone
+        // else in the meantime
+        Todo    *models.Todo    `json:"todo,omitempty"`
     }
 
     // Remove endpoint
@@ -44,6 +52,38 @@ type (
         Error   string          `json:"error,omitempty"`
         Todo    models.Todo     `json:"todo,omitempty"`
     }
+
+    // Move endpoint
+    TodoEndpointMoveRequest struct {
+        Todo        models.Todo     `json:"todo"`
+        Auth        string          `json:"authority"`
+        State       int             `json:"state"`
+        Position    int             `json:"position"`
+        // Start the todo even if it is still blocked
+        Force       bool            `json:"force"`
+    }
+    TodoEndpointMoveResponse struct {
+        Error   string          `json:"error,omitempty"`
+        // The current todo, when it was changed by someone else in the meantime
+        Todo    *models.Todo    `json:"todo,omitempty"`
+    }
+
+    // Occurrences endpoint
+    TodoEndpointOccurrencesRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+        Count   int             `json:"count"`
+    }
+    TodoEndpointOccurrencesResponse struct {
+        Error       string      `json:"error,omitempty"`
+        Occurrences []time.Time `json:"occurrences,omitempty"`
+    }
+)
+
+// Number of occurrences to preview when no count is given, and the most allowed
+const (
+    defaultOccurrences  = 5
+    maxOccurrences      = 100
 )
 
 func NewTodoEndpoint() *TodoEndpoint {  
@@ -69,6 +109,21 @@ func (te TodoEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprout
         return
     }
 
+    // Check the fields that are up to the user
+    if msg := teur.Todo.Validate(); len(msg) != 0 {
+        resp := TodoEndpointUpdateResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    teur.Todo.NormalizeTags()
+
     // Two possiblities - new or update existing
     if teur.Todo.Id < 0 {
         // New, check if auth token <= 3
@@ -91,8 +146,60 @@ func (te TodoEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprout
             return
         }
 
+        // A retried creation gets the response of the first try
+        if key := idempotencyKeyOf(r, teur.IdempotencyKey); len(key) != 0 {
+            iw := beginIdempotentRequest(w, &auth, key, teur.Todo)
+            if iw == nil {
+                return
+            }
+            defer iw.finish()
+            w = iw
+        }
+
+        // New todos go on the board of the token, if it is limited to one
+        if msg := checkNewOnBoard(&teur.Todo.BoardId, &auth); len(msg) != 0 {
+            resp := TodoEndpointUpdateResponse{
+                Error: msg,
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Todos can only be tagged with the owner's (or board's) own tags
+        if !models.TagsOwnedBy(teur.Todo.TagIds, auth.OwnerId, teur.Todo.BoardId) {
+            resp := TodoEndpointUpdateResponse{
+                Error: "Tag not found in database",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Check the recurrence rule, if any
+        teur.Todo.RRuleStart = time.Time{}
+        if err := teur.Todo.PrepareRecurrence(); err != nil {
+            // Bad rule or time zone
+            resp := TodoEndpointUpdateResponse{
+                Error: fmt.Sprintf("Invalid recurrence: %s", err),
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
         // Authorized to create todo, so write it!
         teur.Todo.OwnerId = auth.OwnerId
+        teur.Todo.Editor = &auth
         if !teur.Todo.InsertValues() {
             // Database error
             resp := TodoEndpointUpdateResponse{
@@ -106,11 +213,15 @@ func (te TodoEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprout
             return
         }
 
-        // Everything is good!
-        resp := TodoEndpointUpdateResponse{}
+        // Everything is good! Send back the todo as it was stored
+        teur.Todo.ReadValues()
+        resp := TodoEndpointUpdateResponse{
+            Todo:   &teur.Todo,
+        }
         jresp, _ := json.Marshal(resp)
 
-        // Write error + payload
+        // Write OK + payload
+        w.Header().Set("ETag", versionETag(teur.Todo.Version))
         w.WriteHeader(200)
         fmt.Fprintf(w, "%s", jresp)
         return
@@ -151,8 +262,9 @@ func (te TodoEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprout
             fmt.Fprintf(w, "%s", jresp)
             return
         }
-        if todo.OwnerId != auth.OwnerId {
-            // Todo doesn't belong to the right owner
+        if todo.AccessOf(&auth) < models.AccessWrite {
+            // Todo doesn't belong to the right owner, and isn't shared for
+            // writing either
             resp := TodoEndpointUpdateResponse{
                 Error: "User does not own todo",
             }
@@ -164,8 +276,138 @@ func (te TodoEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprout
             return
         }
 
+        // The version being edited, preferably from the If-Match header
+        version := ifMatchVersion(r)
+        if version == 0 {
+            version = teur.Todo.Version
+        }
+        if version == 0 {
+            resp := TodoEndpointUpdateResponse{
+                Error: "Version of the todo being updated is missing",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(428)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Read the current values to carry over the recurrence
+        if !todo.ReadValues() {
+            // Database error
+            resp := TodoEndpointUpdateResponse{
+                Error: "Database error",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(500)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Don't clobber changes made since the todo was read
+        if version == anyVersion {
+            version = todo.Version
+        }
+        if version != todo.Version {
+            resp := TodoEndpointUpdateResponse{
+                Error: "Todo was changed in the meantime",
+                Todo:  &todo,
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.Header().Set("ETag", versionETag(todo.Version))
+            w.WriteHeader(409)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+        teur.Todo.Version = version
+
+        // Only the owner decides who else gets to see the todo
+        if teur.Todo.Public != todo.Public && todo.AccessOf(&auth) < models.AccessOwner {
+            resp := TodoEndpointUpdateResponse{
+                Error: "Only the owner of the todo can make it public or private",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Todos can only be newly tagged with the owner's (or board's) own tags
+        var newTagIds []int
+        for _, id := range teur.Todo.TagIds {
+            if !todo.HasTag(id) {
+                newTagIds = append(newTagIds, id)
+            }
+        }
+        if !models.TagsOwnedBy(newTagIds, todo.OwnerId, todo.BoardId) {
+            resp := TodoEndpointUpdateResponse{
+                Error: "Tag not found in database",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Keep the same series going unless the rule or time zone changed
+        if teur.Todo.RRule == todo.RRule && teur.Todo.TimeZone == todo.TimeZone {
+            teur.Todo.RRuleStart = todo.RRuleStart
+        } else {
+            teur.Todo.RRuleStart = time.Time{}
+        }
+        if err := teur.Todo.PrepareRecurrence(); err != nil {
+            // Bad rule or time zone
+            resp := TodoEndpointUpdateResponse{
+                Error: fmt.Sprintf("Invalid recurrence: %s", err),
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Todos waiting on others aren't started by accident
+        if !checkNotBlocked(w, todo.Id, todo.State, teur.Todo.State, teur.Force) {
+            return
+        }
+
+        // Completing a recurring todo moves it on to the next occurrence
+        if teur.Todo.State == models.StateDone && todo.State != models.StateDone {
+            teur.Todo.Advance(todo.State)
+        }
+
         // Everything looks good! Time to update the todo
+        teur.Todo.Editor = &auth
         if !teur.Todo.WriteValues() {
+            // Lost a race with another update?
+            current := models.Todo{
+                Id:     teur.Todo.Id,
+            }
+            if current.ReadValues() && current.Version != version {
+                resp := TodoEndpointUpdateResponse{
+                    Error: "Todo was changed in the meantime",
+                    Todo:  &current,
+                }
+                jresp, _ := json.Marshal(resp)
+
+                // Write error + payload
+                w.Header().Set("ETag", versionETag(current.Version))
+                w.WriteHeader(409)
+                fmt.Fprintf(w, "%s", jresp)
+                return
+            }
+
             // Database error
             resp := TodoEndpointUpdateResponse{
                 Error: "Database error",
@@ -178,11 +420,15 @@ func (te TodoEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprout
             return
         }
 
-        // Everything is good!
-        resp := TodoEndpointUpdateResponse{}
+        // Everything is good! Send back the todo as it was stored
+        teur.Todo.ReadValues()
+        resp := TodoEndpointUpdateResponse{
+            Todo:   &teur.Todo,
+        }
         jresp, _ := json.Marshal(resp)
 
-        // Write error + payload
+        // Write OK + payload
+        w.Header().Set("ETag", versionETag(teur.Todo.Version))
         w.WriteHeader(200)
         fmt.Fprintf(w, "%s", jresp)
         return
@@ -239,7 +485,7 @@ func (te TodoEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprout
         fmt.Fprintf(w, "%s", jresp)
         return
     }
-    if terr.Todo.OwnerId != auth.OwnerId {
+    if terr.Todo.AccessOf(&auth) < models.AccessOwner {
         // Todo doesn't belong to the right owner
         resp := TodoEndpointRemoveResponse{
             Error: "User does not own todo",
@@ -253,6 +499,7 @@ func (te TodoEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprout
     }
 
     // Ok, looks like we can remove the todo now.
+    terr.Todo.Editor = &auth
     if !terr.Todo.Remove() {
         // Database error
         resp := TodoEndpointRemoveResponse{
@@ -342,8 +589,9 @@ func (te TodoEndpoint) Info(w http.ResponseWriter, r *http.Request, p httprouter
             return
         }
 
-        // Check if the todo is owned by the correct person
-        if teir.Todo.OwnerId != auth.OwnerId  {
+        // Check if the todo is owned by the correct person, or assigned to or
+        // shared with them
+        if teir.Todo.AccessOf(&auth) < models.AccessRead {
             // Todo doesn't belong to the right owner
             resp := TodoEndpointUpdateResponse{
                 Error: "User does not own todo",
@@ -371,6 +619,11 @@ func (te TodoEndpoint) Info(w http.ResponseWriter, r *http.Request, p httprouter
         return
     }
 
+    // Along with what the todo waits for and who works on it
+    teir.Todo.BlockedBy = models.ListBlockers(teir.Todo.Id)
+    teir.Todo.Blocked = len(models.ListUnfinishedBlockers(teir.Todo.Id)) > 0
+    teir.Todo.ReadAssignees()
+
     // Create response
     resp := TodoEndpointInfoResponse{
         Todo:   teir.Todo,
@@ -380,6 +633,256 @@ func (te TodoEndpoint) Info(w http.ResponseWriter, r *http.Request, p httprouter
     jresp, _ := json.Marshal(resp)
 
     // Write OK + payload
+    w.Header().Set("ETag", versionETag(teir.Todo.Version))
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TodoEndpoint) Occurrences(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var teor TodoEndpointOccurrencesRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&teor)
+
+    // Check for errors
+    if err != nil {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Clamp the number of occurrences
+    if teor.Count <= 0 {
+        teor.Count = defaultOccurrences
+    } else if teor.Count > maxOccurrences {
+        teor.Count = maxOccurrences
+    }
+
+    if teor.Todo.Id > 0 {
+        // Existing todo, same rules as reading its information
+        if !teor.Todo.ReadPermissions() {
+            // Database error
+            resp := TodoEndpointOccurrencesResponse{
+                Error: "Todo not found in database",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        if !teor.Todo.Public {
+            // Todo is not public, need to check a token
+            auth := models.Token{
+                Value:  teor.Auth,
+            }
+
+            // Check the privileges on the auth token
+            if len(teor.Auth) == 0 || !auth.ReadValues() || auth.Type > 2 || teor.Todo.AccessOf(&auth) < models.AccessRead {
+                // Fake a not known error
+                resp := TodoEndpointOccurrencesResponse{
+                    Error: "Todo not found in database",
+                }
+                jresp, _ := json.Marshal(resp)
+
+                // Write error + payload
+                w.WriteHeader(400)
+                fmt.Fprintf(w, "%s", jresp)
+                return
+            }
+        }
+
+        if !teor.Todo.ReadValues() {
+            // Database error
+            resp := TodoEndpointOccurrencesResponse{
+                Error: "Database error",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(500)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+    } else {
+        // Unsaved todo, preview a series starting at the given due date
+        teor.Todo.RRuleStart = teor.Todo.DueDate
+    }
+
+    // Nothing to preview for a todo that doesn't repeat
+    if len(teor.Todo.RRule) == 0 {
+        resp := TodoEndpointOccurrencesResponse{
+            Error: "Todo does not recur",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Compute the occurrences following the current due date
+    occurrences, err := teor.Todo.Occurrences(teor.Todo.DueDate, teor.Count)
+    if err != nil {
+        // Bad rule or time zone
+        resp := TodoEndpointOccurrencesResponse{
+            Error: fmt.Sprintf("Invalid recurrence: %s", err),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TodoEndpointOccurrencesResponse{
+        Occurrences:    occurrences,
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TodoEndpoint) Move(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var temr TodoEndpointMoveRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&temr)
+
+    // Check for errors
+    if err != nil || temr.State < models.StateIdeas || temr.State > models.StateDone {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Moving is modifying, check if auth token <= 2
+    auth := models.Token{
+        Value:  temr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := TodoEndpointMoveResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Valid key, but does it belong to the right owner?
+    todo := models.Todo{
+        Id:     temr.Todo.Id,
+    }
+    if !todo.ReadValues() || todo.DeletedAt != nil {
+        // Database error
+        resp := TodoEndpointMoveResponse{
+            Error: "Todo not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    if todo.AccessOf(&auth) < models.AccessState {
+        // Todo doesn't belong to the right owner, who may let others move it
+        resp := TodoEndpointMoveResponse{
+            Error: "User does not own todo",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Moving is checked against the version being looked at, if one is given
+    version := ifMatchVersion(r)
+    if version == 0 {
+        version = temr.Todo.Version
+    }
+    if version > 0 && version != todo.Version {
+        resp := TodoEndpointMoveResponse{
+            Error: "Todo was changed in the meantime",
+            Todo:  &todo,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.Header().Set("ETag", versionETag(todo.Version))
+        w.WriteHeader(409)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Todos waiting on others aren't started by accident
+    if !checkNotBlocked(w, todo.Id, todo.State, temr.State, temr.Force) {
+        return
+    }
+
+    // Completing a recurring todo moves it on to the next occurrence, in place
+    todo.Editor = &auth
+    if temr.State == models.StateDone && todo.State != models.StateDone && todo.Advance(todo.State) {
+        if !todo.WriteValues() {
+            // Database error
+            resp := TodoEndpointMoveResponse{
+                Error: "Database error",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(500)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+    } else if !todo.Move(temr.State, temr.Position) {
+        // Database error
+        resp := TodoEndpointMoveResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TodoEndpointMoveResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.Header().Set("ETag", versionETag(todo.Version))
     w.WriteHeader(200)
     fmt.Fprintf(w, "%s", jresp)
 }
@@ -0,0 +1,99 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Assign endpoint
+    TodoEndpointAssignRequest struct {
+        Todo        models.Todo     `json:"todo"`
+        Auth        string          `json:"authority"`
+        // Names of all the users the todo is assigned to
+        Assignees   []string        `json:"assignees"`
+    }
+    TodoEndpointAssignResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Assignees   []models.Assignee   `json:"assignees"`
+    }
+)
+
+func (te TodoEndpoint) Assign(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tear TodoEndpointAssignRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&tear)
+
+    // Check for errors
+    if err != nil || len(tear.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Only the owner decides who works on a todo
+    auth := models.Token{
+        Value:  tear.Auth,
+    }
+    todo := models.Todo{
+        Id:     tear.Todo.Id,
+    }
+    if !readOwnTodo(w, &todo, &auth) {
+        return
+    }
+
+    // Assignees are given by name
+    userIds, unknown := models.UserIdsByName(tear.Assignees)
+    if len(unknown) != 0 {
+        resp := errorResponse{
+            Error: fmt.Sprintf("User %s not found", unknown),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    todo.Editor = &auth
+    if !todo.WriteAssignees(userIds) || !todo.ReadAssignees() {
+        // Database error
+        resp := errorResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Send back who the todo is assigned to now
+    resp := TodoEndpointAssignResponse{
+        Assignees:  todo.Assignees,
+    }
+    if resp.Assignees == nil {
+        resp.Assignees = []models.Assignee{}
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
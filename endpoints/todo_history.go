@@ -0,0 +1,209 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // History endpoint
+    TodoEndpointHistoryRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+    }
+    TodoEndpointHistoryResponse struct {
+        Error   string              `json:"error,omitempty"`
+        Events  []models.TodoEvent  `json:"events,omitempty"`
+    }
+
+    // Restore endpoint
+    TodoEndpointRestoreRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+        // Event in the history of the todo to go back to
+        EventId int             `json:"event_id"`
+    }
+    TodoEndpointRestoreResponse struct {
+        Error   string          `json:"error,omitempty"`
+        Todo    *models.Todo    `json:"todo,omitempty"`
+    }
+)
+
+func (te TodoEndpoint) History(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tehr TodoEndpointHistoryRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&tehr)
+
+    // The todo may also be given in the query string
+    if id := r.URL.Query().Get("id"); len(id) != 0 {
+        var err error
+        tehr.Todo.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+    if tehr.Todo.Id <= 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Same rules as reading its information, using the last known version of
+    // todos that have since been removed
+    todo := models.Todo{
+        Id:     tehr.Todo.Id,
+    }
+    if !todo.ReadPermissions() && !todo.ReadLatestVersion() {
+        resp := TodoEndpointHistoryResponse{
+            Error: "Todo not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    if !todo.Public {
+        // Todo is not public, need to check a token
+        auth := models.Token{
+            Value:  tehr.Auth,
+        }
+
+        // Check the privileges on the auth token
+        if len(tehr.Auth) == 0 || !auth.ReadValues() || auth.Type > 2 || todo.AccessOf(&auth) < models.AccessRead {
+            // Fake a not known error
+            resp := TodoEndpointHistoryResponse{
+                Error: "Todo not found in database",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(400)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+    }
+
+    // Create response
+    resp := TodoEndpointHistoryResponse{
+        Events: models.ListTodoEvents(todo.Id),
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TodoEndpoint) Restore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var terr TodoEndpointRestoreRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&terr)
+
+    // Check for errors
+    if err != nil || terr.Todo.Id <= 0 || terr.EventId <= 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Restoring is modifying, check if auth token <= 2
+    auth := models.Token{
+        Value:  terr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := TodoEndpointRestoreResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Valid key, but does it belong to the right owner?
+    todo := models.Todo{
+        Id:     terr.Todo.Id,
+    }
+    if !todo.ReadPermissions() && !todo.ReadLatestVersion() {
+        resp := TodoEndpointRestoreResponse{
+            Error: "Todo not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    if todo.AccessOf(&auth) < models.AccessOwner {
+        // Todo doesn't belong to the right owner. Restoring can take it out of
+        // the trash and change fields others can't, so it is left to them.
+        resp := TodoEndpointRestoreResponse{
+            Error: "User does not own todo",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Go back to the chosen version
+    todo.Editor = &auth
+    if !todo.Restore(terr.EventId) {
+        resp := TodoEndpointRestoreResponse{
+            Error: "Version not found in history",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TodoEndpointRestoreResponse{
+        Todo:   &todo,
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
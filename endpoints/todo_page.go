@@ -0,0 +1,116 @@
+package endpoints
+
+import (
+    // stdlib
+    "html/template"
+    "net/http"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // What a read-only HTML page of todos is rendered from
+    todoPage struct {
+        // Heading of the page, "" for none
+        Title       string
+        // Status line if the page can't be shown, "" otherwise
+        Error       string
+        // Ask for a password instead of showing the page
+        AskPassword bool
+        WrongPassword bool
+        // A single todo
+        Todo        *models.Todo
+        // A list of todos, in the order of the states
+        Columns     []todoColumn
+    }
+
+    // The todos of a list in one state
+    todoColumn struct {
+        State       int             `json:"state"`
+        Name        string          `json:"name"`
+        Todos       []models.Todo   `json:"todos"`
+    }
+)
+
+// The states in the order the columns are shown in
+var columnStates = []int{models.StateIdeas, models.StateDoingSoon, models.StateInProgress, models.StatePaused, models.StateDone}
+
+// Group a list of todos by state, keeping their order within each state
+func todoColumns(todos []models.Todo) []todoColumn {
+    var columns []todoColumn
+    for _, state := range columnStates {
+        column := todoColumn{
+            State:  state,
+            Name:   models.StateNames[state],
+            Todos:  []models.Todo{},
+        }
+        for _, todo := range todos {
+            if todo.State == state {
+                column.Todos = append(column.Todos, todo)
+            }
+        }
+        columns = append(columns, column)
+    }
+    return columns
+}
+
+var todoPageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+    "state": func(state int) string {
+        return models.StateNames[state]
+    },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Todo}}{{.Todo.Name}}{{else if .Title}}{{.Title}}{{else}}Todos{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+.columns { display: flex; flex-wrap: wrap; gap: 1em; }
+.column { flex: 1; min-width: 12em; }
+.todo { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em; margin-bottom: 0.5em; }
+.meta { color: #666; font-size: 0.9em; }
+.desc { white-space: pre-wrap; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+{{if .Error}}
+<p class="error">{{.Error}}</p>
+{{else if .AskPassword}}
+<form method="post">
+<p>This link is protected by a password.</p>
+{{if .WrongPassword}}<p class="error">Wrong password</p>{{end}}
+<input type="password" name="password" autofocus>
+<button type="submit">Open</button>
+</form>
+{{else if .Todo}}
+<h1>{{.Todo.Name}}</h1>
+<p class="meta">{{state .Todo.State}}{{if .Todo.Priority}} &middot; {{.Todo.Priority}}{{end}}{{if not .Todo.DueDate.IsZero}} &middot; due {{.Todo.DueDate.Format "2006-01-02"}}{{end}}</p>
+<div class="desc">{{.Todo.Desc}}</div>
+{{else}}
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+<div class="columns">
+{{range .Columns}}
+<div class="column">
+<h2>{{.Name}}</h2>
+{{range .Todos}}
+<div class="todo">
+<div>{{.Name}}</div>
+<div class="meta">{{if .Priority}}{{.Priority}} {{end}}{{if not .DueDate.IsZero}}due {{.DueDate.Format "2006-01-02"}}{{end}}</div>
+</div>
+{{end}}
+</div>
+{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// Write out a read-only page of todos
+func writeTodoPage(w http.ResponseWriter, status int, page *todoPage) {
+    w.WriteHeader(status)
+    todoPageTemplate.Execute(w, page)
+}
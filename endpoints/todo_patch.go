@@ -0,0 +1,264 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "time"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Patch endpoint
+    TodoEndpointPatchRequest struct {
+        // Only the fields to change, as a JSON Merge Patch, plus the id
+        Todo    map[string]json.RawMessage  `json:"todo"`
+        Auth    string                      `json:"authority"`
+        // Start the todo even if it is still blocked
+        Force   bool                        `json:"force"`
+    }
+    TodoEndpointPatchResponse struct {
+        Error   string          `json:"error,omitempty"`
+        // The todo after the patch, or the current todo on a conflict
+        Todo    *models.Todo    `json:"todo,omitempty"`
+    }
+)
+
+func (te TodoEndpoint) Patch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tepr TodoEndpointPatchRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&tepr)
+
+    // Find out which todo and version the patch is for
+    var target struct {
+        Id      int     `json:"id"`
+        Version int     `json:"version"`
+    }
+    if err == nil {
+        jtodo, _ := json.Marshal(tepr.Todo)
+        err = json.Unmarshal(jtodo, &target)
+    }
+
+    // Check for errors
+    if err != nil || target.Id <= 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Patching is modifying, check if auth token <= 2
+    auth := models.Token{
+        Value:  tepr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := TodoEndpointPatchResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Valid key, but does it belong to the right owner?
+    todo := models.Todo{
+        Id:     target.Id,
+    }
+    if !todo.ReadPermissions() {
+        // Database error
+        resp := TodoEndpointPatchResponse{
+            Error: "Todo not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    access := models.AccessWrite
+    if models.StateOnlyPatch(tepr.Todo) {
+        access = models.AccessState
+    }
+    if todo.AccessOf(&auth) < access {
+        // Todo doesn't belong to the right owner, who may let others change
+        // it
+        resp := TodoEndpointPatchResponse{
+            Error: "User does not own todo",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Read the current values to patch
+    if !todo.ReadValues() {
+        // Database error
+        resp := TodoEndpointPatchResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    old := todo
+
+    // Only the given fields change, so the version is only checked if given
+    version := ifMatchVersion(r)
+    if version == 0 {
+        version = target.Version
+    }
+    if version > 0 && version != todo.Version {
+        resp := TodoEndpointPatchResponse{
+            Error: "Todo was changed in the meantime",
+            Todo:  &todo,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.Header().Set("ETag", versionETag(todo.Version))
+        w.WriteHeader(409)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Apply the changes, field by field
+    if msg := todo.Patch(tepr.Todo); len(msg) != 0 {
+        resp := TodoEndpointPatchResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Only the owner decides who else gets to see the todo
+    if todo.Public != old.Public && old.AccessOf(&auth) < models.AccessOwner {
+        resp := TodoEndpointPatchResponse{
+            Error: "Only the owner of the todo can make it public or private",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Todos can only be newly tagged with the owner's (or board's) own tags
+    var newTagIds []int
+    for _, id := range todo.TagIds {
+        if !old.HasTag(id) {
+            newTagIds = append(newTagIds, id)
+        }
+    }
+    if !models.TagsOwnedBy(newTagIds, old.OwnerId, old.BoardId) {
+        resp := TodoEndpointPatchResponse{
+            Error: "Tag not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Keep the same series going unless the rule or time zone changed
+    if todo.RRule != old.RRule || todo.TimeZone != old.TimeZone {
+        todo.RRuleStart = time.Time{}
+    }
+    if err := todo.PrepareRecurrence(); err != nil {
+        // Bad rule or time zone
+        resp := TodoEndpointPatchResponse{
+            Error: fmt.Sprintf("Invalid recurrence: %s", err),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Todos waiting on others aren't started by accident
+    if !checkNotBlocked(w, todo.Id, old.State, todo.State, tepr.Force) {
+        return
+    }
+
+    // Completing a recurring todo moves it on to the next occurrence
+    if todo.State == models.StateDone && old.State != models.StateDone {
+        todo.Advance(old.State)
+    }
+
+    // Everything looks good! Time to update the todo
+    todo.Editor = &auth
+    if !todo.WriteValues() {
+        // Lost a race with another update?
+        current := models.Todo{
+            Id:     todo.Id,
+        }
+        if current.ReadValues() && current.Version != old.Version {
+            resp := TodoEndpointPatchResponse{
+                Error: "Todo was changed in the meantime",
+                Todo:  &current,
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.Header().Set("ETag", versionETag(current.Version))
+            w.WriteHeader(409)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+
+        // Database error
+        resp := TodoEndpointPatchResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything is good! Send back the todo as it was stored
+    todo.ReadValues()
+    resp := TodoEndpointPatchResponse{
+        Todo:   &todo,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.Header().Set("ETag", versionETag(todo.Version))
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
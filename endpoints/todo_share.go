@@ -0,0 +1,171 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Shares endpoint
+    TodoEndpointSharesRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+    }
+    TodoEndpointSharesResponse struct {
+        Error   string          `json:"error,omitempty"`
+        Shares  []models.Share  `json:"shares"`
+    }
+
+    // Share endpoint
+    TodoEndpointShareRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+        // Who to share the todo with, by name, and what for. An empty level
+        // stops sharing it with them.
+        Share   models.Share    `json:"share"`
+    }
+)
+
+func (te TodoEndpoint) Shares(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tesr TodoEndpointSharesRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&tesr)
+
+    // The todo may also be given in the query string
+    if id := r.URL.Query().Get("todo_id"); len(id) != 0 {
+        var err error
+        tesr.Todo.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+
+    // Only the owner manages who the todo is shared with
+    auth := models.Token{
+        Value:  tesr.Auth,
+    }
+    if !readOwnTodo(w, &tesr.Todo, &auth) {
+        return
+    }
+
+    // Read all the shares
+    resp := TodoEndpointSharesResponse{
+        Shares: models.ListShares(tesr.Todo.Id),
+    }
+    if resp.Shares == nil {
+        resp.Shares = []models.Share{}
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TodoEndpoint) Share(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tesr TodoEndpointShareRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&tesr)
+
+    // Check for errors
+    if err != nil || len(tesr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Only the owner manages who the todo is shared with
+    auth := models.Token{
+        Value:  tesr.Auth,
+    }
+    todo := models.Todo{
+        Id:     tesr.Todo.Id,
+    }
+    if !readOwnTodo(w, &todo, &auth) {
+        return
+    }
+
+    // Find out who to share the todo with
+    var msg string
+    userIds, unknown := models.UserIdsByName([]string{tesr.Share.Name})
+    if len(unknown) != 0 {
+        msg = fmt.Sprintf("User %s not found", unknown)
+    } else if userIds[0] == todo.OwnerId {
+        msg = "Todo can't be shared with its owner"
+    } else if tesr.Share.Level != "" && tesr.Share.Level != models.ShareRead && tesr.Share.Level != models.ShareWrite {
+        msg = "Invalid level"
+    }
+    if len(msg) != 0 {
+        resp := errorResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    share := models.Share{
+        TodoId: todo.Id,
+        UserId: userIds[0],
+        Level:  tesr.Share.Level,
+    }
+    share.Editor = &auth
+    var ok bool
+    if len(share.Level) == 0 {
+        ok = share.Remove()
+    } else {
+        ok = share.WriteValues()
+    }
+    if !ok {
+        // Database error
+        resp := errorResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Send back who the todo is shared with now
+    resp := TodoEndpointSharesResponse{
+        Shares: models.ListShares(todo.Id),
+    }
+    if resp.Shares == nil {
+        resp.Shares = []models.Share{}
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
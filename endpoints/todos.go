@@ -5,6 +5,8 @@ import (
     "fmt"
     "encoding/json"
     "net/http"
+    "strconv"
+    "strings"
 
     // HTTP router
     "github.com/julienschmidt/httprouter"
@@ -19,10 +21,18 @@ type (
 
     // List endpoint
     TodosEndpointListRequest struct {
-        Auth    string          `json:"authority"`
+        Auth        string          `json:"authority"`
+        // Only list todos with these tags
+        TagIds      []int           `json:"tag_ids"`
+        // "any" (default) or "all" of the tags
+        TagMode     string          `json:"tag_mode"`
+        // Only list todos assigned to any of these users
+        AssigneeIds []int           `json:"assignee_ids"`
+        // Only list todos on this board, 0 for personal todos
+        BoardId     *int            `json:"board_id"`
     }
     TodosEndpointListResponse struct {
-        Todos   []models.Todo   `json:"todos"`
+        Todos       []models.Todo   `json:"todos"`
     }
 )
 
@@ -41,6 +51,53 @@ func (te TodosEndpoint) List(w http.ResponseWriter, r *http.Request, p httproute
     // Decode into the input type
     decoder.Decode(&telr)
 
+    // Filters may also be given in the query string
+    query := r.URL.Query()
+    if tagIds := query.Get("tag_ids"); len(tagIds) != 0 {
+        for _, s := range strings.Split(tagIds, ",") {
+            id, err := strconv.Atoi(s)
+            if err != nil {
+                w.WriteHeader(400)
+                return
+            }
+            telr.TagIds = append(telr.TagIds, id)
+        }
+    }
+    if tagMode := query.Get("tag_mode"); len(tagMode) != 0 {
+        telr.TagMode = tagMode
+    }
+    if assigneeIds := query.Get("assignee_ids"); len(assigneeIds) != 0 {
+        for _, s := range strings.Split(assigneeIds, ",") {
+            id, err := strconv.Atoi(s)
+            if err != nil {
+                w.WriteHeader(400)
+                return
+            }
+            telr.AssigneeIds = append(telr.AssigneeIds, id)
+        }
+    }
+
+    if boardId := query.Get("board_id"); len(boardId) != 0 {
+        id, err := strconv.Atoi(boardId)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+        telr.BoardId = &id
+    }
+
+    // Check the tag filter
+    if telr.TagMode != "" && telr.TagMode != "any" && telr.TagMode != "all" {
+        w.WriteHeader(400)
+        return
+    }
+    filter := models.TodoFilter{
+        TagIds:         telr.TagIds,
+        MatchAll:       telr.TagMode == "all",
+        AssigneeIds:    telr.AssigneeIds,
+        BoardId:        telr.BoardId,
+    }
+
     // The OwnerId of this request
     var ownerId int = -1
 
@@ -55,20 +112,25 @@ func (te TodosEndpoint) List(w http.ResponseWriter, r *http.Request, p httproute
 
         // Check type
         if token.Type == 1 {
-            // This token is authorized to view private todos from this owner
+            // This token is authorized to view private todos from this owner,
+            // and the ones on their boards or assigned to them
             ownerId = token.OwnerId
         }
+
+        // Tokens limited to a board only see the todos on it
+        scopeFilter(&filter, &token)
     }
 
     // Read all the tags
     resp := TodosEndpointListResponse{
-        Todos: models.ListAllTodos(ownerId),
+        Todos: models.ListTodos(ownerId, filter),
     }
 
     // Create JSON response
     jresp, _ := json.Marshal(resp)
 
     // Write OK + payload
+    w.Header().Set("ETag", listETag(resp.Todos))
     w.WriteHeader(200)
     fmt.Fprintf(w, "%s", jresp)
 }
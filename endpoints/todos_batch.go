@@ -0,0 +1,459 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "time"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Batch endpoint
+    TodosEndpointBatchRequest struct {
+        Auth        string                          `json:"authority"`
+        // "atomic" (default) or "independent"
+        Mode        string                          `json:"mode"`
+        Operations  []TodosEndpointBatchOperation   `json:"operations"`
+    }
+    TodosEndpointBatchOperation struct {
+        // "create", "update", "move" or "delete"
+        Op          string          `json:"op"`
+        // The new todo to create, the fields to change as a JSON Merge Patch
+        // along with the id, or just the id (and version) of the todo
+        Todo        json.RawMessage `json:"todo"`
+        // Where to move the todo to
+        State       int             `json:"state"`
+        Position    int             `json:"position"`
+        // Start the todo even if it is still blocked
+        Force       bool            `json:"force"`
+    }
+    TodosEndpointBatchResult struct {
+        // The HTTP status the operation would have had on its own
+        Status      int             `json:"status"`
+        Error       string          `json:"error,omitempty"`
+        // The todo after the operation, or the current todo on a conflict
+        Todo        *models.Todo    `json:"todo,omitempty"`
+        // The unfinished todos blocking the todo from being started
+        BlockedBy   []int           `json:"blocked_by,omitempty"`
+    }
+    TodosEndpointBatchResponse struct {
+        Error       string                      `json:"error,omitempty"`
+        Results     []TodosEndpointBatchResult  `json:"results"`
+    }
+)
+
+// Most operations a single batch may have
+const maxBatchOperations = 500
+
+// Make up the result of a failed operation
+func batchError(status int, msg string) TodosEndpointBatchResult {
+    return TodosEndpointBatchResult{
+        Status: status,
+        Error:  msg,
+    }
+}
+
+// Read a todo for an operation and check that the owner of the token has at
+// least the given access to it, and that it is not in the trash. Also checks
+// the version if one is given. Returns a result with a zero status if all is
+// well.
+func batchReadTodo(batch *models.TodoBatch, todo *models.Todo, version int, auth *models.Token, access int) TodosEndpointBatchResult {
+    if todo.Id <= 0 || !batch.ReadValues(todo) || todo.DeletedAt != nil {
+        return batchError(400, "Todo not found in database")
+    }
+    if batch.AccessOf(todo, auth) < access {
+        return batchError(403, "User does not own todo")
+    }
+    if version > 0 && version != todo.Version {
+        current := *todo
+        return TodosEndpointBatchResult{
+            Status: 409,
+            Error:  "Todo was changed in the meantime",
+            Todo:   &current,
+        }
+    }
+    return TodosEndpointBatchResult{}
+}
+
+// Check that a todo isn't started while todos blocking it aren't done yet,
+// like checkNotBlocked. Returns a result with a zero status if all is well.
+func batchCheckNotBlocked(batch *models.TodoBatch, todo_id int, oldState int, newState int, force bool) TodosEndpointBatchResult {
+    if force || newState != models.StateInProgress || oldState == models.StateInProgress {
+        return TodosEndpointBatchResult{}
+    }
+    blockers := batch.UnfinishedBlockers(todo_id)
+    if len(blockers) == 0 {
+        return TodosEndpointBatchResult{}
+    }
+    return TodosEndpointBatchResult{
+        Status:     409,
+        Error:      "Todo is blocked by todos that aren't done yet",
+        BlockedBy:  blockers,
+    }
+}
+
+// Create a new todo as part of a batch
+func batchCreate(batch *models.TodoBatch, op *TodosEndpointBatchOperation, auth *models.Token) TodosEndpointBatchResult {
+    if auth.Type > 3 {
+        return batchError(403, "Authorization token lacks creation privilege")
+    }
+
+    var todo models.Todo
+    if err := json.Unmarshal(op.Todo, &todo); err != nil {
+        return batchError(400, "Invalid todo")
+    }
+    if msg := todo.Validate(); len(msg) != 0 {
+        return batchError(400, msg)
+    }
+
+    // New todos go on the board of the token, if it is limited to one
+    if msg := checkNewOnBoard(&todo.BoardId, auth); len(msg) != 0 {
+        return batchError(403, msg)
+    }
+
+    // Todos can only be tagged with the owner's (or board's) own tags
+    todo.NormalizeTags()
+    if !models.TagsOwnedBy(todo.TagIds, auth.OwnerId, todo.BoardId) {
+        return batchError(400, "Tag not found in database")
+    }
+
+    // Check the recurrence rule, if any
+    todo.RRuleStart = time.Time{}
+    if err := todo.PrepareRecurrence(); err != nil {
+        return batchError(400, fmt.Sprintf("Invalid recurrence: %s", err))
+    }
+
+    // Always a new todo, whatever id it came with
+    todo.Id = 0
+    todo.DeletedAt = nil
+    todo.OwnerId = auth.OwnerId
+    todo.Editor = auth
+    if !batch.Insert(&todo) {
+        return batchError(500, "Database error")
+    }
+    return TodosEndpointBatchResult{
+        Status: 200,
+        Todo:   &todo,
+    }
+}
+
+// Change some fields of a todo as part of a batch, like the patch endpoint
+func batchUpdate(batch *models.TodoBatch, op *TodosEndpointBatchOperation, auth *models.Token) TodosEndpointBatchResult {
+    if auth.Type > 2 {
+        return batchError(403, "Authorization token lacks modification privilege")
+    }
+
+    // Find out which todo and version the patch is for
+    var patch map[string]json.RawMessage
+    var target struct {
+        Id      int     `json:"id"`
+        Version int     `json:"version"`
+    }
+    if json.Unmarshal(op.Todo, &patch) != nil || json.Unmarshal(op.Todo, &target) != nil {
+        return batchError(400, "Invalid todo")
+    }
+
+    todo := models.Todo{
+        Id:     target.Id,
+    }
+    access := models.AccessWrite
+    if models.StateOnlyPatch(patch) {
+        access = models.AccessState
+    }
+    if res := batchReadTodo(batch, &todo, target.Version, auth, access); res.Status != 0 {
+        return res
+    }
+    old := todo
+
+    // Apply the changes, field by field
+    if msg := todo.Patch(patch); len(msg) != 0 {
+        return batchError(400, msg)
+    }
+
+    // Only the owner decides who else gets to see the todo
+    if todo.Public != old.Public && batch.AccessOf(&old, auth) < models.AccessOwner {
+        return batchError(403, "Only the owner of the todo can make it public or private")
+    }
+
+    // Todos can only be newly tagged with the owner's (or board's) own tags
+    var newTagIds []int
+    for _, id := range todo.TagIds {
+        if !old.HasTag(id) {
+            newTagIds = append(newTagIds, id)
+        }
+    }
+    if !models.TagsOwnedBy(newTagIds, old.OwnerId, old.BoardId) {
+        return batchError(400, "Tag not found in database")
+    }
+
+    // Keep the same series going unless the rule or time zone changed
+    if todo.RRule != old.RRule || todo.TimeZone != old.TimeZone {
+        todo.RRuleStart = time.Time{}
+    }
+    if err := todo.PrepareRecurrence(); err != nil {
+        return batchError(400, fmt.Sprintf("Invalid recurrence: %s", err))
+    }
+
+    // Todos waiting on others aren't started by accident
+    if res := batchCheckNotBlocked(batch, todo.Id, old.State, todo.State, op.Force); res.Status != 0 {
+        return res
+    }
+
+    // Completing a recurring todo moves it on to the next occurrence
+    if todo.State == models.StateDone && old.State != models.StateDone {
+        todo.Advance(old.State)
+    }
+
+    todo.Editor = auth
+    if !batch.Write(&todo) {
+        return batchError(500, "Database error")
+    }
+    return TodosEndpointBatchResult{
+        Status: 200,
+        Todo:   &todo,
+    }
+}
+
+// Move a todo as part of a batch, like the move endpoint
+func batchMove(batch *models.TodoBatch, op *TodosEndpointBatchOperation, auth *models.Token) TodosEndpointBatchResult {
+    if auth.Type > 2 {
+        return batchError(403, "Authorization token lacks modification privilege")
+    }
+    if op.State < models.StateIdeas || op.State > models.StateDone {
+        return batchError(400, "Invalid state")
+    }
+
+    var target models.Todo
+    if json.Unmarshal(op.Todo, &target) != nil {
+        return batchError(400, "Invalid todo")
+    }
+    todo := models.Todo{
+        Id:     target.Id,
+    }
+    if res := batchReadTodo(batch, &todo, target.Version, auth, models.AccessState); res.Status != 0 {
+        return res
+    }
+
+    // Todos waiting on others aren't started by accident
+    if res := batchCheckNotBlocked(batch, todo.Id, todo.State, op.State, op.Force); res.Status != 0 {
+        return res
+    }
+
+    // Completing a recurring todo moves it on to the next occurrence, in place
+    todo.Editor = auth
+    if op.State == models.StateDone && todo.State != models.StateDone && todo.Advance(todo.State) {
+        if !batch.Write(&todo) {
+            return batchError(500, "Database error")
+        }
+    } else if !batch.Move(&todo, op.State, op.Position) {
+        return batchError(500, "Database error")
+    }
+    return TodosEndpointBatchResult{
+        Status: 200,
+        Todo:   &todo,
+    }
+}
+
+// Move a todo to the trash as part of a batch
+func batchDelete(batch *models.TodoBatch, op *TodosEndpointBatchOperation, auth *models.Token) TodosEndpointBatchResult {
+    if auth.Type != 1 {
+        return batchError(403, "Authorization token lacks removal privilege")
+    }
+
+    var target models.Todo
+    if json.Unmarshal(op.Todo, &target) != nil {
+        return batchError(400, "Invalid todo")
+    }
+    todo := models.Todo{
+        Id:     target.Id,
+    }
+    if res := batchReadTodo(batch, &todo, target.Version, auth, models.AccessOwner); res.Status != 0 {
+        return res
+    }
+
+    todo.Editor = auth
+    if !batch.Remove(&todo) {
+        return batchError(500, "Database error")
+    }
+    return TodosEndpointBatchResult{
+        Status: 200,
+        Todo:   &todo,
+    }
+}
+
+// Run a single operation of a batch
+func batchOperation(batch *models.TodoBatch, op *TodosEndpointBatchOperation, auth *models.Token) TodosEndpointBatchResult {
+    switch op.Op {
+    case "create":
+        return batchCreate(batch, op, auth)
+    case "update":
+        return batchUpdate(batch, op, auth)
+    case "move":
+        return batchMove(batch, op, auth)
+    case "delete":
+        return batchDelete(batch, op, auth)
+    }
+    return batchError(400, fmt.Sprintf("Unknown operation %s", op.Op))
+}
+
+// Run n operations one after the other as part of a batch, undoing the ones
+// that fail on their own. Returns nil if what is left of the batch can't be told
+// anymore.
+func batchIndependently(batch *models.TodoBatch, n int, run func(i int) TodosEndpointBatchResult) []TodosEndpointBatchResult {
+    results := make([]TodosEndpointBatchResult, 0, n)
+    for i := 0; i < n; i++ {
+        if !batch.Savepoint() {
+            results = append(results, batchError(500, "Database error"))
+            continue
+        }
+
+        res := run(i)
+        results = append(results, res)
+
+        if res.Status == 200 {
+            if !batch.ReleaseSavepoint() {
+                results[i] = batchError(500, "Database error")
+            }
+        } else if !batch.RollbackToSavepoint() {
+            return nil
+        }
+    }
+    return results
+}
+
+func (te TodosEndpoint) Batch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tebr TodosEndpointBatchRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&tebr)
+
+    // Check for errors
+    if err != nil || (tebr.Mode != "" && tebr.Mode != "atomic" && tebr.Mode != "independent") {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+    atomic := tebr.Mode != "independent"
+
+    if len(tebr.Operations) > maxBatchOperations {
+        resp := TodosEndpointBatchResponse{
+            Error: fmt.Sprintf("Too many operations, at most %d are allowed", maxBatchOperations),
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Every operation needs a token, which privileges are checked per operation
+    auth := models.Token{
+        Value:  tebr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 3 {
+        // User not authorized
+        resp := TodosEndpointBatchResponse{
+            Error: "Authorization token lacks creation privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // All the operations are done in a single transaction
+    batch := models.BeginTodoBatch()
+    if batch == nil {
+        // Database error
+        resp := TodosEndpointBatchResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    defer batch.Rollback()
+
+    var results []TodosEndpointBatchResult
+    if atomic {
+        results = make([]TodosEndpointBatchResult, 0, len(tebr.Operations))
+        for i := range tebr.Operations {
+            res := batchOperation(batch, &tebr.Operations[i], &auth)
+            results = append(results, res)
+            if res.Status == 200 {
+                continue
+            }
+
+            // Nothing is changed if anything fails
+            resp := TodosEndpointBatchResponse{
+                Error:   fmt.Sprintf("Operation %d failed, nothing was changed", i),
+                Results: results,
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(res.Status)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+    } else {
+        results = batchIndependently(batch, len(tebr.Operations), func(i int) TodosEndpointBatchResult {
+            return batchOperation(batch, &tebr.Operations[i], &auth)
+        })
+        if results == nil {
+            // Can't tell what is left of the batch anymore
+            resp := TodosEndpointBatchResponse{
+                Error: "Database error",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(500)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+    }
+
+    if !batch.Commit() {
+        // Database error
+        resp := TodosEndpointBatchResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything that could be done is done
+    resp := TodosEndpointBatchResponse{
+        Results: results,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
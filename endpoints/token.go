@@ -24,6 +24,8 @@ type (
     }
     TokenEndpointTypeResponse struct {
         Type    int         `json:"type"`
+        OwnerId int         `json:"owner_id,omitempty"`
+        BoardId int         `json:"board_id,omitempty"`
     }
 
     // New endpoint
@@ -32,6 +34,8 @@ type (
         UName   *string     `json:"username"`
         UPwdUH  *string     `json:"password"`
         Auth    *string     `json:"authority"`
+        // Limit the token to a board the user is a member of
+        BoardId int         `json:"board_id"`
     }
     TokenEndpointNewResponse struct {
         Error   string      `json:"error,omitempty"`
@@ -54,6 +58,23 @@ func NewTokenEndpoint() *TokenEndpoint {
     return &TokenEndpoint{}
 }
 
+// Check that the owner of a new token is a member of the board it is limited
+// to, if any. Writes the error response and returns false if not.
+func checkTokenBoard(w http.ResponseWriter, token *models.Token) bool {
+    if token.BoardId != 0 && !models.ValidRole(models.BoardRoleOf(token.BoardId, token.OwnerId)) {
+        resp := TokenEndpointNewResponse{
+            Error: "Board not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    return true
+}
+
 func (te TokenEndpoint) Type(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
     w.Header().Set("Content-Type", "application/json")
 
@@ -83,6 +104,8 @@ func (te TokenEndpoint) Type(w http.ResponseWriter, r *http.Request, p httproute
     // Create response
     resp := TokenEndpointTypeResponse{
         Type:   token.Type,
+        OwnerId:token.OwnerId,
+        BoardId:token.BoardId,
     }
 
     // Create JSON response
@@ -165,6 +188,10 @@ func (te TokenEndpoint) New(w http.ResponseWriter, r *http.Request, p httprouter
         token := models.Token{
             Type:   tenr.Type,
             OwnerId:user.Id,
+            BoardId:tenr.BoardId,
+        }
+        if !checkTokenBoard(w, &token) {
+            return
         }
         token.GenValue()
 
@@ -226,10 +253,29 @@ func (te TokenEndpoint) New(w http.ResponseWriter, r *http.Request, p httprouter
             return
         }
 
-        // Token is authorized, create a new token!
+        // Token is authorized, create a new token! It can't reach any further
+        // than the token it was made with.
         token := models.Token{
             Type:   tenr.Type,
             OwnerId:auth.OwnerId,
+            BoardId:tenr.BoardId,
+        }
+        if auth.BoardId > 0 && token.BoardId == 0 {
+            token.BoardId = auth.BoardId
+        }
+        if auth.BoardId > 0 && token.BoardId != auth.BoardId {
+            resp := TokenEndpointNewResponse{
+                Error: "Authorization token is limited to another board",
+            }
+            jresp, _ := json.Marshal(resp)
+
+            // Write error + payload
+            w.WriteHeader(403)
+            fmt.Fprintf(w, "%s", jresp)
+            return
+        }
+        if !checkTokenBoard(w, &token) {
+            return
         }
         token.GenValue()
 
@@ -0,0 +1,264 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // TrashEndpoint represents the controller for operating on removed todos
+    TrashEndpoint struct {}
+
+    // List endpoint
+    TrashEndpointListRequest struct {
+        Auth    string          `json:"authority"`
+    }
+    TrashEndpointListResponse struct {
+        Error   string          `json:"error,omitempty"`
+        Todos   []models.Todo   `json:"todos"`
+    }
+
+    // Restore endpoint
+    TrashEndpointRestoreRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+    }
+    TrashEndpointRestoreResponse struct {
+        Error   string          `json:"error,omitempty"`
+    }
+
+    // Purge endpoint
+    TrashEndpointPurgeRequest struct {
+        Todo    models.Todo     `json:"todo"`
+        Auth    string          `json:"authority"`
+    }
+    TrashEndpointPurgeResponse struct {
+        Error   string          `json:"error,omitempty"`
+    }
+)
+
+func NewTrashEndpoint() *TrashEndpoint {
+    return &TrashEndpoint{}
+}
+
+// Read a todo in the trash and check that it belongs to the owner of a token.
+// Writes the error response and returns false if not.
+func readTrashedTodo(w http.ResponseWriter, todo *models.Todo, auth *models.Token) bool {
+    if !todo.ReadValues() || todo.DeletedAt == nil {
+        resp := TrashEndpointRestoreResponse{
+            Error: "Todo not found in trash",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    if todo.AccessOf(auth) < models.AccessOwner {
+        // Todo doesn't belong to the right owner
+        resp := TrashEndpointRestoreResponse{
+            Error: "User does not own todo",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    return true
+}
+
+func (te TrashEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var telr TrashEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&telr)
+
+    // Stub an example token
+    auth := models.Token{
+        Value:  telr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := TrashEndpointListResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Read all the removed todos, only the ones on the board of a token limited
+    // to one
+    resp := TrashEndpointListResponse{
+        Todos: []models.Todo{},
+    }
+    for _, todo := range models.ListDeletedTodos(auth.OwnerId) {
+        if auth.BoardId == 0 || auth.BoardId == todo.BoardId {
+            resp.Todos = append(resp.Todos, todo)
+        }
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TrashEndpoint) Restore(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var terr TrashEndpointRestoreRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&terr)
+
+    // Check for errors
+    if err != nil || len(terr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Restoring is modifying, check if auth token <= 2
+    auth := models.Token{
+        Value:  terr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := TrashEndpointRestoreResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Check if the todo is in the trash of the correct person
+    if !readTrashedTodo(w, &terr.Todo, &auth) {
+        return
+    }
+
+    // Ok, looks like we can restore the todo now.
+    terr.Todo.Editor = &auth
+    if !terr.Todo.RestoreFromTrash() {
+        // Database error
+        resp := TrashEndpointRestoreResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TrashEndpointRestoreResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (te TrashEndpoint) Purge(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var tepr TrashEndpointPurgeRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&tepr)
+
+    // Check for errors
+    if err != nil || len(tepr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    // Stub an example token
+    auth := models.Token{
+        Value:  tepr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := TrashEndpointPurgeResponse{
+            Error: "Authorization token lacks removal privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Check if the todo is in the trash of the correct person
+    if !readTrashedTodo(w, &tepr.Todo, &auth) {
+        return
+    }
+
+    // Ok, looks like we can get rid of the todo for good now.
+    tepr.Todo.Editor = &auth
+    if !tepr.Todo.Purge() {
+        // Database error
+        resp := TrashEndpointPurgeResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := TrashEndpointPurgeResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
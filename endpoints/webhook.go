@@ -0,0 +1,474 @@
+package endpoints
+
+import (
+    // stdlib
+    "fmt"
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    // HTTP router
+    "github.com/julienschmidt/httprouter"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // WebhookEndpoint represents the controller for operating on the Webhook resource
+    WebhookEndpoint struct {}
+
+    // Update endpoint
+    WebhookEndpointUpdateRequest struct {
+        Webhook     models.Webhook      `json:"webhook"`
+        Auth        string              `json:"authority"`
+    }
+    WebhookEndpointUpdateResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Webhook     *models.Webhook     `json:"webhook,omitempty"`
+    }
+
+    // List endpoint
+    WebhookEndpointListRequest struct {
+        Auth        string              `json:"authority"`
+    }
+    WebhookEndpointListResponse struct {
+        Error       string              `json:"error,omitempty"`
+        Webhooks    []models.Webhook    `json:"webhooks"`
+    }
+
+    // Remove endpoint
+    WebhookEndpointRemoveRequest struct {
+        Webhook     models.Webhook      `json:"webhook"`
+        Auth        string              `json:"authority"`
+    }
+
+    // Deliveries endpoint
+    WebhookEndpointDeliveriesRequest struct {
+        Webhook     models.Webhook      `json:"webhook"`
+        Auth        string              `json:"authority"`
+    }
+    WebhookEndpointDeliveriesResponse struct {
+        Error       string                      `json:"error,omitempty"`
+        Deliveries  []models.WebhookDelivery    `json:"deliveries"`
+    }
+
+    // Redeliver endpoint
+    WebhookEndpointRedeliverRequest struct {
+        Delivery    models.WebhookDelivery      `json:"delivery"`
+        Auth        string                      `json:"authority"`
+    }
+    WebhookEndpointRedeliverResponse struct {
+        Error       string                      `json:"error,omitempty"`
+        Delivery    *models.WebhookDelivery     `json:"delivery,omitempty"`
+    }
+)
+
+// How many of the latest deliveries to a webhook are listed
+const webhookDeliveryLogSize = 100
+
+func NewWebhookEndpoint() *WebhookEndpoint {
+    return &WebhookEndpoint{}
+}
+
+// Read in a webhook and check that it belongs to the owner of a token, and to
+// the board the token is limited to, if any. Writes the error response and
+// returns false if not.
+func readOwnWebhook(w http.ResponseWriter, hook *models.Webhook, auth *models.Token) bool {
+    if !hook.ReadValues() || hook.OwnerId != auth.OwnerId || (auth.BoardId > 0 && hook.BoardId != auth.BoardId) {
+        resp := errorResponse{
+            Error: "Webhook not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return false
+    }
+    return true
+}
+
+func (we WebhookEndpoint) Update(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var weur WebhookEndpointUpdateRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&weur)
+
+    // Check for errors
+    if err != nil || len(weur.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  weur.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := WebhookEndpointUpdateResponse{
+            Error: "Authorization token lacks privilege to change webhooks",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Start from the webhook as it is, or a new one
+    hook := models.Webhook{
+        Id:         weur.Webhook.Id,
+        OwnerId:    auth.OwnerId,
+        BoardId:    auth.BoardId,
+        Active:     true,
+    }
+    if hook.Id > 0 {
+        if !readOwnWebhook(w, &hook, &auth) {
+            return
+        }
+        hook.Active = weur.Webhook.Active
+    } else {
+        hook.Id = 0
+    }
+    hook.Url = weur.Webhook.Url
+    hook.Events = []string{}
+    hook.TagId = weur.Webhook.TagId
+    hook.Secret = weur.Webhook.Secret
+
+    // Check what the webhook wants to be told about
+    msg := hook.Validate()
+    seen := make(map[string]bool)
+    for _, event := range weur.Webhook.Events {
+        switch event {
+        case StreamCreated, StreamUpdated, StreamMoved, StreamDeleted:
+            if !seen[event] {
+                seen[event] = true
+                hook.Events = append(hook.Events, event)
+            }
+        default:
+            msg = fmt.Sprintf("Unknown event %s", event)
+        }
+    }
+    if len(msg) == 0 && hook.TagId > 0 && !models.TagsOwnedBy([]int{hook.TagId}, auth.OwnerId, hook.BoardId) {
+        msg = "Tag not found in database"
+    }
+    if len(msg) != 0 {
+        resp := WebhookEndpointUpdateResponse{
+            Error: msg,
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Everything looks good! Save the webhook
+    var ok bool
+    if hook.Id > 0 {
+        ok = hook.WriteValues()
+    } else {
+        ok = hook.InsertValues()
+    }
+    if !ok {
+        // Database error
+        resp := WebhookEndpointUpdateResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := WebhookEndpointUpdateResponse{
+        Webhook:    &hook,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (we WebhookEndpoint) List(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var welr WebhookEndpointListRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&welr)
+
+    // The token may also be given in the query string
+    if authority := r.URL.Query().Get("authority"); len(authority) != 0 {
+        welr.Auth = authority
+    }
+
+    auth := models.Token{
+        Value:  welr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := WebhookEndpointListResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Read all the webhooks, only the ones on the board of a token limited to
+    // one
+    resp := WebhookEndpointListResponse{
+        Webhooks:   []models.Webhook{},
+    }
+    for _, hook := range models.ListWebhooks(auth.OwnerId) {
+        if auth.BoardId == 0 || hook.BoardId == auth.BoardId {
+            resp.Webhooks = append(resp.Webhooks, hook)
+        }
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (we WebhookEndpoint) Remove(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var werr WebhookEndpointRemoveRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&werr)
+
+    // Check for errors
+    if err != nil || len(werr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  werr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type != 1 {
+        // User not authorized
+        resp := errorResponse{
+            Error: "Authorization token lacks privilege to change webhooks",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    hook := models.Webhook{
+        Id:     werr.Webhook.Id,
+    }
+    if !readOwnWebhook(w, &hook, &auth) {
+        return
+    }
+
+    if !hook.Remove() {
+        // Database error
+        resp := errorResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Create response
+    resp := errorResponse{}
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (we WebhookEndpoint) Deliveries(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var wedr WebhookEndpointDeliveriesRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    decoder.Decode(&wedr)
+
+    // The token and webhook may also be given in the query string
+    if authority := r.URL.Query().Get("authority"); len(authority) != 0 {
+        wedr.Auth = authority
+    }
+    if id := r.URL.Query().Get("webhook_id"); len(id) != 0 {
+        var err error
+        wedr.Webhook.Id, err = strconv.Atoi(id)
+        if err != nil {
+            w.WriteHeader(400)
+            return
+        }
+    }
+
+    auth := models.Token{
+        Value:  wedr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := WebhookEndpointDeliveriesResponse{
+            Error: "Authorization token lacks information privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    hook := models.Webhook{
+        Id:     wedr.Webhook.Id,
+    }
+    if !readOwnWebhook(w, &hook, &auth) {
+        return
+    }
+
+    // Read the latest deliveries
+    resp := WebhookEndpointDeliveriesResponse{
+        Deliveries: models.ListWebhookDeliveries(hook.Id, webhookDeliveryLogSize),
+    }
+    if resp.Deliveries == nil {
+        resp.Deliveries = []models.WebhookDelivery{}
+    }
+
+    // Create JSON response
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
+
+func (we WebhookEndpoint) Redeliver(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Input type
+    var werr WebhookEndpointRedeliverRequest
+
+    // Create a decoder
+    decoder := json.NewDecoder(r.Body)
+    // Decode into the input type
+    err := decoder.Decode(&werr)
+
+    // Check for errors
+    if err != nil || len(werr.Auth) == 0 {
+        // Failed to parse user input... call it a user error
+        w.WriteHeader(400)
+        return
+    }
+
+    auth := models.Token{
+        Value:  werr.Auth,
+    }
+
+    // Check the privileges on the auth token
+    if !auth.ReadValues() || auth.Type > 2 {
+        // User not authorized
+        resp := WebhookEndpointRedeliverResponse{
+            Error: "Authorization token lacks modification privilege",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(403)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+
+    // Only deliveries to the token owner's webhooks can be sent again
+    delivery := models.WebhookDelivery{
+        Id:     werr.Delivery.Id,
+    }
+    if !delivery.ReadValues() {
+        resp := WebhookEndpointRedeliverResponse{
+            Error: "Delivery not found in database",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(400)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    hook := models.Webhook{
+        Id:     delivery.WebhookId,
+    }
+    if !readOwnWebhook(w, &hook, &auth) {
+        return
+    }
+
+    if !delivery.Redeliver() {
+        // Database error
+        resp := WebhookEndpointRedeliverResponse{
+            Error: "Database error",
+        }
+        jresp, _ := json.Marshal(resp)
+
+        // Write error + payload
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "%s", jresp)
+        return
+    }
+    wakeWebhooks()
+
+    // Create response
+    resp := WebhookEndpointRedeliverResponse{
+        Delivery:   &delivery,
+    }
+    jresp, _ := json.Marshal(resp)
+
+    // Write OK + payload
+    w.WriteHeader(200)
+    fmt.Fprintf(w, "%s", jresp)
+}
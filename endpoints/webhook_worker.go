@@ -0,0 +1,286 @@
+package endpoints
+
+import (
+    // stdlib
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+type (
+    // Payload delivered to a webhook for a change to a todo
+    WebhookEndpointPayload struct {
+        // What the change looks like to the owner of the webhook, as in the
+        // stream of todo changes
+        Event       string          `json:"event"`
+        // The change in the history of the todo
+        EventId     int             `json:"event_id"`
+        WebhookId   int             `json:"webhook_id"`
+        Time        time.Time       `json:"time"`
+        TodoId      int             `json:"todo_id"`
+        // The todo after the change, left out when it was deleted, or can no
+        // longer be seen
+        Todo        *models.Todo    `json:"todo,omitempty"`
+        // Who made the change, 0 if done by the server
+        UserId      int             `json:"user_id"`
+        UserName    string          `json:"user_name"`
+    }
+)
+
+// How often to look for deliveries that are due again
+var webhookPollInterval = 5 * time.Second
+
+// How long a webhook has to respond
+var webhookTimeout = 10 * time.Second
+
+// How many changes or deliveries to read from the database at once
+const webhookBatchSize = 100
+
+// Wakes up the worker when a delivery is queued up outside of it
+var webhookWake = make(chan struct{}, 1)
+
+// Webhooks that deliveries are being attempted to right now
+var (
+    webhookBusy     = make(map[int]bool)
+    webhookBusyLock sync.Mutex
+)
+
+// Let the worker know that there are deliveries to attempt right away
+func wakeWebhooks() {
+    select {
+    case webhookWake <- struct{}{}:
+    default:
+    }
+}
+
+// Told to a webhook connecting to an address it may not be sent to
+var errWebhookAddress = errors.New("webhook address is not allowed")
+
+// Make up the client deliveries are sent with. The address is checked as it is
+// connected to, after the host has been resolved, so that a host resolving to
+// another address than when the webhook was saved can't get around the check.
+func newWebhookClient() *http.Client {
+    dialer := &net.Dialer{
+        Timeout:    webhookTimeout,
+        Control: func(network, address string, c syscall.RawConn) error {
+            host, _, err := net.SplitHostPort(address)
+            if err != nil {
+                return err
+            }
+            if ip := net.ParseIP(host); ip == nil || !models.WebhookAddressAllowed(ip) {
+                return errWebhookAddress
+            }
+            return nil
+        },
+    }
+    return &http.Client{
+        Timeout:    webhookTimeout,
+        // Straight to the webhook, as the address of a proxy is all that
+        // would be checked otherwise
+        Transport:  &http.Transport{
+            DialContext:            dialer.DialContext,
+            TLSHandshakeTimeout:    webhookTimeout,
+            IdleConnTimeout:        90 * time.Second,
+            ForceAttemptHTTP2:      true,
+        },
+        // Redirects are taken as failed deliveries, not followed
+        CheckRedirect: func(req *http.Request, via []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+}
+
+// Deliver the changes to todos to webhooks as they are made, forever
+func DeliverWebhooksForever() {
+    // Listen before reading, so that no change is noticed late
+    sub := models.SubscribeTodoEvents()
+    defer sub.Close()
+
+    client := newWebhookClient()
+    poll := time.NewTicker(webhookPollInterval)
+    defer poll.Stop()
+    for {
+        queueWebhookDeliveries()
+        attemptWebhookDeliveries(client)
+
+        // Wait for more
+        select {
+        case <-sub.C:
+        case <-webhookWake:
+        case <-poll.C:
+        }
+    }
+}
+
+// Queue up a delivery to every active webhook for every change it wants to be
+// told about
+func queueWebhookDeliveries() {
+    for _, hook := range models.ListActiveWebhooks() {
+        // Changes are seen as by the owner of the webhook
+        auth := models.Token{
+            OwnerId:    hook.OwnerId,
+            BoardId:    hook.BoardId,
+        }
+        var tags map[int]bool
+        if hook.TagId > 0 {
+            tags = make(map[int]bool)
+            for _, id := range models.TagDescendants([]int{hook.TagId}) {
+                tags[id] = true
+            }
+        }
+
+        for {
+            events := models.ListTodoEventsSince(hook.LastEventId, webhookBatchSize)
+            if len(events) == 0 {
+                break
+            }
+
+            var deliveries []models.WebhookDelivery
+            for i := range events {
+                if delivery := webhookDeliveryOf(&hook, &events[i], &auth, tags); delivery != nil {
+                    deliveries = append(deliveries, *delivery)
+                }
+            }
+            if !hook.Enqueue(deliveries, events[len(events) - 1].Id) || len(events) < webhookBatchSize {
+                break
+            }
+        }
+    }
+}
+
+// Make up the delivery of a change to a webhook. Returns nil if the webhook
+// doesn't want to be told about it.
+func webhookDeliveryOf(hook *models.Webhook, event *models.TodoEvent, auth *models.Token, tags map[int]bool) *models.WebhookDelivery {
+    kind := streamEventKind(event, auth)
+    if len(kind) == 0 || !hook.Wants(kind) {
+        return nil
+    }
+
+    // A todo losing the tag is a change to a todo with the tag all the same
+    if tags != nil {
+        tagged := false
+        previous := event.Previous()
+        for _, ids := range [][]int{event.Todo.TagIds, previous.TagIds} {
+            for _, id := range ids {
+                tagged = tagged || tags[id]
+            }
+        }
+        if !tagged {
+            return nil
+        }
+    }
+
+    payload := WebhookEndpointPayload{
+        Event:      kind,
+        EventId:    event.Id,
+        WebhookId:  hook.Id,
+        Time:       event.Time,
+        TodoId:     event.TodoId,
+        UserId:     event.UserId,
+        UserName:   event.UserName,
+    }
+    if kind != StreamDeleted {
+        payload.Todo = &event.Todo
+    }
+    jpayload, _ := json.Marshal(payload)
+
+    return &models.WebhookDelivery{
+        WebhookId:  hook.Id,
+        EventId:    event.Id,
+        Event:      kind,
+        Payload:    jpayload,
+    }
+}
+
+// Attempt all the deliveries that are due. Every webhook gets its own
+// goroutine, so that a slow one doesn't hold up the others, and its deliveries
+// are attempted in order.
+func attemptWebhookDeliveries(client *http.Client) {
+    for _, id := range models.ListDueWebhooks(time.Now()) {
+        webhookBusyLock.Lock()
+        busy := webhookBusy[id]
+        webhookBusy[id] = true
+        webhookBusyLock.Unlock()
+        if busy {
+            // Still at it since last time
+            continue
+        }
+
+        go func(id int) {
+            defer func() {
+                webhookBusyLock.Lock()
+                delete(webhookBusy, id)
+                webhookBusyLock.Unlock()
+            }()
+            attemptWebhookDeliveriesTo(client, id)
+        }(id)
+    }
+}
+
+// Attempt the deliveries to a webhook that are due, until none are left
+func attemptWebhookDeliveriesTo(client *http.Client, webhook_id int) {
+    for {
+        deliveries := models.ListDueWebhookDeliveries(webhook_id, time.Now(), webhookBatchSize)
+        for i := range deliveries {
+            if !attemptWebhookDelivery(client, &deliveries[i]) {
+                // Don't attempt the same deliveries over and over
+                return
+            }
+        }
+        if len(deliveries) < webhookBatchSize {
+            return
+        }
+    }
+}
+
+// POST a delivery to its webhook, signed with the secret of the webhook, and
+// record how it went. Returns false if that couldn't be recorded.
+func attemptWebhookDelivery(client *http.Client, delivery *models.WebhookDelivery) bool {
+    hook := models.Webhook{
+        Id:     delivery.WebhookId,
+    }
+    if !hook.ReadValues() {
+        return delivery.Abandon("Webhook not found in database")
+    }
+    if !hook.Active {
+        return delivery.Abandon("Webhook is turned off")
+    }
+
+    req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(delivery.Payload))
+    if err != nil {
+        return delivery.Attempted(0, err.Error())
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "gotodo-webhook")
+    req.Header.Set("X-Gotodo-Event", delivery.Event)
+    req.Header.Set("X-Gotodo-Delivery", strconv.Itoa(delivery.Id))
+    req.Header.Set("X-Gotodo-Signature", hook.Sign(delivery.Payload))
+
+    resp, err := client.Do(req)
+    if errors.Is(err, errWebhookAddress) {
+        // Trying again won't help
+        return delivery.Abandon("Webhook URL points to a private address")
+    }
+    if err != nil {
+        return delivery.Attempted(0, err.Error())
+    }
+    // Read a bit of the response, so that the connection can be used again
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 64 << 10))
+    resp.Body.Close()
+
+    message := ""
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        message = "Webhook responded with " + resp.Status
+    }
+    return delivery.Attempted(resp.StatusCode, message)
+}
@@ -0,0 +1,41 @@
+package endpoints
+
+import (
+    // stdlib
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    // own stuff
+    "github.com/ohnx/gotodo/models"
+)
+
+// Deliveries can't reach addresses next to the server, whatever the host of the
+// webhook resolved to when it was saved
+func TestWebhookClientPrivateAddress(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(204)
+    }))
+    defer server.Close()
+
+    client := newWebhookClient()
+    _, err := client.Post(server.URL, "application/json", nil)
+    if !errors.Is(err, errWebhookAddress) {
+        t.Errorf("delivery to %s gave error %v, want it refused", server.URL, err)
+    }
+
+    // Unless the server allows it
+    models.WebhookAllowPrivate = true
+    defer func() {
+        models.WebhookAllowPrivate = false
+    }()
+    resp, err := client.Post(server.URL, "application/json", nil)
+    if err != nil {
+        t.Fatalf("delivery to an allowed private address failed: %s", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != 204 {
+        t.Errorf("delivery got status %d, want 204", resp.StatusCode)
+    }
+}
@@ -4,9 +4,11 @@ go 1.19
 
 require (
 	github.com/emersion/go-smtp v0.15.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mattn/go-sqlite3 v1.14.15
 	github.com/rs/cors v1.8.2
+	github.com/teambition/rrule-go v1.8.2
 )
 
 require (
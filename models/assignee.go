@@ -0,0 +1,179 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+    "sort"
+    "strings"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a user a todo is assigned to
+    Assignee struct {
+        Id      int     `json:"id"`
+        Name    string  `json:"name"`
+    }
+)
+
+// Column expression reading the ids of the users a todo is assigned to, as a
+// comma-separated list. Used in place of a column when selecting from todos.
+const todoAssigneesColumn = "(SELECT IFNULL(group_concat(user_id), '') FROM (SELECT user_id FROM todo_assignees WHERE todo_id = todos.id ORDER BY user_id))"
+
+// Read in the users a todo is assigned to. Returns true if values were read.
+func (todo *Todo) ReadAssignees() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT u.id, u.name FROM todo_assignees a JOIN users u ON u.id = a.user_id WHERE a.todo_id = ? ORDER BY u.id", todo.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return false
+    }
+    defer res.Close()
+
+    // Check results
+    todo.Assignees = nil
+    for res.Next() {
+        var assignee Assignee
+        err = res.Scan(&assignee.Id, &assignee.Name)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return false
+        }
+        todo.Assignees = append(todo.Assignees, assignee)
+    }
+
+    // Done
+    return true
+}
+
+// Replace the users a todo is assigned to, recording the users that changed
+// for in the history. Returns true on success.
+func (todo *Todo) WriteAssignees(user_ids []int) bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 {
+        return false
+    }
+
+    return inTransaction(func(tx *sql.Tx) error {
+        // Find out who was assigned before
+        res, err := tx.Query("SELECT user_id FROM todo_assignees WHERE todo_id = ?", todo.Id)
+        if err != nil {
+            return err
+        }
+        assigned := make(map[int]bool)
+        for res.Next() {
+            var user_id int
+            err = res.Scan(&user_id)
+            if err != nil {
+                res.Close()
+                return err
+            }
+            assigned[user_id] = true
+        }
+        res.Close()
+
+        _, err = tx.Exec("DELETE FROM todo_assignees WHERE todo_id = ?", todo.Id)
+        if err != nil {
+            return err
+        }
+        var changed []int
+        for _, user_id := range user_ids {
+            res, err := tx.Exec("INSERT OR IGNORE INTO todo_assignees(todo_id, user_id) values(?,?)", todo.Id, user_id)
+            if err != nil {
+                return err
+            }
+            if n, _ := res.RowsAffected(); n == 0 {
+                // Listed twice
+                continue
+            }
+            if assigned[user_id] {
+                delete(assigned, user_id)
+            } else {
+                changed = append(changed, user_id)
+            }
+        }
+        // The ones left aren't assigned anymore
+        for user_id := range assigned {
+            changed = append(changed, user_id)
+        }
+        sort.Ints(changed)
+
+        return recordAccessEvent(tx, todo.Id, changed, todo.Editor)
+    })
+}
+
+// Look up the ids of users by name, in the same order. Returns the first name
+// that isn't known too, or "" if all of them are.
+func UserIdsByName(names []string) ([]int, string) {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    var r []int
+    for _, name := range names {
+        var id int
+        err := conn.QueryRow("SELECT id FROM users WHERE name = ?", name).Scan(&id)
+        if err != nil {
+            if err != sql.ErrNoRows {
+                log.Printf("Warning: Failed to read database: %s", err)
+            }
+            return nil, name
+        }
+        r = append(r, id)
+    }
+    return r, ""
+}
+
+// Fill in the names of the assignees of todos, which only have their ids
+func readAssigneeNames(todos []Todo) bool {
+    // Find all the users that are needed
+    seen := make(map[int]bool)
+    var args []interface{}
+    for _, todo := range todos {
+        for _, assignee := range todo.Assignees {
+            if !seen[assignee.Id] {
+                seen[assignee.Id] = true
+                args = append(args, assignee.Id)
+            }
+        }
+    }
+    if len(args) == 0 {
+        return true
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT id, name FROM users WHERE id IN (?" + strings.Repeat(",?", len(args) - 1) + ")", args...)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return false
+    }
+    defer res.Close()
+
+    // Check results
+    names := make(map[int]string)
+    for res.Next() {
+        var id int
+        var name string
+        err = res.Scan(&id, &name)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return false
+        }
+        names[id] = name
+    }
+
+    for i := range todos {
+        for j := range todos[i].Assignees {
+            todos[i].Assignees[j].Name = names[todos[i].Assignees[j].Id]
+        }
+    }
+    return true
+}
@@ -0,0 +1,189 @@
+package models
+
+import (
+    // Standard library
+    "io"
+    "log"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+    "github.com/ohnx/gotodo/storage"
+)
+
+type (
+    // Represent a file attached to a todo. The content itself is kept in the
+    // blob store under StorageKey.
+    Attachment struct {
+        Id          int         `json:"id"`
+        TodoId      int         `json:"todo_id"`
+        UploaderId  int         `json:"uploader_id"`
+        // File name given by the uploader
+        Name        string      `json:"name"`
+        MimeType    string      `json:"mime_type"`
+        // In bytes
+        Size        int64       `json:"size"`
+        StorageKey  string      `json:"-"`
+        Created     time.Time   `json:"created"`
+    }
+)
+
+// Biggest attachment accepted, in bytes
+var MaxAttachmentSize int64 = 10 << 20
+
+// Types of content accepted as attachments
+var AttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "text/plain", "application/pdf", "application/zip", "application/x-gzip"}
+
+// Check whether content of a type may be attached
+func AttachmentTypeAllowed(mime_type string) bool {
+    for _, t := range AttachmentTypes {
+        if t == mime_type {
+            return true
+        }
+    }
+    return false
+}
+
+// Store the content read from r and insert a new attachment for it. Returns
+// true on success, false on error.
+func (attachment *Attachment) InsertValues(r io.Reader) bool {
+    // Check that there is no input Id
+    if attachment.Id > 0 || attachment.TodoId <= 0 {
+        return false
+    }
+
+    // Store the content first, so there is never an attachment without it
+    attachment.StorageKey = storage.NewKey()
+    err := storage.GetStore().Put(attachment.StorageKey, r)
+    if err != nil {
+        log.Printf("Warning: Failed to write to storage: %s", err)
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute insert statement
+    attachment.Created = time.Now().UTC()
+    res, err := conn.Exec("INSERT INTO attachments(todo_id, uploader_id, name, mime_type, size, storage_key, created) values(?,?,?,?,?,?,?)",
+        attachment.TodoId, attachment.UploaderId, attachment.Name, attachment.MimeType, attachment.Size, attachment.StorageKey, attachment.Created)
+    if err == nil {
+        var id int64
+        id, err = res.LastInsertId()
+        attachment.Id = int(id)
+    }
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        storage.GetStore().Delete(attachment.StorageKey)
+        attachment.Id = 0
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Read in the values of an attachment based on id. Returns true if values were
+// read.
+func (attachment *Attachment) ReadValues() bool {
+    // Check that there is an input Id
+    if attachment.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := conn.QueryRow("SELECT todo_id, uploader_id, name, mime_type, size, storage_key, created FROM attachments WHERE id = ?", attachment.Id).Scan(
+        &attachment.TodoId, &attachment.UploaderId, &attachment.Name, &attachment.MimeType, &attachment.Size, &attachment.StorageKey, &attachment.Created)
+    if err != nil {
+        // No results
+        return false
+    }
+
+    return true
+}
+
+// Open the content of an attachment, which the caller has to close. Returns nil
+// on error.
+func (attachment *Attachment) Open() io.ReadCloser {
+    f, err := storage.GetStore().Get(attachment.StorageKey)
+    if err != nil {
+        log.Printf("Warning: Failed to read storage: %s", err)
+        return nil
+    }
+    return f
+}
+
+// Remove an attachment and its content based on Id. Returns true on success.
+func (attachment *Attachment) Remove() bool {
+    // Check that there is an input Id
+    if attachment.Id <= 0 || !attachment.ReadValues() {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute delete statement
+    _, err := conn.Exec("DELETE FROM attachments WHERE id = ?", attachment.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // The content is gone for good once nothing refers to it anymore
+    deleteBlobs([]string{attachment.StorageKey})
+    return true
+}
+
+// List the attachments of a todo, oldest first
+func ListAttachments(todo_id int) []Attachment {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare read statement
+    stmt, err := conn.Prepare("SELECT id, todo_id, uploader_id, name, mime_type, size, storage_key, created FROM attachments WHERE todo_id = ? ORDER BY id")
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer stmt.Close()
+
+    // Execute read statement
+    res, err := stmt.Query(todo_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Create new slice for storing output
+    var r []Attachment
+
+    // Check results
+    for res.Next() {
+        var attachment Attachment
+        err = res.Scan(&attachment.Id, &attachment.TodoId, &attachment.UploaderId, &attachment.Name, &attachment.MimeType, &attachment.Size, &attachment.StorageKey, &attachment.Created)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+
+        // No errors, append to slice
+        r = append(r, attachment)
+    }
+
+    // Done
+    return r
+}
+
+// Remove content from the blob store, logging what can't be removed
+func deleteBlobs(keys []string) {
+    for _, key := range keys {
+        if err := storage.GetStore().Delete(key); err != nil {
+            log.Printf("Warning: Failed to write to storage: %s", err)
+        }
+    }
+}
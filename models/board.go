@@ -0,0 +1,343 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a board shared by a team, owning todos and tags of its own
+    Board struct {
+        Id      int         `json:"id"`
+        Name    string      `json:"name"`
+        Created time.Time   `json:"created"`
+        // Role of the user the boards were listed for, only filled in when
+        // listing boards
+        Role    string      `json:"role,omitempty"`
+    }
+
+    // Represent a user's membership of a board
+    BoardMember struct {
+        BoardId int     `json:"board_id"`
+        UserId  int     `json:"user_id"`
+        Name    string  `json:"name"`
+        // One of RoleViewer, RoleMember, RoleAdmin or RoleOwner
+        Role    string  `json:"role"`
+        // Who is changing the member, for the history of the todos on the
+        // board
+        Editor  *Token  `json:"-"`
+    }
+)
+
+// What a member can do on a board, each role allowing everything the ones
+// before it do
+const (
+    // Can see the todos and tags of the board
+    RoleViewer  = "viewer"
+    // Can also create todos, change all of them and remove the ones they
+    // created, and create and change tags
+    RoleMember  = "member"
+    // Can also remove any todo and tag, and manage the members
+    RoleAdmin   = "admin"
+    // Can also rename and remove the board, and manage the other owners
+    RoleOwner   = "owner"
+)
+
+// Longest allowed board name, in characters
+const MaxBoardName = 64
+
+// Order the roles, 0 for anything that isn't one
+func roleRank(role string) int {
+    switch role {
+    case RoleViewer:
+        return 1
+    case RoleMember:
+        return 2
+    case RoleAdmin:
+        return 3
+    case RoleOwner:
+        return 4
+    }
+    return 0
+}
+
+// Check whether a role is one of the known ones
+func ValidRole(role string) bool {
+    return roleRank(role) > 0
+}
+
+// Check whether a role allows at least everything another one does. Not being
+// a member at all ("") allows nothing.
+func RoleAtLeast(role string, min string) bool {
+    return ValidRole(role) && roleRank(role) >= roleRank(min)
+}
+
+// Check the name of a board. Returns a friendly error message, or "" if the
+// board is valid.
+func (board *Board) Validate() string {
+    board.Name = strings.TrimSpace(board.Name)
+    if len(board.Name) == 0 || utf8.RuneCountInString(board.Name) > MaxBoardName {
+        return "Board name must be between 1 and 64 characters"
+    }
+    return ""
+}
+
+// Inserts a new board, owned by owner_id. Returns true on success, false on
+// error.
+func (board *Board) InsertValues(owner_id int) bool {
+    // Check that there is no input Id
+    if board.Id > 0 || len(board.Name) == 0 {
+        return false
+    }
+
+    board.Created = time.Now().UTC()
+    return inTransaction(func(tx *sql.Tx) error {
+        res, err := tx.Exec("INSERT INTO boards(name, created) values(?,?)", board.Name, board.Created)
+        if err != nil {
+            return err
+        }
+
+        // Find out the new id to make the owner a member of
+        id, err := res.LastInsertId()
+        if err != nil {
+            return err
+        }
+        board.Id = int(id)
+
+        _, err = tx.Exec("INSERT INTO board_members(board_id, user_id, role) values(?,?,?)", board.Id, owner_id, RoleOwner)
+        return err
+    })
+}
+
+// Updates the name of an existing board. Returns true on success, false on
+// error.
+func (board *Board) WriteValues() bool {
+    // Check that there is an input Id
+    if board.Id <= 0 || len(board.Name) == 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE boards SET name = ? WHERE id = ?", board.Name, board.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Read in the values of a board based on id. Returns true if values were read.
+func (board *Board) ReadValues() bool {
+    // Check that there is an input Id
+    if board.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := conn.QueryRow("SELECT id, name, created FROM boards WHERE id = ?", board.Id).Scan(&board.Id, &board.Name, &board.Created)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    return true
+}
+
+// Check whether a board still has todos, including the ones in the trash
+func (board *Board) HasTodos() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    var found bool
+    err := conn.QueryRow("SELECT EXISTS (SELECT 1 FROM todos WHERE board_id = ?)", board.Id).Scan(&found)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return true
+    }
+
+    return found
+}
+
+// Remove a board along with its members, its tags and the tokens limited to
+// it. The board should not have any todos left. Returns true on successful
+// removal.
+func (board *Board) Remove() bool {
+    // Check that there is an input Id
+    if board.Id <= 0 {
+        return false
+    }
+
+    return inTransaction(func(tx *sql.Tx) error {
+        for _, query := range []string{
+            "DELETE FROM tokens WHERE board_id = ?",
+            "DELETE FROM tags WHERE board_id = ?",
+            "DELETE FROM board_members WHERE board_id = ?",
+            "DELETE FROM boards WHERE id = ?",
+        } {
+            if _, err := tx.Exec(query, board.Id); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// List the boards a user is a member of, along with their role on each
+func ListBoards(user_id int) []Board {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT b.id, b.name, b.created, m.role FROM boards b JOIN board_members m ON m.board_id = b.id WHERE m.user_id = ? ORDER BY b.name, b.id", user_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []Board
+    for res.Next() {
+        var board Board
+        err = res.Scan(&board.Id, &board.Name, &board.Created, &board.Role)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, board)
+    }
+
+    // Done
+    return r
+}
+
+// Find out the role of a user on a board, "" if they are not a member
+func BoardRoleOf(board_id int, user_id int) string {
+    return boardRoleOf(database.GetConnection(), board_id, user_id)
+}
+
+func boardRoleOf(db dbHandle, board_id int, user_id int) string {
+    var role string
+    err := db.QueryRow("SELECT role FROM board_members WHERE board_id = ? AND user_id = ?", board_id, user_id).Scan(&role)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return ""
+    }
+    return role
+}
+
+// Add a member to a board, or change their role, recording it in the history
+// of the todos on the board. Returns true on success.
+func (member *BoardMember) WriteValues() bool {
+    // Check that there are input Ids
+    if member.BoardId <= 0 || member.UserId <= 0 || !ValidRole(member.Role) {
+        return false
+    }
+
+    return inTransaction(func(tx *sql.Tx) error {
+        role := boardRoleOf(tx, member.BoardId, member.UserId)
+
+        // Execute insert statement
+        _, err := tx.Exec("INSERT OR REPLACE INTO board_members(board_id, user_id, role) values(?,?,?)", member.BoardId, member.UserId, member.Role)
+        if err != nil || role == member.Role {
+            return err
+        }
+        return member.recordAccess(tx)
+    })
+}
+
+// Take a member off a board, along with their tokens limited to it, recording
+// it in the history of the todos on the board. Returns true on success.
+func (member *BoardMember) Remove() bool {
+    return inTransaction(func(tx *sql.Tx) error {
+        res, err := tx.Exec("DELETE FROM board_members WHERE board_id = ? AND user_id = ?", member.BoardId, member.UserId)
+        if err != nil {
+            return err
+        }
+        _, err = tx.Exec("DELETE FROM tokens WHERE board_id = ? AND owner_id = ?", member.BoardId, member.UserId)
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err != nil || n == 0 {
+            // Wasn't a member
+            return err
+        }
+        return member.recordAccess(tx)
+    })
+}
+
+// Record that what a member can do changed in the history of every todo on the
+// board, leaving out the ones in the trash
+func (member *BoardMember) recordAccess(tx dbHandle) error {
+    res, err := tx.Query("SELECT id FROM todos WHERE board_id = ? AND deleted_at IS NULL ORDER BY id", member.BoardId)
+    if err != nil {
+        return err
+    }
+    var ids []int
+    for res.Next() {
+        var id int
+        err = res.Scan(&id)
+        if err != nil {
+            res.Close()
+            return err
+        }
+        ids = append(ids, id)
+    }
+    res.Close()
+
+    for _, id := range ids {
+        err = recordAccessEvent(tx, id, []int{member.UserId}, member.Editor)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// List the members of a board
+func ListBoardMembers(board_id int) []BoardMember {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT m.board_id, m.user_id, u.name, m.role FROM board_members m JOIN users u ON u.id = m.user_id WHERE m.board_id = ? ORDER BY u.name", board_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []BoardMember
+    for res.Next() {
+        var member BoardMember
+        err = res.Scan(&member.BoardId, &member.UserId, &member.Name, &member.Role)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, member)
+    }
+
+    // Done
+    return r
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+    // Standard library
+    "log"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a comment on a todo
+    Comment struct {
+        Id          int         `json:"id"`
+        TodoId      int         `json:"todo_id"`
+        AuthorId    int         `json:"author_id"`
+        // Name of the author, filled in when reading
+        AuthorName  string      `json:"author_name"`
+        // Markdown
+        Body        string      `json:"body"`
+        Created     time.Time   `json:"created"`
+        // When the body was last changed, nil if it never was
+        Edited      *time.Time  `json:"edited,omitempty"`
+    }
+)
+
+// Longest allowed comment body, in characters
+const MaxCommentBody = 10000
+
+// Column expression counting the comments of a todo. Used in place of a column
+// when selecting from todos.
+const todoCommentsColumn = "(SELECT COUNT(*) FROM comments WHERE todo_id = todos.id)"
+
+// Check the body of a comment. Returns a friendly error message, or "" if the
+// comment is valid.
+func (comment *Comment) Validate() string {
+    if len(strings.TrimSpace(comment.Body)) == 0 || utf8.RuneCountInString(comment.Body) > MaxCommentBody {
+        return "Comment must be between 1 and 10000 characters"
+    }
+    return ""
+}
+
+// Inserts a new comment. Returns true on success, false on error.
+func (comment *Comment) InsertValues() bool {
+    // Check that there is no input Id
+    if comment.Id > 0 || comment.TodoId <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute insert statement
+    comment.Created = time.Now().UTC()
+    comment.Edited = nil
+    res, err := conn.Exec("INSERT INTO comments(todo_id, author_id, body, created) values(?,?,?,?)", comment.TodoId, comment.AuthorId, comment.Body, comment.Created)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // Find out the new id
+    id, err := res.LastInsertId()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    comment.Id = int(id)
+
+    // No error
+    return true
+}
+
+// Updates the body of an existing comment, marking it as edited. Returns true on
+// success, false on error.
+func (comment *Comment) WriteValues() bool {
+    // Check that there is an input Id
+    if comment.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute update statement
+    now := time.Now().UTC()
+    _, err := conn.Exec("UPDATE comments SET body = ?, edited = ? WHERE id = ?", comment.Body, now, comment.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    comment.Edited = &now
+
+    // No error
+    return true
+}
+
+// Read in the values of a comment based on id. Returns true if values were read.
+func (comment *Comment) ReadValues() bool {
+    // Check that there is an input Id
+    if comment.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := conn.QueryRow("SELECT c.todo_id, c.author_id, IFNULL(u.name, ''), c.body, c.created, c.edited FROM comments c LEFT JOIN users u ON u.id = c.author_id WHERE c.id = ?", comment.Id).Scan(
+        &comment.TodoId, &comment.AuthorId, &comment.AuthorName, &comment.Body, &comment.Created, &comment.Edited)
+    if err != nil {
+        // No results
+        return false
+    }
+
+    return true
+}
+
+// Remove a comment based on Id. Returns true on success.
+func (comment *Comment) Remove() bool {
+    // Check that there is an input Id
+    if comment.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute delete statement
+    _, err := conn.Exec("DELETE FROM comments WHERE id = ?", comment.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// List the comments on a todo, oldest first
+func ListComments(todo_id int) []Comment {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare read statement
+    stmt, err := conn.Prepare("SELECT c.id, c.todo_id, c.author_id, IFNULL(u.name, ''), c.body, c.created, c.edited FROM comments c LEFT JOIN users u ON u.id = c.author_id WHERE c.todo_id = ? ORDER BY c.id")
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer stmt.Close()
+
+    // Execute read statement
+    res, err := stmt.Query(todo_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Create new slice for storing output
+    var r []Comment
+
+    // Check results
+    for res.Next() {
+        var comment Comment
+        err = res.Scan(&comment.Id, &comment.TodoId, &comment.AuthorId, &comment.AuthorName, &comment.Body, &comment.Created, &comment.Edited)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+
+        // No errors, append to slice
+        r = append(r, comment)
+    }
+
+    // Done
+    return r
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+    // Standard library
+    "log"
+    "strings"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a todo that can't be done before another one is
+    Dependency struct {
+        TodoId      int     `json:"todo_id"`
+        BlockerId   int     `json:"blocker_id"`
+    }
+)
+
+// Column expression telling whether a todo has blockers that aren't done yet.
+// Blockers in the trash don't count. Used in place of a column when selecting
+// from todos.
+const todoBlockedColumn = "EXISTS (SELECT 1 FROM todo_dependencies d JOIN todos b ON b.id = d.blocker_id WHERE d.todo_id = todos.id AND b.state != 5 AND b.deleted_at IS NULL)"
+
+// Inserts a new dependency, unless it is already there. Returns true on
+// success, false on error.
+func (dep *Dependency) InsertValues() bool {
+    // Check that there are input Ids
+    if dep.TodoId <= 0 || dep.BlockerId <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute insert statement
+    _, err := conn.Exec("INSERT OR IGNORE INTO todo_dependencies(todo_id, blocker_id) values(?,?)", dep.TodoId, dep.BlockerId)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Remove a dependency. Returns true on success.
+func (dep *Dependency) Remove() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute delete statement
+    _, err := conn.Exec("DELETE FROM todo_dependencies WHERE todo_id = ? AND blocker_id = ?", dep.TodoId, dep.BlockerId)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Check whether adding the dependency would make a todo (indirectly) block
+// itself. Also true on error, to be safe.
+func (dep *Dependency) CreatesCycle() bool {
+    if dep.TodoId == dep.BlockerId {
+        return true
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Follow the blockers of the blocker all the way up, looking for the todo
+    var count int
+    err := conn.QueryRow(`WITH RECURSIVE up(id) AS (
+        SELECT blocker_id FROM todo_dependencies WHERE todo_id = ?
+        UNION SELECT d.blocker_id FROM todo_dependencies d JOIN up ON d.todo_id = up.id
+    ) SELECT COUNT(*) FROM up WHERE id = ?`, dep.BlockerId, dep.TodoId).Scan(&count)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return true
+    }
+
+    return count > 0
+}
+
+// List the ids of the todos blocking a todo, in order
+func ListBlockers(todo_id int) []int {
+    return listBlockers(database.GetConnection(), "SELECT blocker_id FROM todo_dependencies WHERE todo_id = ? ORDER BY blocker_id", todo_id)
+}
+
+// List the ids of the todos blocking a todo that aren't done yet, leaving out
+// the ones in the trash
+func ListUnfinishedBlockers(todo_id int) []int {
+    return listUnfinishedBlockers(database.GetConnection(), todo_id)
+}
+
+func listUnfinishedBlockers(db dbHandle, todo_id int) []int {
+    return listBlockers(db, "SELECT d.blocker_id FROM todo_dependencies d JOIN todos b ON b.id = d.blocker_id WHERE d.todo_id = ? AND b.state != 5 AND b.deleted_at IS NULL ORDER BY d.blocker_id", todo_id)
+}
+
+func listBlockers(db dbHandle, query string, todo_id int) []int {
+    // Execute read statement
+    res, err := db.Query(query, todo_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []int
+    for res.Next() {
+        var id int
+        err = res.Scan(&id)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, id)
+    }
+
+    // Done
+    return r
+}
+
+// List the dependencies that any of the given todos are part of, on either end
+func ListDependencies(todo_ids []int) []Dependency {
+    if len(todo_ids) == 0 {
+        return nil
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Each id is needed for both ends
+    in := "(?" + strings.Repeat(",?", len(todo_ids) - 1) + ")"
+    var args []interface{}
+    for i := 0; i < 2; i++ {
+        for _, id := range todo_ids {
+            args = append(args, id)
+        }
+    }
+
+    // Execute read statement
+    res, err := conn.Query("SELECT todo_id, blocker_id FROM todo_dependencies WHERE todo_id IN " + in + " OR blocker_id IN " + in + " ORDER BY todo_id, blocker_id", args...)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []Dependency
+    for res.Next() {
+        var dep Dependency
+        err = res.Scan(&dep.TodoId, &dep.BlockerId)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, dep)
+    }
+
+    // Done
+    return r
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+    // Standard library
+    "log"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a request that may be retried, and the response it got
+    IdempotencyKey struct {
+        TokenId     int         `json:"token_id"`
+        Key         string      `json:"key"`
+        // Hash of the request, to tell if a retry is really the same request
+        RequestHash string      `json:"request_hash"`
+        // 0 while the request is still being handled
+        Status      int         `json:"status"`
+        Response    string      `json:"response"`
+        // ETag header of the response, if it had one
+        ETag        string      `json:"etag"`
+        Created     time.Time   `json:"created"`
+    }
+)
+
+// Longest idempotency key accepted, in bytes
+const MaxIdempotencyKey = 255
+
+// How long keys are remembered for
+var IdempotencyKeyLifetime = 24 * time.Hour
+
+// Claim a key for a new request, unless the token has used it recently. Keys
+// that have expired are forgotten first. Returns true if the key was claimed,
+// false if it is already taken or on error.
+func (key *IdempotencyKey) Reserve() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Make room for keys that may be used again
+    _, err := conn.Exec("DELETE FROM idempotency_keys WHERE created < ?", time.Now().UTC().Add(-IdempotencyKeyLifetime))
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // Execute insert statement, which does nothing if the key is taken
+    key.Status = 0
+    key.Response = ""
+    key.ETag = ""
+    key.Created = time.Now().UTC()
+    res, err := conn.Exec("INSERT OR IGNORE INTO idempotency_keys(token_id, key, request_hash, status, response, created) values(?,?,?,?,?,?)",
+        key.TokenId, key.Key, key.RequestHash, key.Status, key.Response, key.Created)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    n, err := res.RowsAffected()
+    return err == nil && n == 1
+}
+
+// Read in the values of a key based on token id and key. Returns true if values
+// were read.
+func (key *IdempotencyKey) ReadValues() bool {
+    // Check that there is an input key
+    if len(key.Key) == 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := conn.QueryRow("SELECT request_hash, status, response, etag, created FROM idempotency_keys WHERE token_id = ? AND key = ?", key.TokenId, key.Key).Scan(&key.RequestHash, &key.Status, &key.Response, &key.ETag, &key.Created)
+    if err != nil {
+        // No results
+        return false
+    }
+
+    return true
+}
+
+// Remember the response to the request of a claimed key. Returns true on
+// success.
+func (key *IdempotencyKey) WriteResponse() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE idempotency_keys SET status = ?, response = ?, etag = ? WHERE token_id = ? AND key = ?", key.Status, key.Response, key.ETag, key.TokenId, key.Key)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    return true
+}
+
+// Give up a claimed key, so that the request may be tried again. Returns true on
+// success.
+func (key *IdempotencyKey) Remove() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute delete statement
+    _, err := conn.Exec("DELETE FROM idempotency_keys WHERE token_id = ? AND key = ?", key.TokenId, key.Key)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    return true
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+    // Standard library
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
+    "log"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent the secret part of the address mail can be sent to for it to
+    // become a todo of a user
+    MailKey struct {
+        UserId      int     `json:"user_id"`
+        Key         string  `json:"key"`
+    }
+)
+
+// Number of random bytes in a key. Keys are written in lowercase hex, since the
+// case of addresses isn't always kept.
+const mailKeyBytes = 16
+
+// Read in the key of UserId, or the user a Key belongs to if it is given.
+// Returns true if the user has a key.
+func (key *MailKey) ReadValues() bool {
+    // Check that there is an input Key or UserId
+    if len(key.Key) == 0 && key.UserId < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    var row *sql.Row
+    if len(key.Key) != 0 {
+        row = conn.QueryRow("SELECT id, mail_key FROM users WHERE mail_key = ?", key.Key)
+    } else {
+        row = conn.QueryRow("SELECT id, mail_key FROM users WHERE id = ?", key.UserId)
+    }
+    err := row.Scan(&key.UserId, &key.Key)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    return len(key.Key) != 0
+}
+
+// Make up a new key for UserId, so that mail sent to the old one is no longer
+// accepted. Returns true on success, false on error.
+func (key *MailKey) Reset() bool {
+    // Check that there is an input UserId
+    if key.UserId <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    b := make([]byte, mailKeyBytes)
+    rand.Read(b)
+    key.Key = hex.EncodeToString(b)
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE users SET mail_key = ? WHERE id = ?", key.Key, key.UserId)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
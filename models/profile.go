@@ -0,0 +1,110 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+    "strings"
+    "unicode/utf8"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent the public profile of a user, listing their public todos
+    Profile struct {
+        UserId      int     `json:"-"`
+        Name        string  `json:"name"`
+        // Name shown on the profile instead of the username, "" to show the
+        // username
+        DisplayName string  `json:"display_name"`
+        // Whether anyone can see the profile, on by default
+        Public      bool    `json:"public"`
+    }
+)
+
+// Longest allowed display name, in characters
+const MaxDisplayName = 64
+
+// Check the display name of a profile. Returns a friendly error message, or ""
+// if the profile is valid.
+func (profile *Profile) Validate() string {
+    profile.DisplayName = strings.TrimSpace(profile.DisplayName)
+    if utf8.RuneCountInString(profile.DisplayName) > MaxDisplayName {
+        return "Display name must be at most 64 characters"
+    }
+    return ""
+}
+
+// Read in the profile of a user based on name, or on UserId if there is no
+// name. Returns true if values were read.
+func (profile *Profile) ReadValues() bool {
+    // Check that there is an input Name or UserId
+    if len(profile.Name) == 0 && profile.UserId < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    var row *sql.Row
+    if len(profile.Name) != 0 {
+        row = conn.QueryRow("SELECT id, name, display_name, profile_public FROM users WHERE name = ?", profile.Name)
+    } else {
+        row = conn.QueryRow("SELECT id, name, display_name, profile_public FROM users WHERE id = ?", profile.UserId)
+    }
+    err := row.Scan(&profile.UserId, &profile.Name, &profile.DisplayName, &profile.Public)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    return true
+}
+
+// Updates the display name and visibility of the profile of UserId. Returns true
+// on success, false on error.
+func (profile *Profile) WriteValues() bool {
+    // Check that there is an input UserId
+    if profile.UserId <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE users SET display_name = ?, profile_public = ? WHERE id = ?", profile.DisplayName, profile.Public, profile.UserId)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Name to show on the profile
+func (profile *Profile) ShownName() string {
+    if len(profile.DisplayName) != 0 {
+        return profile.DisplayName
+    }
+    return profile.Name
+}
+
+// List the public todos of the user of a profile
+func (profile *Profile) ListTodos() []Todo {
+    // Nobody is logged in, so only public todos are visible
+    todos := ListTodos(-1, TodoFilter{
+        OwnerId:    profile.UserId,
+    })
+    for i := range todos {
+        todos[i].OwnerId = profile.UserId
+        todos[i].Public = true
+    }
+    return todos
+}
@@ -0,0 +1,259 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// Todos are ordered within a column by a rank string. A rank is a fraction in
+// base 36 with the leading "0." left out, so that it is always possible to make
+// up a new rank between two existing ones and moving a todo only has to update
+// that one todo. Ranks never end in the zero digit, otherwise there would be no
+// room left directly in front of them.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func rankDigit(rank string, i int) int {
+    if i >= len(rank) {
+        return 0
+    }
+    for d := 0; d < len(rankDigits); d++ {
+        if rankDigits[d] == rank[i] {
+            return d
+        }
+    }
+    return 0
+}
+
+// Make up a rank that sorts strictly between lo and hi, which has to sort
+// before hi. An empty lo means the start of the column, an empty hi means the
+// end of the column.
+func RankBetween(lo, hi string) string {
+    // Skip over the common prefix
+    n := 0
+    if len(hi) > 0 {
+        for n < len(hi) && rankDigit(lo, n) == rankDigit(hi, n) {
+            n++
+        }
+    }
+    prefix := hi[:n]
+    if n < len(lo) {
+        lo = lo[n:]
+    } else {
+        lo = ""
+    }
+    if len(hi) > 0 {
+        hi = hi[n:]
+    }
+
+    // First digits now differ
+    dlo := rankDigit(lo, 0)
+    dhi := len(rankDigits)
+    if len(hi) > 0 {
+        dhi = rankDigit(hi, 0)
+    }
+
+    if dhi - dlo > 1 {
+        // There is a digit in between, use it
+        return prefix + string(rankDigits[(dlo + dhi) / 2])
+    }
+
+    // Consecutive digits, so the rank needs to get longer
+    if len(hi) > 1 {
+        // hi itself is longer, its first digit alone sorts before it
+        return prefix + hi[:1]
+    }
+    rest := ""
+    if len(lo) > 1 {
+        rest = lo[1:]
+    }
+    return prefix + string(rankDigits[dlo]) + RankBetween(rest, "")
+}
+
+// Read the last rank in use in a state column of a board, or of the personal
+// todos of owner_id if board_id is 0. Returns "" if the column is empty.
+func LastRank(state int, owner_id int, board_id int) string {
+    return lastRank(database.GetConnection(), state, owner_id, board_id)
+}
+
+// Read the last rank in use in a state column as seen by db
+func lastRank(db dbHandle, state int, owner_id int, board_id int) string {
+    // prepare read statement
+    var rank sql.NullString
+    err := db.QueryRow("SELECT MAX(rank) FROM todos WHERE state = ? AND board_id = ? AND (board_id != 0 OR owner_id = ?)", state, board_id, owner_id).Scan(&rank)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return ""
+    }
+
+    return rank.String
+}
+
+// Read the ranks of the todos in a state column of a board, or of the personal
+// todos of owner_id if board_id is 0, in order, leaving out the todo with the
+// given id.
+func ListColumnRanks(state int, owner_id int, board_id int, except_id int) []string {
+    _, ranks := listColumnRanks(database.GetConnection(), state, owner_id, board_id, except_id)
+    return ranks
+}
+
+// Read the ids and ranks of a state column as seen by db. Todos sharing a rank
+// are ordered by id.
+func listColumnRanks(db dbHandle, state int, owner_id int, board_id int, except_id int) ([]int, []string) {
+    // prepare read statement
+    stmt, err := db.Prepare("SELECT id, rank FROM todos WHERE state = ? AND board_id = ? AND (board_id != 0 OR owner_id = ?) AND id != ? AND deleted_at IS NULL ORDER BY rank, id")
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil, nil
+    }
+    defer stmt.Close()
+
+    // Execute read statement
+    res, err := stmt.Query(state, board_id, owner_id, except_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil, nil
+    }
+    defer res.Close()
+
+    // Create new slices for storing output
+    var ids []int
+    var ranks []string
+    var id int
+    var rank string
+
+    // Check results
+    for res.Next() {
+        err = res.Scan(&id, &rank)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil, nil
+        }
+        ids = append(ids, id)
+        ranks = append(ranks, rank)
+    }
+
+    // Done
+    return ids, ranks
+}
+
+// Give the todos sharing the rank of ranks[i] ranks of their own, in the order
+// they are in now, so that there is room between them again. ranks is updated
+// to match.
+func spreadRanks(db dbHandle, ids []int, ranks []string, i int, editor *Token) error {
+    // Find the todos sharing the rank
+    start, end := i, i
+    for start > 0 && ranks[start - 1] == ranks[i] {
+        start--
+    }
+    for end < len(ranks) - 1 && ranks[end + 1] == ranks[i] {
+        end++
+    }
+    lo, hi := "", ""
+    if start > 0 {
+        lo = ranks[start - 1]
+    }
+    if end < len(ranks) - 1 {
+        hi = ranks[end + 1]
+    }
+
+    for j := start; j <= end; j++ {
+        lo = RankBetween(lo, hi)
+
+        // Record it in the history like any other move
+        before := Todo{
+            Id:     ids[j],
+        }
+        if !before.readValues(db) {
+            return errNotFound
+        }
+        after := before
+        after.Editor = editor
+        after.Rank = lo
+        after.Version++
+        _, err := db.Exec("UPDATE todos SET rank = ?, version = ? WHERE id = ?", after.Rank, after.Version, after.Id)
+        if err != nil {
+            return err
+        }
+        err = recordTodoEvent(db, EventUpdate, &before, &after)
+        if err != nil {
+            return err
+        }
+        ranks[j] = lo
+    }
+    return nil
+}
+
+// Move a todo to the given position (0 = top) of a state column of its board,
+// or of its owner's personal todos. Only the state and rank of the todo are
+// written. Returns true on success, false on error.
+func (todo *Todo) Move(state int, position int) bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 {
+        return false
+    }
+
+    return inTransaction(func(tx *sql.Tx) error {
+        return todo.move(tx, state, position)
+    })
+}
+
+// Move a todo and record it in the history
+func (todo *Todo) move(db dbHandle, state int, position int) error {
+    // Keep the previous version around for the history
+    before := Todo{
+        Id:     todo.Id,
+    }
+    if !before.readValues(db) {
+        return errNotFound
+    }
+
+    // Find the neighbours at the new position, among the todos the moved todo
+    // is ranked with
+    ids, ranks := listColumnRanks(db, state, before.OwnerId, before.BoardId, todo.Id)
+    if position < 0 {
+        position = 0
+    } else if position > len(ranks) {
+        position = len(ranks)
+    }
+    // Todos sharing a rank leave no room between them, so spread them out
+    // first
+    if position > 0 && position < len(ranks) && ranks[position - 1] == ranks[position] {
+        err := spreadRanks(db, ids, ranks, position, todo.Editor)
+        if err != nil {
+            return err
+        }
+    }
+    lo, hi := "", ""
+    if position > 0 {
+        lo = ranks[position - 1]
+    }
+    if position < len(ranks) {
+        hi = ranks[position]
+    }
+
+    after := before
+    after.Editor = todo.Editor
+    after.State = state
+    after.Rank = RankBetween(lo, hi)
+    after.Version++
+
+    // Execute update statement
+    _, err := db.Exec("UPDATE todos SET state = ?, rank = ?, version = ? WHERE id = ?", after.State, after.Rank, after.Version, todo.Id)
+    if err != nil {
+        return err
+    }
+    err = recordTodoEvent(db, EventUpdate, &before, &after)
+    if err != nil {
+        return err
+    }
+
+    // No error
+    todo.State = after.State
+    todo.Rank = after.Rank
+    todo.Version = after.Version
+    return nil
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+    // Standard library
+    "reflect"
+    "strings"
+    "testing"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// A rank made up between lo and hi has to sort between them, and mustn't end
+// in the zero digit
+func checkRankBetween(t *testing.T, lo, hi, rank string) {
+    t.Helper()
+    if rank <= lo || (len(hi) > 0 && rank >= hi) {
+        t.Errorf("RankBetween(%q, %q) = %q, not between them", lo, hi, rank)
+    }
+    if strings.HasSuffix(rank, "0") {
+        t.Errorf("RankBetween(%q, %q) = %q, ends in 0", lo, hi, rank)
+    }
+}
+
+func TestRankBetween(t *testing.T) {
+    tests := []struct {
+        lo      string
+        hi      string
+        want    string
+    }{
+        {"", "", "i"},
+        {"i", "", "r"},
+        {"", "i", "9"},
+        {"a", "c", "b"},
+        {"a", "b", "ai"},
+        {"", "1", "0i"},
+        {"z", "", "zi"},
+        {"az", "b", "azi"},
+        {"a", "a1", "a0i"},
+        {"ai", "b", "ar"},
+        {"a", "ab", "a5"},
+        {"abc", "abd", "abci"},
+    }
+    for _, test := range tests {
+        got := RankBetween(test.lo, test.hi)
+        if got != test.want {
+            t.Errorf("RankBetween(%q, %q) = %q, want %q", test.lo, test.hi, got, test.want)
+        }
+        checkRankBetween(t, test.lo, test.hi, got)
+    }
+}
+
+// Ranks stay in order however often todos are put in the same spot
+func TestRankBetweenRepeated(t *testing.T) {
+    // Always at the start of the column
+    first := "i"
+    for i := 0; i < 200; i++ {
+        rank := RankBetween("", first)
+        checkRankBetween(t, "", first, rank)
+        first = rank
+    }
+
+    // Always at the end of the column
+    last := "i"
+    for i := 0; i < 200; i++ {
+        rank := RankBetween(last, "")
+        checkRankBetween(t, last, "", rank)
+        last = rank
+    }
+
+    // Always right behind the same todo
+    lo, hi := "i", "r"
+    for i := 0; i < 200; i++ {
+        rank := RankBetween(lo, hi)
+        checkRankBetween(t, lo, hi, rank)
+        hi = rank
+    }
+}
+
+// Moving between todos that share a rank spreads them out first
+func TestMoveBetweenEqualRanks(t *testing.T) {
+    testDatabase(t)
+
+    var todos []Todo
+    for i := 0; i < 4; i++ {
+        todo := Todo{
+            Name:       "todo",
+            State:      StateDoingSoon,
+            OwnerId:    1,
+        }
+        if !todo.InsertValues() {
+            t.Fatal("failed to insert todo")
+        }
+        todos = append(todos, todo)
+    }
+    // As left behind by todos made before there were ranks
+    _, err := database.GetConnection().Exec("UPDATE todos SET rank = 'i' WHERE id != ?", todos[3].Id)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !todos[3].Move(StateDoingSoon, 1) {
+        t.Fatal("failed to move todo")
+    }
+    ids, ranks := listColumnRanks(database.GetConnection(), StateDoingSoon, 1, 0, 0)
+    want := []int{todos[0].Id, todos[3].Id, todos[1].Id, todos[2].Id}
+    for i := range want {
+        if i >= len(ids) || ids[i] != want[i] {
+            t.Fatalf("column is %v with ranks %v, want %v", ids, ranks, want)
+        }
+    }
+    for i := 1; i < len(ranks); i++ {
+        if ranks[i - 1] >= ranks[i] {
+            t.Errorf("ranks %v are not strictly increasing", ranks)
+        }
+    }
+}
+
+// Todos of others that can be seen, but aren't in the same column, are never
+// re-ranked when moving between todos that share a rank
+func TestMoveLeavesOthersTodosAlone(t *testing.T) {
+    testDatabase(t)
+
+    conn := database.GetConnection()
+    _, err := conn.Exec("INSERT INTO users(name, password) values(?,?)", "alice", database.Hash("password"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Someone else's public todo, and one they shared with the mover
+    var foreign []Todo
+    for _, public := range []bool{true, false} {
+        todo := Todo{
+            Name:       "foreign",
+            State:      StateDoingSoon,
+            OwnerId:    2,
+            Public:     public,
+        }
+        if !todo.InsertValues() {
+            t.Fatal("failed to insert todo")
+        }
+        foreign = append(foreign, todo)
+    }
+    _, err = conn.Exec("INSERT INTO todo_shares(todo_id, user_id, level) values(?,?,?)", foreign[1].Id, 1, ShareWrite)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var own []Todo
+    for i := 0; i < 3; i++ {
+        todo := Todo{
+            Name:       "own",
+            State:      StateDoingSoon,
+            OwnerId:    1,
+        }
+        if !todo.InsertValues() {
+            t.Fatal("failed to insert todo")
+        }
+        own = append(own, todo)
+    }
+    _, err = conn.Exec("UPDATE todos SET rank = 'i' WHERE id != ?", own[2].Id)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    own[2].Editor = &Token{OwnerId: 1}
+    if !own[2].Move(StateDoingSoon, 1) {
+        t.Fatal("failed to move todo")
+    }
+    for _, todo := range foreign {
+        stored := Todo{
+            Id:     todo.Id,
+        }
+        if !stored.ReadValues() {
+            t.Fatal("failed to read todo")
+        }
+        if stored.Rank != "i" || stored.Version != todo.Version {
+            t.Errorf("todo %d of someone else was re-ranked to %q, version %d", todo.Id, stored.Rank, stored.Version)
+        }
+        var events int
+        err = conn.QueryRow("SELECT COUNT(*) FROM todo_events WHERE todo_id = ? AND user_id = 1", todo.Id).Scan(&events)
+        if err != nil || events != 0 {
+            t.Errorf("todo %d of someone else has %d changes by the mover (%v)", todo.Id, events, err)
+        }
+    }
+
+    ids, ranks := listColumnRanks(conn, StateDoingSoon, 1, 0, 0)
+    want := []int{own[0].Id, own[2].Id, own[1].Id}
+    if !reflect.DeepEqual(ids, want) {
+        t.Errorf("column is %v with ranks %v, want %v", ids, ranks, want)
+    }
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+    // Standard library
+    "strings"
+    "time"
+
+    // RFC 5545 recurrence rules
+    "github.com/teambition/rrule-go"
+)
+
+// Load the time zone the recurrence of this todo is evaluated in
+func (todo *Todo) Location() (*time.Location, error) {
+    if len(todo.TimeZone) == 0 {
+        return time.UTC, nil
+    }
+    return time.LoadLocation(todo.TimeZone)
+}
+
+// Parse the recurrence rule of this todo. The rule is anchored at the start of
+// the series, in the time zone of the todo so that e.g. "every day at 9am"
+// stays at 9am across daylight saving changes.
+func (todo *Todo) Recurrence() (*rrule.RRule, error) {
+    loc, err := todo.Location()
+    if err != nil {
+        return nil, err
+    }
+
+    // UNTIL without a trailing Z is a local time in the todo's time zone
+    opt, err := rrule.StrToROptionInLocation(strings.TrimSpace(todo.RRule), loc)
+    if err != nil {
+        return nil, err
+    }
+
+    // The series always starts at the server-managed start, never a DTSTART
+    // that might have been passed in the rule itself
+    opt.Dtstart = todo.RRuleStart.In(loc)
+    return rrule.NewRRule(*opt)
+}
+
+// Validate the recurrence of this todo and fill in the start of the series if
+// it is not known yet. Call this before writing a todo.
+func (todo *Todo) PrepareRecurrence() error {
+    // Not recurring, nothing to keep track of
+    if len(strings.TrimSpace(todo.RRule)) == 0 {
+        todo.RRule = ""
+        todo.RRuleStart = time.Time{}
+        return nil
+    }
+
+    // New series starts at the current due date
+    if todo.RRuleStart.IsZero() {
+        todo.RRuleStart = todo.DueDate
+    }
+
+    // Make sure the rule is usable
+    _, err := todo.Recurrence()
+    return err
+}
+
+// List up to n occurrences of this todo that come strictly after the given time.
+// Fewer are returned if the recurrence ends (COUNT or UNTIL) before that.
+func (todo *Todo) Occurrences(after time.Time, n int) ([]time.Time, error) {
+    r, err := todo.Recurrence()
+    if err != nil {
+        return nil, err
+    }
+
+    // Collect the occurrences one after another
+    var res []time.Time
+    for len(res) < n {
+        next := r.After(after, false)
+        if next.IsZero() {
+            // End of the series
+            break
+        }
+        res = append(res, next.UTC())
+        after = next
+    }
+
+    return res, nil
+}
+
+// Move a recurring todo that has just been completed on to its next occurrence,
+// putting it back into the given state. Returns true if there was a next
+// occurrence; a todo whose series has ended is left done.
+func (todo *Todo) Advance(state int) bool {
+    if len(todo.RRule) == 0 {
+        return false
+    }
+
+    next, err := todo.Occurrences(todo.DueDate, 1)
+    if err != nil || len(next) == 0 {
+        return false
+    }
+
+    todo.DueDate = next[0]
+    todo.State = state
+    return true
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a todo shared with a user other than its owner
+    Share struct {
+        TodoId  int     `json:"todo_id"`
+        UserId  int     `json:"user_id"`
+        Name    string  `json:"name"`
+        // ShareRead or ShareWrite
+        Level   string  `json:"level"`
+        // Who is sharing the todo, for its history
+        Editor  *Token  `json:"-"`
+    }
+)
+
+// What a todo can be shared for
+const (
+    ShareRead   = "read"
+    ShareWrite  = "write"
+)
+
+// How much a user can do with a todo, each level allowing everything the ones
+// below it do
+const (
+    AccessNone  = iota
+    // Can see the todo, its comments and attachments, and comment on it
+    AccessRead
+    // Can also change its state, like its assignees
+    AccessState
+    // Can also change everything else about it, except for removing it and
+    // who it is shared with or assigned to
+    AccessWrite
+    // Owns the todo
+    AccessOwner
+)
+
+// Condition matching the todos a user can see, given as the parameter four
+// times. Used when selecting from todos.
+const todoVisibleCondition = "(public = 1 OR (board_id = 0 AND owner_id = ?) OR board_id IN (SELECT board_id FROM board_members WHERE user_id = ?) OR id IN (SELECT todo_id FROM todo_assignees WHERE user_id = ?) OR id IN (SELECT todo_id FROM todo_shares WHERE user_id = ?))"
+
+// Condition matching the todos a user owns, as in AccessOwner, given as the
+// parameter three times. Used when selecting from todos.
+const todoOwnedCondition = "((board_id = 0 AND owner_id = ?) OR board_id IN (SELECT board_id FROM board_members WHERE user_id = ? AND role IN ('admin', 'owner')) OR (owner_id = ? AND board_id IN (SELECT board_id FROM board_members WHERE user_id = owner_id AND role = 'member')))"
+
+// Work out how much the owner of a token can do with a todo, not counting it
+// being public. The owner and board of the todo have to be read in already.
+func (todo *Todo) AccessOf(auth *Token) int {
+    return todo.accessOf(database.GetConnection(), auth)
+}
+
+func (todo *Todo) accessOf(db dbHandle, auth *Token) int {
+    // Tokens limited to a board can't do anything with todos elsewhere
+    if auth.BoardId > 0 && todo.BoardId != auth.BoardId {
+        return AccessNone
+    }
+
+    // Todos on a board are up to the members of the board, the ones who
+    // created them only owning them as long as they can still change them
+    access := AccessNone
+    if todo.BoardId > 0 {
+        role := boardRoleOf(db, todo.BoardId, auth.OwnerId)
+        switch {
+        case RoleAtLeast(role, RoleAdmin):
+            return AccessOwner
+        case RoleAtLeast(role, RoleMember) && todo.OwnerId == auth.OwnerId:
+            return AccessOwner
+        case RoleAtLeast(role, RoleMember):
+            access = AccessWrite
+        case RoleAtLeast(role, RoleViewer):
+            access = AccessRead
+        }
+    } else if todo.OwnerId == auth.OwnerId {
+        return AccessOwner
+    }
+
+    var level string
+    var assigned bool
+    err := db.QueryRow("SELECT IFNULL((SELECT level FROM todo_shares WHERE todo_id = ? AND user_id = ?), ''), EXISTS (SELECT 1 FROM todo_assignees WHERE todo_id = ? AND user_id = ?)", todo.Id, auth.OwnerId, todo.Id, auth.OwnerId).Scan(&level, &assigned)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return access
+    }
+
+    switch {
+    case level == ShareWrite && access < AccessWrite:
+        return AccessWrite
+    case assigned && access < AccessState:
+        return AccessState
+    case level == ShareRead && access < AccessRead:
+        return AccessRead
+    }
+    return access
+}
+
+// Share a todo with a user, or change what it is shared for, recording it in
+// the history. Returns true on success.
+func (share *Share) WriteValues() bool {
+    // Check that there are input Ids
+    if share.TodoId <= 0 || share.UserId <= 0 || (share.Level != ShareRead && share.Level != ShareWrite) {
+        return false
+    }
+
+    return inTransaction(func(tx *sql.Tx) error {
+        var level string
+        err := tx.QueryRow("SELECT IFNULL((SELECT level FROM todo_shares WHERE todo_id = ? AND user_id = ?), '')", share.TodoId, share.UserId).Scan(&level)
+        if err != nil {
+            return err
+        }
+
+        // Execute insert statement
+        _, err = tx.Exec("INSERT OR REPLACE INTO todo_shares(todo_id, user_id, level) values(?,?,?)", share.TodoId, share.UserId, share.Level)
+        if err != nil || level == share.Level {
+            return err
+        }
+        return recordAccessEvent(tx, share.TodoId, []int{share.UserId}, share.Editor)
+    })
+}
+
+// Stop sharing a todo with a user, recording it in the history. Returns true on
+// success.
+func (share *Share) Remove() bool {
+    return inTransaction(func(tx *sql.Tx) error {
+        // Execute delete statement
+        res, err := tx.Exec("DELETE FROM todo_shares WHERE todo_id = ? AND user_id = ?", share.TodoId, share.UserId)
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err != nil || n == 0 {
+            // Wasn't shared with them
+            return err
+        }
+        return recordAccessEvent(tx, share.TodoId, []int{share.UserId}, share.Editor)
+    })
+}
+
+// List the users a todo is shared with
+func ListShares(todo_id int) []Share {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT s.todo_id, s.user_id, u.name, s.level FROM todo_shares s JOIN users u ON u.id = s.user_id WHERE s.todo_id = ? ORDER BY u.name", todo_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []Share
+    for res.Next() {
+        var share Share
+        err = res.Scan(&share.TodoId, &share.UserId, &share.Name, &share.Level)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, share)
+    }
+
+    // Done
+    return r
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+    // Standard library
+    "crypto/rand"
+    "crypto/subtle"
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "log"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent a read-only link to a todo, or to a list of todos, that works
+    // without an account
+    ShareLink struct {
+        Id          int         `json:"id"`
+        // Random part of the address of the link, made up by the server
+        Slug        string      `json:"slug"`
+        OwnerId     int         `json:"owner_id"`
+        // Todo the link shows, 0 if it shows a list of todos instead
+        TodoId      int         `json:"todo_id"`
+        // Which of the owner's todos the link shows if it doesn't show a
+        // single todo
+        Filter      TodoFilter  `json:"filter"`
+        // Only given when creating a link, which then can only be opened with
+        // the same password
+        Password    string      `json:"password,omitempty"`
+        HasPassword bool        `json:"has_password"`
+        // When the link stops working, nil if it keeps working until revoked
+        ExpiresAt   *time.Time  `json:"expires_at,omitempty"`
+        Created     time.Time   `json:"created"`
+        // Hash of the password, "" if there is none
+        passwordHash string
+    }
+)
+
+// Number of random bytes in a slug
+const shareLinkSlugBytes = 18
+
+// Make up a new slug that can't be guessed
+func newShareLinkSlug() string {
+    b := make([]byte, shareLinkSlugBytes)
+    rand.Read(b)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Inserts a new link, making up its slug. Returns true on success, false on
+// error.
+func (link *ShareLink) InsertValues() bool {
+    // Check that there is no input Id
+    if link.Id > 0 || link.OwnerId <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only the hash of the password is kept
+    link.passwordHash = ""
+    if len(link.Password) != 0 {
+        link.passwordHash = database.Hash(link.Password)
+    }
+    link.Password = ""
+    link.HasPassword = len(link.passwordHash) != 0
+    filter, _ := json.Marshal(link.Filter)
+
+    // Execute insert statement
+    link.Slug = newShareLinkSlug()
+    link.Created = time.Now().UTC()
+    res, err := conn.Exec("INSERT INTO share_links(slug, owner_id, todo_id, filter, password, expires_at, created) values(?,?,?,?,?,?,?)", link.Slug, link.OwnerId, link.TodoId, string(filter), link.passwordHash, link.ExpiresAt, link.Created)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // Find out the new id
+    id, err := res.LastInsertId()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    link.Id = int(id)
+
+    // No error
+    return true
+}
+
+// The columns of share_links read into a link by scan
+const shareLinkColumns = "id, slug, owner_id, todo_id, filter, password, expires_at, created"
+
+// Read in a link from a row of shareLinkColumns
+func (link *ShareLink) scan(row interface{ Scan(dest ...interface{}) error }) error {
+    var filter string
+    err := row.Scan(&link.Id, &link.Slug, &link.OwnerId, &link.TodoId, &filter, &link.passwordHash, &link.ExpiresAt, &link.Created)
+    if err != nil {
+        return err
+    }
+    json.Unmarshal([]byte(filter), &link.Filter)
+    link.HasPassword = len(link.passwordHash) != 0
+    return nil
+}
+
+// Read in the values of a link based on slug, or on id if there is no slug.
+// Returns true if values were read.
+func (link *ShareLink) ReadValues() bool {
+    // Check that there is an input Slug or Id
+    if len(link.Slug) == 0 && link.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    var row *sql.Row
+    if len(link.Slug) != 0 {
+        row = conn.QueryRow("SELECT " + shareLinkColumns + " FROM share_links WHERE slug = ?", link.Slug)
+    } else {
+        row = conn.QueryRow("SELECT " + shareLinkColumns + " FROM share_links WHERE id = ?", link.Id)
+    }
+    err := link.scan(row)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    return true
+}
+
+// Check whether a link has stopped working
+func (link *ShareLink) Expired() bool {
+    return link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt)
+}
+
+// Check a password given to open a link. Links without a password open with
+// any password.
+func (link *ShareLink) CheckPassword(password string) bool {
+    if !link.HasPassword {
+        return true
+    }
+    hash := database.Hash(password)
+    return subtle.ConstantTimeCompare([]byte(hash), []byte(link.passwordHash)) == 1
+}
+
+// Revoke a link based on Id. Returns true on success.
+func (link *ShareLink) Remove() bool {
+    // Check that there is an input Id
+    if link.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute delete statement
+    _, err := conn.Exec("DELETE FROM share_links WHERE id = ?", link.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// List the links of owner_id, newest first
+func ListShareLinks(owner_id int) []ShareLink {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT " + shareLinkColumns + " FROM share_links WHERE owner_id = ? ORDER BY created DESC, id DESC", owner_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []ShareLink
+    for res.Next() {
+        var link ShareLink
+        err = link.scan(res)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, link)
+    }
+
+    // Done
+    return r
+}
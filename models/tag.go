@@ -2,7 +2,11 @@ package models
 
 import (
     // Standard library
+    "database/sql"
     "log"
+    "regexp"
+    "strings"
+    "unicode/utf8"
 
     // Own stuff
     "github.com/ohnx/gotodo/database"
@@ -13,16 +17,409 @@ type (
     Tag struct {
         Id      int     `json:"id"`
         Name    string  `json:"name"`
+        // CSS hex color, e.g. #07457E
+        Color   string  `json:"color"`
+        Desc    string  `json:"description"`
+        OwnerId int     `json:"owner_id"`
+        // Board the tag belongs to, 0 for a personal tag of its owner
+        BoardId int     `json:"board_id"`
+        // Tag this one is nested under, 0 for a top-level tag
+        ParentId int    `json:"parent_id"`
+        // Names of the ancestors and this tag, e.g. infra/ci
+        Path    string  `json:"path"`
+        // Nested tags, only filled in when listing as a tree
+        Children []Tag  `json:"children,omitempty"`
+        // Who is removing or merging the tag, for the history of its todos
+        Editor  *Token  `json:"-"`
     }
 )
 
+// Condition matching the tags of a board, or the personal tags of an owner if
+// the board is 0, given the board and owner as parameters. Used when selecting
+// from tags.
+const tagScopeCondition = "(board_id = ? AND (board_id > 0 OR owner_id = ?))"
+
+// Longest allowed tag name, in characters
+const MaxTagName = 16
+
+// Colors handed out to tags created without one
+var tagColors = []string{"#07457E", "#FFC914", "#7C077E", "#17BEBB", "#F17300", "#76B041", "#8C0000"}
+
+var colorRegexp = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+// Check the name and color of a tag, picking a color if there is none.
+// Returns a friendly error message, or "" if the tag is valid.
+func (tag *Tag) Validate() string {
+    // A path-style name picks the parent, e.g. infra/ci is ci nested under infra
+    tag.Name = strings.TrimSpace(tag.Name)
+    if i := strings.LastIndex(tag.Name, TagPathSeparator); i >= 0 {
+        parent := FindTagByPath(tag.OwnerId, tag.BoardId, strings.TrimSpace(tag.Name[:i]))
+        if parent == nil {
+            return "Parent tag not found in database"
+        }
+        tag.ParentId = parent.Id
+        tag.Name = strings.TrimSpace(tag.Name[i + 1:])
+    }
+    if len(tag.Name) == 0 || utf8.RuneCountInString(tag.Name) > MaxTagName {
+        return "Tag name must be between 1 and 16 characters"
+    }
+
+    // The parent has to be another of the owner's (or board's) tags, and not
+    // below this one
+    if tag.ParentId != 0 {
+        parent := Tag{
+            Id:     tag.ParentId,
+        }
+        if !parent.ReadValues() || !parent.SameScope(tag) {
+            return "Parent tag not found in database"
+        }
+        if tag.Id > 0 && TagIsDescendant(tag.ParentId, tag.Id) {
+            return "Tag cannot be nested under itself"
+        }
+    }
+
+    if len(tag.Color) == 0 {
+        tag.Color = tagColors[countTags() % len(tagColors)]
+    } else if !colorRegexp.MatchString(tag.Color) {
+        return "Tag color must be of the form #RRGGBB"
+    }
+
+    if tagNameTaken(tag.OwnerId, tag.BoardId, tag.ParentId, tag.Name, tag.Id) {
+        return "Tag name already in use"
+    }
+
+    return ""
+}
+
+// Check whether two tags belong to the same board, or are personal tags of the
+// same owner
+func (tag *Tag) SameScope(other *Tag) bool {
+    return tag.BoardId == other.BoardId && (tag.BoardId > 0 || tag.OwnerId == other.OwnerId)
+}
+
+// Check whether an owner (or board) already has another tag of the same name
+// next to where a tag goes
+func tagNameTaken(owner_id int, board_id int, parent_id int, name string, except_id int) bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    var count int
+    err := conn.QueryRow("SELECT COUNT(*) FROM tags WHERE " + tagScopeCondition + " AND parent_id = ? AND name = ? AND id != ?", board_id, owner_id, parent_id, name, except_id).Scan(&count)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return true
+    }
+
+    return count > 0
+}
+
+// Inserts a new tag. Returns true on success, false on error.
+func (tag *Tag) InsertValues() bool {
+    // Check that there is no input Id
+    if tag.Id > 0 || len(tag.Name) == 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare insert statement
+    stmt, err := conn.Prepare("INSERT INTO tags(name, color, description, owner_id, board_id, parent_id) values(?,?,?,?,?,?)")
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    defer stmt.Close()
+
+    // Execute insert statement
+    res, err := stmt.Exec(tag.Name, tag.Color, tag.Desc, tag.OwnerId, tag.BoardId, tag.ParentId)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // Remember the new id
+    id, err := res.LastInsertId()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    tag.Id = int(id)
+    tag.Path = tagPath(tag.Id)
+
+    // No error
+    return true
+}
+
+// Updates an existing tag, moving its descendants along with it if the parent
+// changes. The owner and board cannot be changed. Returns true on success, false on error.
+func (tag *Tag) WriteValues() bool {
+    // Check that there is an input Id
+    if tag.Id <= 0 || len(tag.Name) == 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare update statement
+    stmt, err := conn.Prepare("UPDATE tags SET name = ?, color = ?, description = ?, parent_id = ? WHERE id = ?")
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    defer stmt.Close()
+
+    // Execute update statement
+    _, err = stmt.Exec(tag.Name, tag.Color, tag.Desc, tag.ParentId, tag.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    tag.Path = tagPath(tag.Id)
+
+    // No error
+    return true
+}
+
+// Read in the values of a tag based on id. Returns true if values were read.
+func (tag *Tag) ReadValues() bool {
+    // Check that there is an input Id
+    if tag.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := conn.QueryRow("SELECT id, name, color, description, owner_id, board_id, parent_id FROM tags WHERE id = ?", tag.Id).Scan(&tag.Id, &tag.Name, &tag.Color, &tag.Desc, &tag.OwnerId, &tag.BoardId, &tag.ParentId)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+    tag.Path = tagPath(tag.Id)
+
+    return true
+}
+
+// Move all todos tagged with this tag over to another tag, as part of a
+// transaction, bumping their versions and recording it in their history like
+// any other change. A target of 0 just removes this tag from the todos.
+func (tag *Tag) retag(tx *sql.Tx, target_id int) error {
+    // Find the todos before changing any of them
+    res, err := tx.Query("SELECT todo_id FROM todo_tags WHERE tag_id = ? ORDER BY todo_id", tag.Id)
+    if err != nil {
+        return err
+    }
+    var ids []int
+    for res.Next() {
+        var id int
+        err = res.Scan(&id)
+        if err != nil {
+            res.Close()
+            return err
+        }
+        ids = append(ids, id)
+    }
+    res.Close()
+
+    for _, id := range ids {
+        before := Todo{
+            Id:     id,
+        }
+        if !before.readValues(tx) {
+            // Nothing left to retag
+            continue
+        }
+
+        // The target takes the place of this tag, unless the todo already has
+        // it, in which case the todo just loses this tag
+        after := before
+        after.Editor = tag.Editor
+        after.TagIds = []int{}
+        for _, tag_id := range before.TagIds {
+            if tag_id == tag.Id {
+                if target_id <= 0 {
+                    continue
+                }
+                tag_id = target_id
+            }
+            after.TagIds = append(after.TagIds, tag_id)
+        }
+        after.NormalizeTags()
+        after.Version++
+
+        // Execute update statement
+        _, err = tx.Exec("UPDATE todos SET tag_id = ?, version = ? WHERE id = ?", after.TagId, after.Version, id)
+        if err == nil {
+            err = writeTodoTags(tx, id, after.TagIds)
+        }
+        if err == nil {
+            err = recordTodoEvent(tx, EventUpdate, &before, &after)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Remove a tag and all of its descendants from the database based on Id, moving
+// their todos over to the tag reassign_id, or just untagging them if reassign_id
+// is 0. Returns true on successful removal.
+func (tag *Tag) Remove(reassign_id int) bool {
+    // Check that there is an input Id
+    if tag.Id <= 0 {
+        return false
+    }
+
+    // The todos cannot be handed to a tag that is about to go away
+    subtree := TagDescendants([]int{tag.Id})
+    for _, id := range subtree {
+        if id == reassign_id {
+            return false
+        }
+    }
+
+    // Retagging and removal happen together
+    return inTransaction(func(tx *sql.Tx) error {
+        for _, id := range subtree {
+            sub := Tag{
+                Id:     id,
+                Editor: tag.Editor,
+            }
+            err := sub.retag(tx, reassign_id)
+            if err == nil {
+                _, err = tx.Exec("DELETE FROM tags WHERE id = ?", id)
+            }
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// Merge a tag into another: its todos are tagged with the target instead, its
+// children are nested under the target, and the tag is removed. Returns a
+// friendly error message, or "" on success.
+func (tag *Tag) MergeInto(target_id int) string {
+    // Check that there is an input Id
+    if tag.Id <= 0 || target_id <= 0 {
+        return "Tag not found in database"
+    }
+
+    // The target cannot be below the tag, it would end up under itself
+    if TagIsDescendant(target_id, tag.Id) {
+        return "Tag cannot be merged into its own descendant"
+    }
+
+    // The children cannot clash with the target's own children
+    target := Tag{
+        Id:         target_id,
+    }
+    if !target.ReadValues() {
+        return "Tag not found in database"
+    }
+    for _, child := range tag.children() {
+        if tagNameTaken(target.OwnerId, target.BoardId, target.Id, child.Name, child.Id) {
+            return "Tag name already in use"
+        }
+    }
+
+    // Moving the children, retagging and removal happen together
+    ok := inTransaction(func(tx *sql.Tx) error {
+        _, err := tx.Exec("UPDATE tags SET parent_id = ? WHERE parent_id = ?", target_id, tag.Id)
+        if err == nil {
+            err = tag.retag(tx, target_id)
+        }
+        if err == nil {
+            _, err = tx.Exec("DELETE FROM tags WHERE id = ?", tag.Id)
+        }
+        return err
+    })
+    if !ok {
+        return "Database error"
+    }
+
+    return ""
+}
+
+// List the tags nested right under this tag
+func (tag *Tag) children() []Tag {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    res, err := conn.Query("SELECT id, name FROM tags WHERE parent_id = ? ORDER BY id", tag.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    var r []Tag
+    for res.Next() {
+        var a Tag
+        err = res.Scan(&a.Id, &a.Name)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, a)
+    }
+
+    return r
+}
+
+// Work out the path of a tag by walking up its ancestors
+func tagPath(id int) string {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    var names []string
+    // Guard against loops just in case
+    seen := make(map[int]bool)
+    for id > 0 && !seen[id] {
+        seen[id] = true
+        var name string
+        err := conn.QueryRow("SELECT name, parent_id FROM tags WHERE id = ?", id).Scan(&name, &id)
+        if err != nil {
+            if err != sql.ErrNoRows {
+                log.Printf("Warning: Failed to read database: %s", err)
+            }
+            break
+        }
+        names = append([]string{name}, names...)
+    }
+
+    return strings.Join(names, TagPathSeparator)
+}
+
+// Count all tags in the database
+func countTags() int {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    var count int
+    err := conn.QueryRow("SELECT COUNT(*) FROM tags").Scan(&count)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return 0
+    }
+
+    return count
+}
+
 // List all tags in the database
 func ListAllTags() []Tag {
     // Get connection handle
     conn := database.GetConnection()
 
     // prepare read statement
-    stmt, err := conn.Prepare("SELECT * FROM tags")
+    stmt, err := conn.Prepare("SELECT id, name, color, description, owner_id, board_id, parent_id FROM tags ORDER BY id")
     if err != nil {
         log.Printf("Warning: Failed to read database: %s", err)
         return nil
@@ -44,7 +441,7 @@ func ListAllTags() []Tag {
     // Check results
     for res.Next() {
         // Read in the values from the database
-        err = res.Scan(&a.Id, &a.Name)
+        err = res.Scan(&a.Id, &a.Name, &a.Color, &a.Desc, &a.OwnerId, &a.BoardId, &a.ParentId)
         // Check for errors
         if err != nil {
             log.Printf("Warning: Failed to read database: %s", err)
@@ -54,6 +451,35 @@ func ListAllTags() []Tag {
         r = append(r, a)
     }
 
+    // Work out where each tag sits
+    fillTagPaths(r)
+
     // Done
     return r
 }
+
+// Check that all of the given tag ids exist and belong to board_id, or are
+// personal tags of owner_id if board_id is 0
+func TagsOwnedBy(ids []int, owner_id int, board_id int) bool {
+    if len(ids) == 0 {
+        return true
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Count the distinct matching tags
+    args := []interface{}{board_id, owner_id}
+    for _, id := range ids {
+        args = append(args, id)
+    }
+    var count int
+    err := conn.QueryRow("SELECT COUNT(*) FROM tags WHERE " + tagScopeCondition + " AND id IN (?" + strings.Repeat(",?", len(ids) - 1) + ")", args...).Scan(&count)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return false
+    }
+
+    // Ids were deduplicated, so every one of them has to match
+    return count == len(ids)
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+    "strings"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// Separator between the names of a tag and its ancestors in a tag path
+const TagPathSeparator = "/"
+
+// Index a list of tags by id
+func tagsById(tags []Tag) map[int]*Tag {
+    byId := make(map[int]*Tag, len(tags))
+    for i := range tags {
+        byId[tags[i].Id] = &tags[i]
+    }
+    return byId
+}
+
+// Fill in the path of every tag in a list, e.g. infra/ci for a tag named ci
+// whose parent is named infra
+func fillTagPaths(tags []Tag) {
+    byId := tagsById(tags)
+    for i := range tags {
+        names := []string{tags[i].Name}
+        // Walk up the ancestors, guarding against loops just in case
+        seen := map[int]bool{tags[i].Id: true}
+        for parent := byId[tags[i].ParentId]; parent != nil && !seen[parent.Id]; parent = byId[parent.ParentId] {
+            seen[parent.Id] = true
+            names = append([]string{parent.Name}, names...)
+        }
+        tags[i].Path = strings.Join(names, TagPathSeparator)
+    }
+}
+
+// Collect the given tags together with all of their descendants
+func TagDescendants(ids []int) []int {
+    if len(ids) == 0 {
+        return nil
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Walk down the subtrees, UNION stops at tags already seen
+    args := make([]interface{}, len(ids))
+    for i, id := range ids {
+        args[i] = id
+    }
+    res, err := conn.Query("WITH RECURSIVE down(id) AS (VALUES (?)" + strings.Repeat(",(?)", len(ids) - 1) + " UNION SELECT t.id FROM tags t JOIN down ON t.parent_id = down.id) SELECT id FROM down", args...)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    var r []int
+    for res.Next() {
+        var id int
+        err = res.Scan(&id)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, id)
+    }
+
+    return r
+}
+
+// Check whether a tag is one of the descendants of another (or the tag itself)
+func TagIsDescendant(id int, ancestor_id int) bool {
+    for _, d := range TagDescendants([]int{ancestor_id}) {
+        if d == id {
+            return true
+        }
+    }
+    return false
+}
+
+// Find the tag of an owner, or of a board if board_id isn't 0, at the given
+// path, e.g. infra/ci. Returns nil if there is none.
+func FindTagByPath(owner_id int, board_id int, path string) *Tag {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Walk down the path one name at a time, starting from the top-level tags
+    // (orphans included, as their paths start with them)
+    id := 0
+    parent := "(parent_id = 0 OR parent_id NOT IN (SELECT id FROM tags))"
+    for _, name := range strings.Split(path, TagPathSeparator) {
+        args := []interface{}{board_id, owner_id, name}
+        if id > 0 {
+            args = append(args, id)
+        }
+        err := conn.QueryRow("SELECT id FROM tags WHERE " + tagScopeCondition + " AND name = ? AND " + parent + " ORDER BY id LIMIT 1", args...).Scan(&id)
+        if err != nil {
+            if err != sql.ErrNoRows {
+                log.Printf("Warning: Failed to read database: %s", err)
+            }
+            return nil
+        }
+        parent = "parent_id = ?"
+    }
+
+    tag := Tag{
+        Id:         id,
+    }
+    if !tag.ReadValues() {
+        return nil
+    }
+    return &tag
+}
+
+// Arrange a list of tags into trees, returning the top-level tags with their
+// descendants nested in Children
+func BuildTagTree(tags []Tag) []Tag {
+    // Index the children of every tag
+    children := make(map[int][]Tag)
+    byId := tagsById(tags)
+    for _, tag := range tags {
+        parent := tag.ParentId
+        if byId[parent] == nil {
+            // Orphans are shown at the top
+            parent = 0
+        }
+        children[parent] = append(children[parent], tag)
+    }
+
+    // Nest them, starting from the top
+    seen := make(map[int]bool)
+    var nest func(parent int) []Tag
+    nest = func(parent int) []Tag {
+        var r []Tag
+        for _, tag := range children[parent] {
+            if seen[tag.Id] {
+                continue
+            }
+            seen[tag.Id] = true
+            tag.Children = nest(tag.Id)
+            r = append(r, tag)
+        }
+        return r
+    }
+
+    return nest(0)
+}
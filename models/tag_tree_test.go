@@ -0,0 +1,113 @@
+package models
+
+import (
+    // Standard library
+    "reflect"
+    "sort"
+    "testing"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// Add a tag straight to the database, returning its id
+func insertTestTag(t *testing.T, name string, owner_id int, board_id int, parent_id int) int {
+    t.Helper()
+    res, err := database.GetConnection().Exec("INSERT INTO tags(name, color, description, owner_id, board_id, parent_id) values(?,?,?,?,?,?)", name, "#07457E", "", owner_id, board_id, parent_id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    id, err := res.LastInsertId()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return int(id)
+}
+
+func TestTagDescendants(t *testing.T) {
+    testDatabase(t)
+
+    infra := insertTestTag(t, "infra", 1, 0, 0)
+    ci := insertTestTag(t, "ci", 1, 0, infra)
+    linux := insertTestTag(t, "linux", 1, 0, ci)
+    docs := insertTestTag(t, "docs", 1, 0, 0)
+    // Two tags nested under each other, which can't be made through the API
+    a := insertTestTag(t, "a", 1, 0, 0)
+    b := insertTestTag(t, "b", 1, 0, a)
+    _, err := database.GetConnection().Exec("UPDATE tags SET parent_id = ? WHERE id = ?", b, a)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        ids     []int
+        want    []int
+    }{
+        {nil, nil},
+        {[]int{linux}, []int{linux}},
+        {[]int{infra}, []int{infra, ci, linux}},
+        {[]int{ci, docs}, []int{ci, linux, docs}},
+        {[]int{infra, ci}, []int{infra, ci, linux}},
+        {[]int{a}, []int{a, b}},
+        // Unknown tags are kept, they just have no descendants
+        {[]int{999}, []int{999}},
+    }
+    for _, test := range tests {
+        got := TagDescendants(test.ids)
+        sort.Ints(got)
+        sort.Ints(test.want)
+        if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("TagDescendants(%v) = %v, want %v", test.ids, got, test.want)
+        }
+    }
+}
+
+func TestFindTagByPath(t *testing.T) {
+    testDatabase(t)
+
+    _, err := database.GetConnection().Exec("INSERT INTO users(name, password) values(?,?)", "alice", database.Hash("password"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    infra := insertTestTag(t, "infra", 1, 0, 0)
+    ci := insertTestTag(t, "ci", 1, 0, infra)
+    insertTestTag(t, "ci", 1, 0, 0)
+    // The same path for someone else, and on a board
+    otherInfra := insertTestTag(t, "infra", 2, 0, 0)
+    otherCi := insertTestTag(t, "ci", 2, 0, otherInfra)
+    boardInfra := insertTestTag(t, "infra", 1, 5, 0)
+    boardCi := insertTestTag(t, "ci", 2, 5, boardInfra)
+    // A tag whose parent was removed, shown at the top
+    orphan := insertTestTag(t, "old", 1, 0, 999)
+
+    tests := []struct {
+        owner_id    int
+        board_id    int
+        path        string
+        want        int
+    }{
+        {1, 0, "infra/ci", ci},
+        {2, 0, "infra/ci", otherCi},
+        {1, 5, "infra/ci", boardCi},
+        {2, 5, "infra/ci", boardCi},
+        {1, 0, "old", orphan},
+        {1, 0, "ci/infra", 0},
+        {1, 0, "infra/ci/linux", 0},
+        {1, 0, "infra/old", 0},
+        {3, 0, "infra", 0},
+        {1, 0, "", 0},
+    }
+    for _, test := range tests {
+        got := 0
+        if tag := FindTagByPath(test.owner_id, test.board_id, test.path); tag != nil {
+            got = tag.Id
+            if tag.Path != test.path {
+                t.Errorf("FindTagByPath(%d, %d, %q) has path %q", test.owner_id, test.board_id, test.path, tag.Path)
+            }
+        }
+        if got != test.want {
+            t.Errorf("FindTagByPath(%d, %d, %q) = %d, want %d", test.owner_id, test.board_id, test.path, got, test.want)
+        }
+    }
+}
@@ -2,27 +2,128 @@ package models
 
 import (
     // Standard library
+    "database/sql"
+    "fmt"
     "log"
+    "strings"
     "time"
+    "unicode/utf8"
 
     // Own stuff
     "github.com/ohnx/gotodo/database"
 )
 
+// The states a todo can be in
+const (
+    StateIdeas      = 1
+    StateDoingSoon  = 2
+    StateInProgress = 3
+    StatePaused     = 4
+    StateDone       = 5
+)
+
+// Names of the states, as shown to people
+var StateNames = map[int]string{
+    StateIdeas:         "Ideas",
+    StateDoingSoon:     "Doing soon",
+    StateInProgress:    "In progress",
+    StatePaused:        "Paused",
+    StateDone:          "Done",
+}
+
 type (
     // Represent a todo item
     Todo struct {
-        Id      int         `json:"id"`
-        State   int         `json:"state"`
-        TagId   int         `json:"tag_id"`
-        OwnerId int         `json:"owner_id"`
-        Public  bool        `json:"public"`
-        Name    string      `json:"name"`
-        DueDate time.Time   `json:"due_date"`
-        Desc    string      `json:"description"`
+        Id          int         `json:"id"`
+        State       int         `json:"state"`
+        // First of TagIds, kept for clients that only know a single tag
+        TagId       int         `json:"tag_id"`
+        TagIds      []int       `json:"tag_ids"`
+        OwnerId     int         `json:"owner_id"`
+        // Board the todo is on, 0 for a personal todo of its owner
+        BoardId     int         `json:"board_id"`
+        Public      bool        `json:"public"`
+        Name        string      `json:"name"`
+        DueDate     time.Time   `json:"due_date"`
+        Desc        string      `json:"description"`
+        // RFC 5545 recurrence rule, empty if the todo does not repeat
+        RRule       string      `json:"rrule"`
+        // IANA time zone the recurrence is evaluated in, empty for UTC
+        TimeZone    string      `json:"timezone"`
+        // First occurrence of the recurrence (DTSTART), managed by the server
+        RRuleStart  time.Time   `json:"-"`
+        // One of P0 (most urgent) to P3, empty if not prioritized
+        Priority    string      `json:"priority"`
+        // Effort estimate in points, 0 if not estimated
+        Estimate    int         `json:"estimate"`
+        // Position within the state column, managed by the server
+        Rank        string      `json:"rank"`
+        // When the todo was moved to the trash, nil if it is not in there
+        DeletedAt   *time.Time  `json:"deleted_at,omitempty"`
+        // Bumped on every change, starting at 1, to detect conflicting edits
+        Version     int         `json:"version"`
+        // Number of comments, only filled in when listing todos
+        CommentCount int        `json:"comment_count,omitempty"`
+        // Whether any todos blocking this one aren't done yet, only filled in
+        // when listing todos
+        Blocked     bool        `json:"blocked,omitempty"`
+        // Todos blocking this one, only filled in for detailed information
+        BlockedBy   []int       `json:"blocked_by,omitempty"`
+        // Users responsible for the todo besides its owner, only filled in
+        // when listing todos and for detailed information
+        Assignees   []Assignee  `json:"assignees,omitempty"`
+        // Token making changes to the todo, recorded in its history
+        Editor      *Token      `json:"-"`
+    }
+
+    // Narrow down a list of todos
+    TodoFilter struct {
+        // Only todos with any of these tags, or all of them if MatchAll is set
+        TagIds      []int       `json:"tag_ids"`
+        MatchAll    bool        `json:"match_all"`
+        // Only todos assigned to any of these users
+        AssigneeIds []int       `json:"assignee_ids"`
+        // Only todos on this board, 0 for personal todos, nil for any board
+        BoardId     *int        `json:"board_id"`
+        // Only the todos the user owns: their personal todos, and the todos on
+        // the boards they are an owner or admin of, or their own todos on
+        // boards they are a member of
+        OwnOnly     bool        `json:"-"`
+        // Only todos owned by this user, 0 for anyone
+        OwnerId     int         `json:"-"`
     }
 )
 
+// Longest name a todo can have, in characters
+const MaxTodoName = 256
+
+// Check whether a priority is one of the known ones
+func ValidPriority(priority string) bool {
+    switch priority {
+    case "", "P0", "P1", "P2", "P3":
+        return true
+    }
+    return false
+}
+
+// Check the fields of a todo that are up to its users. Returns a friendly error
+// message, or "" if the todo is valid.
+func (todo *Todo) Validate() string {
+    if len(todo.Name) == 0 || utf8.RuneCountInString(todo.Name) > MaxTodoName {
+        return fmt.Sprintf("Name must be 1 to %d characters", MaxTodoName)
+    }
+    if todo.State < StateIdeas || todo.State > StateDone {
+        return "Invalid state"
+    }
+    if !ValidPriority(todo.Priority) {
+        return "Invalid priority"
+    }
+    if todo.Estimate < 0 {
+        return "Invalid estimate"
+    }
+    return ""
+}
+
 // Inserts a new todo. Returns true on success, false on error.
 func (todo *Todo) InsertValues() bool {
     // Check that there is no input Id
@@ -30,71 +131,136 @@ func (todo *Todo) InsertValues() bool {
         return false
     }
 
-    // Get connection handle
-    conn := database.GetConnection()
+    ok := inTransaction(func(tx *sql.Tx) error {
+        return todo.insert(tx, EventCreate)
+    })
+    if !ok {
+        todo.Id = 0
+    }
+    return ok
+}
 
-    // prepare insert statement
-    stmt, err := conn.Prepare("INSERT INTO todos(state, tag_id, owner_id, public, name, duedate, description) values(?,?,?,?,?,?,?)")
+// Inserts a todo, keeping its Id if it has one, and records it in the history
+// as the given kind of event.
+func (todo *Todo) insert(db dbHandle, kind string) error {
+    // Inserting a NULL id makes up a new one
+    var id interface{}
+    if todo.Id > 0 {
+        id = todo.Id
+    }
 
+    // New todos go to the bottom of their column
+    todo.Rank = RankBetween(lastRank(db, todo.State, todo.OwnerId, todo.BoardId), "")
+    todo.Version = 1
+    todo.NormalizeTags()
+
+    // prepare insert statement
+    stmt, err := db.Prepare("INSERT INTO todos(id, state, tag_id, owner_id, board_id, public, name, duedate, description, rrule, rrule_start, timezone, priority, estimate, rank, version) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
     if err != nil {
-        log.Printf("Warning: Failed to write to database: %s", err)
-        return false
+        return err
     }
     defer stmt.Close()
 
     // Execute insert statement
-    _, err = stmt.Exec(todo.State, todo.TagId, todo.OwnerId, todo.Public, todo.Name, todo.DueDate, todo.Desc)
+    res, err := stmt.Exec(id, todo.State, todo.TagId, todo.OwnerId, todo.BoardId, todo.Public, todo.Name, todo.DueDate, todo.Desc, todo.RRule, todo.RRuleStart, todo.TimeZone, todo.Priority, todo.Estimate, todo.Rank, todo.Version)
     if err != nil {
-        log.Printf("Warning: Failed to write to database: %s", err)
-        return false
+        return err
     }
 
-    // No error
-    return true
+    // Find out the new id to link the tags to
+    newId, err := res.LastInsertId()
+    if err != nil {
+        return err
+    }
+    todo.Id = int(newId)
+
+    // Write the tags and remember the creation
+    err = writeTodoTags(db, todo.Id, todo.TagIds)
+    if err != nil {
+        return err
+    }
+    return recordTodoEvent(db, kind, nil, todo)
 }
 
-// Updates an existing todo. Returns true on success, false on error.
+// Updates an existing todo, as long as Version is still the current version.
+// Version is bumped afterwards. Returns true on success, false on error or if
+// the todo has been changed in the meantime.
 func (todo *Todo) WriteValues() bool {
     // Check that there is an input Id
     if todo.Id <= 0 || len(todo.Name) == 0 {
         return false
     }
 
-    // Get connection handle
-    conn := database.GetConnection()
+    return inTransaction(func(tx *sql.Tx) error {
+        return todo.write(tx, EventUpdate)
+    })
+}
 
-    // prepare insert statement
-    stmt, err := conn.Prepare("UPDATE todos SET state = ?, tag_id = ?, public = ?, name = ?, duedate = ?, description = ? WHERE id = ?")
+// Updates an existing todo and records it in the history as the given kind of
+// event. Fails with errConflict if Version is not the current version anymore.
+func (todo *Todo) write(db dbHandle, kind string) error {
+    todo.NormalizeTags()
+
+    // Keep the previous version around for the history
+    before := Todo{
+        Id:     todo.Id,
+    }
+    if !before.readValues(db) {
+        return errNotFound
+    }
+    if before.Version != todo.Version {
+        return errConflict
+    }
+    // None of these are changed here
+    todo.OwnerId = before.OwnerId
+    todo.BoardId = before.BoardId
+    todo.Rank = before.Rank
+    // Only restoring a previous version takes a todo out of the trash
+    if kind != EventRestore {
+        todo.DeletedAt = before.DeletedAt
+    }
 
+    // prepare update statement
+    stmt, err := db.Prepare("UPDATE todos SET state = ?, tag_id = ?, public = ?, name = ?, duedate = ?, description = ?, rrule = ?, rrule_start = ?, timezone = ?, priority = ?, estimate = ?, deleted_at = ?, version = version + 1 WHERE id = ? AND version = ?")
     if err != nil {
-        log.Printf("Warning: Failed to write to database: %s", err)
-        return false
+        return err
     }
     defer stmt.Close()
 
-    // Execute insert statement
-    _, err = stmt.Exec(todo.State, todo.TagId, todo.Public, todo.Name, todo.DueDate, todo.Desc, todo.Id)
+    // Execute update statement
+    res, err := stmt.Exec(todo.State, todo.TagId, todo.Public, todo.Name, todo.DueDate, todo.Desc, todo.RRule, todo.RRuleStart, todo.TimeZone, todo.Priority, todo.Estimate, todo.DeletedAt, todo.Id, todo.Version)
     if err != nil {
-        log.Printf("Warning: Failed to write to database: %s", err)
-        return false
+        return err
     }
+    if n, err := res.RowsAffected(); err != nil || n == 0 {
+        // Someone else got there first
+        return errConflict
+    }
+    todo.Version++
 
-    // No error
-    return true
+    // Write the tags and remember the change
+    err = writeTodoTags(db, todo.Id, todo.TagIds)
+    if err != nil {
+        return err
+    }
+    return recordTodoEvent(db, kind, &before, todo)
 }
 
 // Read in the values of a todo based on id. Returns true if values were read.
 func (todo *Todo) ReadValues() bool {
+    return todo.readValues(database.GetConnection())
+}
+
+// Read in the values of a todo based on id, as seen by db. Returns true if
+// values were read.
+func (todo *Todo) readValues(db dbHandle) bool {
     // Check that there is an input Id
     if todo.Id < 1 {
         return false
     }
 
-    // Get connection handle
-    conn := database.GetConnection()
-
     // prepare read statement
-    stmt, err := conn.Prepare("SELECT * FROM todos WHERE id = ?")
+    stmt, err := db.Prepare("SELECT id, state, owner_id, board_id, public, name, duedate, description, rrule, rrule_start, timezone, priority, estimate, rank, deleted_at, version, " + todoTagsColumn + " FROM todos WHERE id = ?")
     if err != nil {
         log.Printf("Warning: Failed to read database: %s", err)
         return false
@@ -112,12 +278,15 @@ func (todo *Todo) ReadValues() bool {
     // Check results
     for res.Next() {
         var boolConv int
+        var tagIds string
         // Only care about the 1st result
-        err = res.Scan(&todo.Id, &todo.State, &todo.TagId, &todo.OwnerId, &boolConv, &todo.Name, &todo.DueDate, &todo.Desc)
+        err = res.Scan(&todo.Id, &todo.State, &todo.OwnerId, &todo.BoardId, &boolConv, &todo.Name, &todo.DueDate, &todo.Desc, &todo.RRule, &todo.RRuleStart, &todo.TimeZone, &todo.Priority, &todo.Estimate, &todo.Rank, &todo.DeletedAt, &todo.Version, &tagIds)
         if err != nil {
             log.Printf("Warning: Failed to read database: %s", err)
             return false
         }
+        todo.TagIds = parseTagIds(tagIds)
+        todo.NormalizeTags()
         if boolConv == 1 {
             todo.Public = true
         } else {
@@ -130,7 +299,8 @@ func (todo *Todo) ReadValues() bool {
     return false
 }
 
-// Read in the only the owner and publicness of a todo id. Returns true if values were read.
+// Read in the only the owner, board and publicness of a todo id, unless it is in
+// the trash. Returns true if values were read.
 func (todo *Todo) ReadPermissions() bool {
     // Check that there is an input Id
     if todo.Id < 1 {
@@ -141,7 +311,7 @@ func (todo *Todo) ReadPermissions() bool {
     conn := database.GetConnection()
 
     // prepare read statement
-    stmt, err := conn.Prepare("SELECT owner_id, public FROM todos WHERE id = ?")
+    stmt, err := conn.Prepare("SELECT owner_id, board_id, public FROM todos WHERE id = ? AND deleted_at IS NULL")
     if err != nil {
         log.Printf("Warning: Failed to read database: %s", err)
         return false
@@ -160,7 +330,7 @@ func (todo *Todo) ReadPermissions() bool {
     for res.Next() {
         // Only care about the 1st result
         var boolConv int
-        err = res.Scan(&todo.OwnerId, &boolConv)
+        err = res.Scan(&todo.OwnerId, &todo.BoardId, &boolConv)
         if err != nil {
             log.Printf("Warning: Failed to read database: %s", err)
             return false
@@ -179,41 +349,108 @@ func (todo *Todo) ReadPermissions() bool {
     return false
 }
 
-// Remove a todo from the database based on Id. Returns true on successful removal.
+// Move a todo to the trash based on Id. It stays in the database until it is
+// restored or purged. Returns true on success.
 func (todo *Todo) Remove() bool {
     // Check that there is an input Id
     if todo.Id <= 0 {
         return false
     }
 
-    // Get connection handle
-    conn := database.GetConnection()
+    return inTransaction(func(tx *sql.Tx) error {
+        return todo.remove(tx)
+    })
+}
 
-    // prepare delete statement
-    stmt, err := conn.Prepare("DELETE FROM todos WHERE id = ?")
+// Move a todo to the trash and record it in the history
+func (todo *Todo) remove(db dbHandle) error {
+    // Keep the last version around for the history
+    before := Todo{
+        Id:     todo.Id,
+    }
+    if !before.readValues(db) || before.DeletedAt != nil {
+        return errNotFound
+    }
+    after := before
+    after.Editor = todo.Editor
+    now := time.Now().UTC()
+    after.DeletedAt = &now
+    after.Version++
+
+    // Execute update statement
+    _, err := db.Exec("UPDATE todos SET deleted_at = ?, version = ? WHERE id = ?", after.DeletedAt, after.Version, todo.Id)
     if err != nil {
-        log.Printf("Warning: Failed to write to database: %s", err)
-        return false
+        return err
     }
-    defer stmt.Close()
-
-    // Execute delete statement
-    _, err = stmt.Exec(todo.Id)
+    err = recordTodoEvent(db, EventDelete, &before, &after)
     if err != nil {
-        log.Printf("Warning: Failed to write to database: %s", err)
-        return false
+        return err
     }
 
-    return true
+    todo.DeletedAt = after.DeletedAt
+    todo.Version = after.Version
+    return nil
 }
 
 // List all viewable todos to the owner_id in the database
 func ListAllTodos(owner_id int) []Todo {
+    return ListTodos(owner_id, TodoFilter{})
+}
+
+// List the viewable todos to the owner_id in the database that match a filter
+func ListTodos(owner_id int, filter TodoFilter) []Todo {
     // Get connection handle
     conn := database.GetConnection()
 
+    // Build up the query
+    query := "SELECT id,state,board_id,name,duedate,rrule,priority,estimate,rank,version," + todoCommentsColumn + "," + todoBlockedColumn + "," + todoTagsColumn + "," + todoAssigneesColumn + " FROM todos WHERE " + todoVisibleCondition + " AND deleted_at IS NULL"
+    args := []interface{}{owner_id, owner_id, owner_id, owner_id}
+    if len(filter.TagIds) > 0 {
+        // Repeated tags would match the same todos twice
+        unique := Todo{TagIds: filter.TagIds}
+        unique.NormalizeTags()
+
+        // Filtering by a tag also matches the tags nested under it
+        var groups [][]int
+        if filter.MatchAll {
+            // A todo has to match every tag (or a descendant) separately
+            for _, id := range unique.TagIds {
+                groups = append(groups, TagDescendants([]int{id}))
+            }
+        } else {
+            // A todo has to match any of the tags (or their descendants)
+            groups = append(groups, TagDescendants(unique.TagIds))
+        }
+
+        for _, group := range groups {
+            query += " AND id IN (SELECT todo_id FROM todo_tags WHERE tag_id IN (?" + strings.Repeat(",?", len(group) - 1) + "))"
+            for _, id := range group {
+                args = append(args, id)
+            }
+        }
+    }
+    if len(filter.AssigneeIds) > 0 {
+        query += " AND id IN (SELECT todo_id FROM todo_assignees WHERE user_id IN (?" + strings.Repeat(",?", len(filter.AssigneeIds) - 1) + "))"
+        for _, id := range filter.AssigneeIds {
+            args = append(args, id)
+        }
+    }
+    if filter.BoardId != nil {
+        query += " AND board_id = ?"
+        args = append(args, *filter.BoardId)
+    }
+    if filter.OwnerId > 0 {
+        query += " AND owner_id = ?"
+        args = append(args, filter.OwnerId)
+    }
+    if filter.OwnOnly {
+        query += " AND " + todoOwnedCondition
+        args = append(args, owner_id, owner_id, owner_id)
+    }
+    query += " ORDER BY rank, id"
+
     // prepare read statement
-    stmt, err := conn.Prepare("SELECT id,state,tag_id,name,duedate FROM todos WHERE public = 1 OR owner_id = ?")
+    stmt, err := conn.Prepare(query)
     if err != nil {
         log.Printf("Warning: Failed to read database: %s", err)
         return nil
@@ -221,7 +458,7 @@ func ListAllTodos(owner_id int) []Todo {
     defer stmt.Close()
 
     // Execute read statement
-    res, err := stmt.Query(owner_id)
+    res, err := stmt.Query(args...)
     if err != nil {
         log.Printf("Warning: Failed to read database: %s", err)
         return nil
@@ -230,28 +467,32 @@ func ListAllTodos(owner_id int) []Todo {
 
     // Create new slice for storing output
     var r []Todo
-    var todo Todo
 
     // Check results
     for res.Next() {
         // Read in the values from the database
-        var boolConv int
-        err = res.Scan(&todo.Id, &todo.State, &todo.TagId, &todo.Name, &todo.DueDate)
+        var todo Todo
+        var tagIds, assigneeIds string
+        err = res.Scan(&todo.Id, &todo.State, &todo.BoardId, &todo.Name, &todo.DueDate, &todo.RRule, &todo.Priority, &todo.Estimate, &todo.Rank, &todo.Version, &todo.CommentCount, &todo.Blocked, &tagIds, &assigneeIds)
         if err != nil {
             log.Printf("Warning: Failed to read database: %s", err)
             return nil
         }
-
-        if boolConv == 1 {
-            todo.Public = true
-        } else {
-            todo.Public = false
+        todo.TagIds = parseTagIds(tagIds)
+        todo.NormalizeTags()
+        for _, id := range parseTagIds(assigneeIds) {
+            todo.Assignees = append(todo.Assignees, Assignee{Id: id})
         }
 
         // No errors, append to slice
         r = append(r, todo)
     }
 
+    // The names of the assignees are read all at once
+    if !readAssigneeNames(r) {
+        return nil
+    }
+
     // Done
     return r
 }
@@ -0,0 +1,140 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// A number of changes to todos made in a single transaction. Each change sees
+// the ones made before it, and nothing is visible to anyone else until the batch
+// is committed.
+type TodoBatch struct {
+    tx      *sql.Tx
+}
+
+// Start a new batch. Returns nil on error.
+func BeginTodoBatch() *TodoBatch {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    tx, err := conn.Begin()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return nil
+    }
+
+    return &TodoBatch{
+        tx:     tx,
+    }
+}
+
+// Log the error of a change in the batch, if it is the database's fault.
+// Returns true if there was no error.
+func batchResult(err error) bool {
+    if err != nil && err != errConflict && err != errNotFound {
+        log.Printf("Warning: Failed to write to database: %s", err)
+    }
+    return err == nil
+}
+
+// Make all the changes in the batch at once. Returns true on success.
+func (batch *TodoBatch) Commit() bool {
+    if !batchResult(batch.tx.Commit()) {
+        return false
+    }
+    publishTodoEvents()
+    return true
+}
+
+// Throw away all the changes in the batch
+func (batch *TodoBatch) Rollback() {
+    batch.tx.Rollback()
+}
+
+// Remember the current point in the batch, to be able to undo the changes
+// made after it on their own. Returns true on success.
+func (batch *TodoBatch) Savepoint() bool {
+    _, err := batch.tx.Exec("SAVEPOINT batch_item")
+    return batchResult(err)
+}
+
+// Undo the changes made since the last savepoint
+func (batch *TodoBatch) RollbackToSavepoint() bool {
+    _, err := batch.tx.Exec("ROLLBACK TO SAVEPOINT batch_item")
+    if err == nil {
+        _, err = batch.tx.Exec("RELEASE SAVEPOINT batch_item")
+    }
+    return batchResult(err)
+}
+
+// Keep the changes made since the last savepoint
+func (batch *TodoBatch) ReleaseSavepoint() bool {
+    _, err := batch.tx.Exec("RELEASE SAVEPOINT batch_item")
+    return batchResult(err)
+}
+
+// Read in the values of a todo based on id, including the changes made in the
+// batch so far. Returns true if values were read.
+func (batch *TodoBatch) ReadValues(todo *Todo) bool {
+    return todo.readValues(batch.tx)
+}
+
+// List the ids of the todos blocking a todo that aren't done yet, including the
+// changes made in the batch so far
+func (batch *TodoBatch) UnfinishedBlockers(todo_id int) []int {
+    return listUnfinishedBlockers(batch.tx, todo_id)
+}
+
+// Work out how much the owner of a token can do with a todo, like AccessOf
+func (batch *TodoBatch) AccessOf(todo *Todo, auth *Token) int {
+    return todo.accessOf(batch.tx, auth)
+}
+
+// Insert a new todo as part of the batch. Returns true on success.
+func (batch *TodoBatch) Insert(todo *Todo) bool {
+    // Check that there is no input Id
+    if todo.Id > 0 || len(todo.Name) == 0 {
+        return false
+    }
+
+    if !batchResult(todo.insert(batch.tx, EventCreate)) {
+        todo.Id = 0
+        return false
+    }
+    return true
+}
+
+// Update an existing todo as part of the batch, as long as Version is still
+// the current version. Returns true on success.
+func (batch *TodoBatch) Write(todo *Todo) bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 || len(todo.Name) == 0 {
+        return false
+    }
+
+    return batchResult(todo.write(batch.tx, EventUpdate))
+}
+
+// Move a todo as part of the batch, like Move. Returns true on success.
+func (batch *TodoBatch) Move(todo *Todo, state int, position int) bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 {
+        return false
+    }
+
+    return batchResult(todo.move(batch.tx, state, position))
+}
+
+// Move a todo to the trash as part of the batch. Returns true on success.
+func (batch *TodoBatch) Remove(todo *Todo) bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 {
+        return false
+    }
+
+    return batchResult(todo.remove(batch.tx))
+}
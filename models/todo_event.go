@@ -0,0 +1,356 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "encoding/json"
+    "log"
+    "reflect"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// The kinds of changes recorded in the history of a todo
+const (
+    EventCreate     = "create"
+    EventUpdate     = "update"
+    EventState      = "state"
+    EventDelete     = "delete"
+    EventRestore    = "restore"
+    EventPurge      = "purge"
+    // Who can see or change the todo changed, not the todo itself. The users
+    // it changed for are the new value of the "access" change.
+    EventAccess     = "access"
+)
+
+type (
+    // Represent a change made to a todo
+    TodoEvent struct {
+        Id          int         `json:"id"`
+        TodoId      int         `json:"todo_id"`
+        Kind        string      `json:"kind"`
+        // Who made the change, and with which token. 0 if done by the server.
+        UserId      int         `json:"user_id"`
+        UserName    string      `json:"user_name"`
+        TokenId     int         `json:"token_id"`
+        Time        time.Time   `json:"time"`
+        // Fields that changed, by JSON name
+        Changes     map[string]TodoFieldChange  `json:"changes"`
+        // The todo after the change
+        Todo        Todo        `json:"todo"`
+    }
+
+    // Represent the change of one field of a todo
+    TodoFieldChange struct {
+        Old     interface{}     `json:"old"`
+        New     interface{}     `json:"new"`
+    }
+)
+
+// Fields of a todo in their JSON form
+func todoFields(todo *Todo) map[string]interface{} {
+    fields := make(map[string]interface{})
+    if todo == nil {
+        return fields
+    }
+    jtodo, _ := json.Marshal(todo)
+    json.Unmarshal(jtodo, &fields)
+    return fields
+}
+
+// Work out which fields differ between two versions of a todo
+func diffTodos(before, after *Todo) map[string]TodoFieldChange {
+    old := todoFields(before)
+    new := todoFields(after)
+
+    changes := make(map[string]TodoFieldChange)
+    for name, value := range new {
+        if !reflect.DeepEqual(old[name], value) {
+            changes[name] = TodoFieldChange{
+                Old:    old[name],
+                New:    value,
+            }
+        }
+    }
+    for name, value := range old {
+        if _, ok := new[name]; !ok {
+            changes[name] = TodoFieldChange{
+                Old:    value,
+            }
+        }
+    }
+
+    return changes
+}
+
+// Record a change to a todo as part of the transaction making it. before is
+// nil for new todos. The editor of after is the one making the change.
+func recordTodoEvent(tx dbHandle, kind string, before, after *Todo) error {
+    changes := diffTodos(before, after)
+
+    // Moving a todo around the board is only a state change
+    if kind == EventUpdate {
+        kind = EventState
+        significant := false
+        for name := range changes {
+            if name != "state" && name != "rank" && name != "version" {
+                kind = EventUpdate
+            }
+            if name != "version" {
+                significant = true
+            }
+        }
+        if !significant {
+            // Nothing to remember
+            return nil
+        }
+    }
+
+    // Who did it
+    userId, tokenId := 0, 0
+    if after.Editor != nil {
+        userId = after.Editor.OwnerId
+        tokenId = after.Editor.Id
+    }
+
+    jchanges, _ := json.Marshal(changes)
+    jsnapshot, _ := json.Marshal(after)
+    _, err := tx.Exec("INSERT INTO todo_events(todo_id, kind, user_id, token_id, time, changes, snapshot) values(?,?,?,?,?,?,?)",
+        after.Id, kind, userId, tokenId, time.Now().UTC(), string(jchanges), string(jsnapshot))
+    return err
+}
+
+// Record that who can see or change a todo changed for some users, as part of
+// the transaction changing it
+func recordAccessEvent(tx dbHandle, todo_id int, user_ids []int, editor *Token) error {
+    if len(user_ids) == 0 {
+        return nil
+    }
+
+    // The todo itself stays the same
+    todo := Todo{
+        Id:     todo_id,
+    }
+    if !todo.readValues(tx) {
+        return errNotFound
+    }
+
+    // Who did it
+    userId, tokenId := 0, 0
+    if editor != nil {
+        userId = editor.OwnerId
+        tokenId = editor.Id
+    }
+
+    changes := map[string]TodoFieldChange{
+        "access":   {New: user_ids},
+    }
+    jchanges, _ := json.Marshal(changes)
+    jsnapshot, _ := json.Marshal(todo)
+    _, err := tx.Exec("INSERT INTO todo_events(todo_id, kind, user_id, token_id, time, changes, snapshot) values(?,?,?,?,?,?,?)",
+        todo_id, EventAccess, userId, tokenId, time.Now().UTC(), string(jchanges), string(jsnapshot))
+    return err
+}
+
+// Check whether an access event changed who can see or change the todo for a
+// user
+func (event *TodoEvent) AccessChangedFor(user_id int) bool {
+    if event.Kind != EventAccess {
+        return false
+    }
+    var users []int
+    jusers, _ := json.Marshal(event.Changes["access"].New)
+    json.Unmarshal(jusers, &users)
+    for _, id := range users {
+        if id == user_id {
+            return true
+        }
+    }
+    return false
+}
+
+// List the history of a todo, oldest first
+func ListTodoEvents(todo_id int) []TodoEvent {
+    return listTodoEvents("e.todo_id = ? ORDER BY e.id", todo_id)
+}
+
+// List the changes to all todos recorded after the event after_id, oldest
+// first, at most limit of them
+func ListTodoEventsSince(after_id int, limit int) []TodoEvent {
+    return listTodoEvents("e.id > ? ORDER BY e.id LIMIT ?", after_id, limit)
+}
+
+// Find out the id of the latest recorded change to any todo, 0 if there is none
+func LatestTodoEventId() int {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    var id int
+    err := conn.QueryRow("SELECT IFNULL(MAX(id), 0) FROM todo_events").Scan(&id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return 0
+    }
+    return id
+}
+
+// List the events matching a condition on todo_events e, which also orders them
+func listTodoEvents(condition string, args ...interface{}) []TodoEvent {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare read statement
+    stmt, err := conn.Prepare("SELECT e.id, e.todo_id, e.kind, e.user_id, IFNULL(u.name, ''), e.token_id, e.time, e.changes, e.snapshot FROM todo_events e LEFT JOIN users u ON u.id = e.user_id WHERE " + condition)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer stmt.Close()
+
+    // Execute read statement
+    res, err := stmt.Query(args...)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Create new slice for storing output
+    var r []TodoEvent
+
+    // Check results
+    for res.Next() {
+        var event TodoEvent
+        var jchanges, jsnapshot string
+        err = res.Scan(&event.Id, &event.TodoId, &event.Kind, &event.UserId, &event.UserName, &event.TokenId, &event.Time, &jchanges, &jsnapshot)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        json.Unmarshal([]byte(jchanges), &event.Changes)
+        json.Unmarshal([]byte(jsnapshot), &event.Todo)
+
+        // No errors, append to slice
+        r = append(r, event)
+    }
+
+    // Done
+    return r
+}
+
+// Work out the values of the todo of an event as they were right before it
+func (event *TodoEvent) Previous() Todo {
+    fields := todoFields(&event.Todo)
+    for name, change := range event.Changes {
+        fields[name] = change.Old
+    }
+
+    var todo Todo
+    jtodo, _ := json.Marshal(fields)
+    json.Unmarshal(jtodo, &todo)
+    return todo
+}
+
+// Read in the values of a todo as they were right after an event in its
+// history. Returns true if values were read.
+func (todo *Todo) ReadVersion(event_id int) bool {
+    // Check that there is an input Id
+    if todo.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    var jsnapshot string
+    err := conn.QueryRow("SELECT snapshot FROM todo_events WHERE id = ? AND todo_id = ?", event_id, todo.Id).Scan(&jsnapshot)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    err = json.Unmarshal([]byte(jsnapshot), todo)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return false
+    }
+    return true
+}
+
+// Read in the values of a todo as they were at its latest recorded event, which
+// also works for todos that are in the trash or have been purged. Returns true
+// if values were read.
+func (todo *Todo) ReadLatestVersion() bool {
+    // Check that there is an input Id
+    if todo.Id < 1 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    var event_id int
+    err := conn.QueryRow("SELECT MAX(id) FROM todo_events WHERE todo_id = ?", todo.Id).Scan(&event_id)
+    if err != nil {
+        // No history at all
+        return false
+    }
+
+    return todo.ReadVersion(event_id)
+}
+
+// Bring a todo back to how it was right after an event in its history, taking
+// it out of the trash if needed. Tags that have been removed or given away since
+// are left out. Returns true on success, false on error.
+func (todo *Todo) Restore(event_id int) bool {
+    version := Todo{
+        Id:     todo.Id,
+    }
+    if !version.ReadVersion(event_id) || len(version.Name) == 0 {
+        // Nothing left to go back to once a todo has been purged
+        return false
+    }
+    version.Editor = todo.Editor
+    version.DeletedAt = nil
+
+    // Only keep the tags that are still around
+    tagIds := []int{}
+    for _, id := range version.TagIds {
+        if TagsOwnedBy([]int{id}, version.OwnerId, version.BoardId) {
+            tagIds = append(tagIds, id)
+        }
+    }
+    version.TagIds = tagIds
+    version.TagId = 0
+
+    // The start of the recurrence is not part of the history
+    current := Todo{
+        Id:     todo.Id,
+    }
+    exists := current.ReadValues()
+    version.Version = current.Version
+    if exists && current.RRule == version.RRule && current.TimeZone == version.TimeZone {
+        version.RRuleStart = current.RRuleStart
+    } else if version.PrepareRecurrence() != nil {
+        // Was valid back then, but not anymore
+        version.RRule = ""
+        version.RRuleStart = time.Time{}
+    }
+
+    ok := inTransaction(func(tx *sql.Tx) error {
+        if exists {
+            return version.write(tx, EventRestore)
+        }
+        return version.insert(tx, EventRestore)
+    })
+    if ok {
+        *todo = version
+    }
+    return ok
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+    // Standard library
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// Check whether a patch changes nothing but the state of a todo, which is all
+// that the users it is assigned to can change. Fields that can't be patched are
+// checked by Patch.
+func StateOnlyPatch(patch map[string]json.RawMessage) bool {
+    for name := range patch {
+        switch name {
+        case "id", "version", "state", "comment_count", "blocked", "blocked_by", "assignees", "owner_id", "board_id", "rank", "deleted_at":
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// Apply a JSON Merge Patch (RFC 7396) to a todo: fields present in the patch are
+// changed, fields set to null are cleared, and all others are left alone. Each
+// field is checked on its own, and the patched todo as a whole like any other.
+// Returns an error message, or "" if the patch was applied. The todo is left
+// partially patched on error.
+func (todo *Todo) Patch(patch map[string]json.RawMessage) string {
+    // tag_ids wins over the older tag_id if both are there
+    if _, ok := patch["tag_ids"]; ok {
+        delete(patch, "tag_id")
+    }
+
+    for name, raw := range patch {
+        null := string(raw) == "null"
+        var err error
+
+        switch name {
+        case "id", "version":
+            // Identify the todo and its version, not changed by a patch
+        case "comment_count", "blocked", "blocked_by", "assignees":
+            // Worked out by the server, so sending back a todo as read is fine
+        case "owner_id", "board_id", "rank", "deleted_at":
+            // Can't be changed by a patch, but sending back a todo as read is
+            // fine
+            if !todo.patchUnchanged(name, raw) {
+                return fmt.Sprintf("Field %s can't be changed", name)
+            }
+        case "state":
+            err = json.Unmarshal(raw, &todo.State)
+            if null {
+                return "Invalid state"
+            }
+        case "name":
+            err = json.Unmarshal(raw, &todo.Name)
+            if null {
+                return fmt.Sprintf("Name must be 1 to %d characters", MaxTodoName)
+            }
+        case "due_date":
+            var due time.Time
+            err = json.Unmarshal(raw, &due)
+            if null {
+                return "Invalid due date"
+            }
+            todo.DueDate = due
+        case "public":
+            todo.Public = false
+            err = json.Unmarshal(raw, &todo.Public)
+        case "description":
+            todo.Desc = ""
+            err = json.Unmarshal(raw, &todo.Desc)
+        case "rrule":
+            // Checked along with the time zone once everything is applied
+            todo.RRule = ""
+            err = json.Unmarshal(raw, &todo.RRule)
+        case "timezone":
+            todo.TimeZone = ""
+            err = json.Unmarshal(raw, &todo.TimeZone)
+            if err == nil {
+                if _, err := todo.Location(); err != nil {
+                    return "Invalid time zone"
+                }
+            }
+        case "priority":
+            todo.Priority = ""
+            err = json.Unmarshal(raw, &todo.Priority)
+        case "estimate":
+            todo.Estimate = 0
+            err = json.Unmarshal(raw, &todo.Estimate)
+        case "tag_ids":
+            todo.TagIds = []int{}
+            err = json.Unmarshal(raw, &todo.TagIds)
+            if todo.TagIds == nil {
+                todo.TagIds = []int{}
+            }
+        case "tag_id":
+            // Older clients replace the tags with a single one
+            var tagId int
+            err = json.Unmarshal(raw, &tagId)
+            todo.TagIds = []int{}
+            if tagId > 0 {
+                todo.TagIds = []int{tagId}
+            }
+        default:
+            return fmt.Sprintf("Unknown field %s", name)
+        }
+
+        if err != nil {
+            return fmt.Sprintf("Invalid %s", name)
+        }
+    }
+
+    todo.NormalizeTags()
+    return todo.Validate()
+}
+
+// Check whether a field that can't be patched is given the value it already
+// has
+func (todo *Todo) patchUnchanged(name string, raw json.RawMessage) bool {
+    switch name {
+    case "owner_id", "board_id":
+        current := todo.OwnerId
+        if name == "board_id" {
+            current = todo.BoardId
+        }
+        var id int
+        return string(raw) != "null" && json.Unmarshal(raw, &id) == nil && id == current
+    case "rank":
+        var rank string
+        return string(raw) != "null" && json.Unmarshal(raw, &rank) == nil && rank == todo.Rank
+    case "deleted_at":
+        var deleted *time.Time
+        if json.Unmarshal(raw, &deleted) != nil {
+            return false
+        }
+        if deleted == nil || todo.DeletedAt == nil {
+            return deleted == todo.DeletedAt
+        }
+        return deleted.Equal(*todo.DeletedAt)
+    }
+    return false
+}
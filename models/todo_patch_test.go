@@ -0,0 +1,136 @@
+package models
+
+import (
+    // Standard library
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+// The todo the patches in the tests are applied to
+func patchTestTodo() Todo {
+    deleted := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+    return Todo{
+        Id:         7,
+        State:      StateDoingSoon,
+        OwnerId:    1,
+        BoardId:    2,
+        Name:       "Write tests",
+        DueDate:    time.Date(2023, 4, 2, 9, 0, 0, 0, time.UTC),
+        Desc:       "All of them",
+        Priority:   "P1",
+        Estimate:   30,
+        TagIds:     []int{3, 4},
+        TagId:      3,
+        Rank:       "i",
+        DeletedAt:  &deleted,
+        Version:    5,
+    }
+}
+
+func TestTodoPatch(t *testing.T) {
+    tests := []struct {
+        name    string
+        patch   string
+        err     string
+        // Changes the patch is expected to make to the todo
+        want    func(todo *Todo)
+    }{
+        {"empty", `{}`, "", nil},
+        {"name", `{"name": "Write more tests"}`, "", func(todo *Todo) { todo.Name = "Write more tests" }},
+        {"clear name", `{"name": null}`, "Name must be 1 to 256 characters", nil},
+        {"empty name", `{"name": ""}`, "Name must be 1 to 256 characters", nil},
+        {"long name", `{"name": "` + strings.Repeat("a", MaxTodoName + 1) + `"}`, "Name must be 1 to 256 characters", nil},
+        {"state", `{"state": 3}`, "", func(todo *Todo) { todo.State = StateInProgress }},
+        {"unknown state", `{"state": 9}`, "Invalid state", nil},
+        {"no state", `{"state": 0}`, "Invalid state", nil},
+        {"clear state", `{"state": null}`, "Invalid state", nil},
+        {"wrong type", `{"state": "done"}`, "Invalid state", nil},
+        {"clear due date", `{"due_date": null}`, "Invalid due date", nil},
+        {"clear description", `{"description": null}`, "", func(todo *Todo) { todo.Desc = "" }},
+        {"clear priority", `{"priority": null}`, "", func(todo *Todo) { todo.Priority = "" }},
+        {"unknown priority", `{"priority": "P9"}`, "Invalid priority", nil},
+        {"negative estimate", `{"estimate": -1}`, "Invalid estimate", nil},
+        {"time zone", `{"timezone": "Europe/Paris"}`, "", func(todo *Todo) { todo.TimeZone = "Europe/Paris" }},
+        {"unknown time zone", `{"timezone": "Nowhere/Town"}`, "Invalid time zone", nil},
+        {"tags", `{"tag_ids": [5, 5, 6]}`, "", func(todo *Todo) { todo.TagIds = []int{5, 6}; todo.TagId = 5 }},
+        {"clear tags", `{"tag_ids": null}`, "", func(todo *Todo) { todo.TagIds = []int{}; todo.TagId = 0 }},
+        {"single tag", `{"tag_id": 6}`, "", func(todo *Todo) { todo.TagIds = []int{6}; todo.TagId = 6 }},
+        {"tags win over tag", `{"tag_id": 6, "tag_ids": [5]}`, "", func(todo *Todo) { todo.TagIds = []int{5}; todo.TagId = 5 }},
+        {"unknown field", `{"colour": "red"}`, "Unknown field colour", nil},
+        {"worked out by the server", `{"comment_count": 3, "blocked": true, "blocked_by": [1], "assignees": [2]}`, "", nil},
+        {"id and version", `{"id": 8, "version": 1}`, "", nil},
+        {"same owner", `{"owner_id": 1}`, "", nil},
+        {"other owner", `{"owner_id": 2}`, "Field owner_id can't be changed", nil},
+        {"same board", `{"board_id": 2}`, "", nil},
+        {"other board", `{"board_id": 0}`, "Field board_id can't be changed", nil},
+        {"clear board", `{"board_id": null}`, "Field board_id can't be changed", nil},
+        {"same rank", `{"rank": "i"}`, "", nil},
+        {"other rank", `{"rank": "j"}`, "Field rank can't be changed", nil},
+        {"same deletion", `{"deleted_at": "2023-04-01T14:00:00+02:00"}`, "", nil},
+        {"other deletion", `{"deleted_at": "2023-04-01T13:00:00Z"}`, "Field deleted_at can't be changed", nil},
+        {"restore", `{"deleted_at": null}`, "Field deleted_at can't be changed", nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var patch map[string]json.RawMessage
+            if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+                t.Fatal(err)
+            }
+
+            todo := patchTestTodo()
+            err := todo.Patch(patch)
+            if err != test.err {
+                t.Fatalf("Patch(%s) = %q, want %q", test.patch, err, test.err)
+            }
+            if len(test.err) != 0 {
+                return
+            }
+
+            want := patchTestTodo()
+            if test.want != nil {
+                test.want(&want)
+            }
+            if !reflect.DeepEqual(todo, want) {
+                t.Errorf("Patch(%s) gave %+v, want %+v", test.patch, todo, want)
+            }
+        })
+    }
+}
+
+// A todo that was never deleted can be sent back as read too
+func TestTodoPatchNotDeleted(t *testing.T) {
+    todo := patchTestTodo()
+    todo.DeletedAt = nil
+    if err := todo.Patch(map[string]json.RawMessage{"deleted_at": json.RawMessage("null")}); err != "" {
+        t.Errorf("Patch of deleted_at null = %q, want no error", err)
+    }
+    if err := todo.Patch(map[string]json.RawMessage{"deleted_at": json.RawMessage(`"2023-04-01T12:00:00Z"`)}); err == "" {
+        t.Error("Patch of deleted_at on a todo that isn't deleted was accepted")
+    }
+}
+
+func TestStateOnlyPatch(t *testing.T) {
+    tests := []struct {
+        patch   string
+        want    bool
+    }{
+        {`{"state": 3}`, true},
+        {`{"id": 7, "version": 5, "state": 3}`, true},
+        {`{"state": 3, "owner_id": 1, "board_id": 2, "rank": "i", "deleted_at": null}`, true},
+        {`{"state": 3, "comment_count": 0, "blocked": false, "blocked_by": [], "assignees": []}`, true},
+        {`{"state": 3, "name": "Other"}`, false},
+        {`{"tag_ids": []}`, false},
+    }
+    for _, test := range tests {
+        var patch map[string]json.RawMessage
+        if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+            t.Fatal(err)
+        }
+        if got := StateOnlyPatch(patch); got != test.want {
+            t.Errorf("StateOnlyPatch(%s) = %v, want %v", test.patch, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+    // Standard library
+    "sync"
+)
+
+// A subscription to the changes made to todos. C receives a value whenever
+// changes may have been recorded since it last did, which can then be read with
+// ListTodoEventsSince.
+type TodoSubscription struct {
+    C       chan struct{}
+}
+
+var (
+    // All current subscriptions
+    todoSubscriptions = make(map[*TodoSubscription]bool)
+    todoSubscriptionsLock sync.Mutex
+)
+
+// Start listening for changes to todos
+func SubscribeTodoEvents() *TodoSubscription {
+    sub := &TodoSubscription{
+        // A single pending value is enough to wake up the subscriber
+        C:      make(chan struct{}, 1),
+    }
+
+    todoSubscriptionsLock.Lock()
+    todoSubscriptions[sub] = true
+    todoSubscriptionsLock.Unlock()
+
+    return sub
+}
+
+// Stop listening for changes to todos
+func (sub *TodoSubscription) Close() {
+    todoSubscriptionsLock.Lock()
+    delete(todoSubscriptions, sub)
+    todoSubscriptionsLock.Unlock()
+}
+
+// Let all subscribers know that changes may have been recorded. Called once the
+// changes are committed, so that subscribers can read them right away.
+func publishTodoEvents() {
+    todoSubscriptionsLock.Lock()
+    defer todoSubscriptionsLock.Unlock()
+
+    for sub := range todoSubscriptions {
+        // Subscribers that haven't caught up yet already have one pending
+        select {
+        case sub.C <- struct{}{}:
+        default:
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+    // Standard library
+    "strconv"
+    "strings"
+)
+
+// Column expression reading the tags of a todo in order, as a comma-separated
+// list. Used in place of a column when selecting from todos.
+const todoTagsColumn = "(SELECT IFNULL(group_concat(tag_id), '') FROM (SELECT tag_id FROM todo_tags WHERE todo_id = todos.id ORDER BY position))"
+
+// Parse the comma-separated list read by todoTagsColumn
+func parseTagIds(list string) []int {
+    r := []int{}
+    for _, s := range strings.Split(list, ",") {
+        id, err := strconv.Atoi(s)
+        if err == nil {
+            r = append(r, id)
+        }
+    }
+    return r
+}
+
+// Fill in the tags of a todo from whichever of tag_ids or tag_id was given, so
+// that TagIds holds the tags in order and TagId is the first of them.
+func (todo *Todo) NormalizeTags() {
+    // Older clients only know about a single tag
+    if todo.TagIds == nil && todo.TagId > 0 {
+        todo.TagIds = []int{todo.TagId}
+    }
+
+    // Drop duplicates, keeping the first occurrence
+    seen := make(map[int]bool)
+    tagIds := []int{}
+    for _, id := range todo.TagIds {
+        if !seen[id] {
+            seen[id] = true
+            tagIds = append(tagIds, id)
+        }
+    }
+    todo.TagIds = tagIds
+
+    // Compatibility alias
+    if len(todo.TagIds) > 0 {
+        todo.TagId = todo.TagIds[0]
+    } else {
+        todo.TagId = 0
+    }
+}
+
+// Check whether a todo has a tag
+func (todo *Todo) HasTag(tag_id int) bool {
+    for _, id := range todo.TagIds {
+        if id == tag_id {
+            return true
+        }
+    }
+    return false
+}
+
+// Replace the tags of a todo as part of a transaction
+func writeTodoTags(tx dbHandle, todo_id int, tag_ids []int) error {
+    _, err := tx.Exec("DELETE FROM todo_tags WHERE todo_id = ?", todo_id)
+    if err != nil {
+        return err
+    }
+
+    // prepare insert statement
+    stmt, err := tx.Prepare("INSERT INTO todo_tags(todo_id, tag_id, position) values(?,?,?)")
+    if err != nil {
+        return err
+    }
+    defer stmt.Close()
+
+    // Insert the tags in order
+    for i, tag_id := range tag_ids {
+        _, err = stmt.Exec(todo_id, tag_id, i)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+    // Standard library
+    "strings"
+    "testing"
+)
+
+func TestTodoValidate(t *testing.T) {
+    tests := []struct {
+        name    string
+        change  func(todo *Todo)
+        want    string
+    }{
+        {"valid", func(todo *Todo) {}, ""},
+        {"no name", func(todo *Todo) { todo.Name = "" }, "Name must be 1 to 256 characters"},
+        {"longest name", func(todo *Todo) { todo.Name = strings.Repeat("é", MaxTodoName) }, ""},
+        {"name too long", func(todo *Todo) { todo.Name = strings.Repeat("é", MaxTodoName + 1) }, "Name must be 1 to 256 characters"},
+        {"no state", func(todo *Todo) { todo.State = 0 }, "Invalid state"},
+        {"done", func(todo *Todo) { todo.State = StateDone }, ""},
+        {"unknown state", func(todo *Todo) { todo.State = StateDone + 1 }, "Invalid state"},
+        {"unknown priority", func(todo *Todo) { todo.Priority = "P4" }, "Invalid priority"},
+        {"negative estimate", func(todo *Todo) { todo.Estimate = -1 }, "Invalid estimate"},
+    }
+    for _, test := range tests {
+        todo := Todo{
+            Name:       "Write tests",
+            State:      StateIdeas,
+            Priority:   "P2",
+            Estimate:   30,
+        }
+        test.change(&todo)
+        if got := todo.Validate(); got != test.want {
+            t.Errorf("%s: Validate() = %q, want %q", test.name, got, test.want)
+        }
+    }
+}
@@ -17,6 +17,8 @@ type (
         Type    int     `json:"type"`
         Value   string  `json:"value"`
         OwnerId int     `json:"owner_id"`
+        // Board the token is limited to, 0 if it isn't
+        BoardId int     `json:"board_id"`
     }
 )
 
@@ -31,7 +33,7 @@ func (token *Token) InsertValues() bool {
     conn := database.GetConnection()
 
     // prepare insert statement
-    stmt, err := conn.Prepare("INSERT INTO tokens(type, value, owner_id) values(?,?,?)")
+    stmt, err := conn.Prepare("INSERT INTO tokens(type, value, owner_id, board_id) values(?,?,?,?)")
 
     if err != nil {
         log.Printf("Warning: Failed to write to database: %s", err)
@@ -40,7 +42,7 @@ func (token *Token) InsertValues() bool {
     defer stmt.Close()
 
     // Execute insert statement
-    _, err = stmt.Exec(token.Type, token.Value, token.OwnerId)
+    _, err = stmt.Exec(token.Type, token.Value, token.OwnerId, token.BoardId)
     if err != nil {
         log.Printf("Warning: Failed to write to database: %s", err)
         return false
@@ -62,7 +64,7 @@ func (token *Token) ReadValues() bool {
     conn := database.GetConnection()
 
     // prepare read statement
-    stmt, err := conn.Prepare("SELECT * FROM tokens WHERE value = ?")
+    stmt, err := conn.Prepare("SELECT id, type, value, owner_id, board_id FROM tokens WHERE value = ?")
     if err != nil {
         log.Printf("Warning: Failed to read database: %s", err)
         return false
@@ -79,7 +81,7 @@ func (token *Token) ReadValues() bool {
 
     // Check results
     for res.Next() {
-        err = res.Scan(&token.Id, &token.Type, &token.Value, &token.OwnerId)
+        err = res.Scan(&token.Id, &token.Type, &token.Value, &token.OwnerId, &token.BoardId)
         if err != nil {
             log.Printf("Warning: Failed to read database: %s", err)
             return false
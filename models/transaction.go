@@ -0,0 +1,57 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "errors"
+    "log"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// Anything that statements can be run on, either the connection itself or a
+// transaction on it
+type dbHandle interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Prepare(query string) (*sql.Stmt, error)
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var (
+    // The todo was changed since the version being written was read
+    errConflict = errors.New("todo was changed in the meantime")
+    // The todo isn't there (anymore), or not in the expected place
+    errNotFound = errors.New("todo not found")
+)
+
+// Run fn in a transaction of its own, which is committed if fn doesn't return
+// an error. Returns true on success, false on error.
+func inTransaction(fn func(tx *sql.Tx) error) bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    tx, err := conn.Begin()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    defer tx.Rollback()
+
+    err = fn(tx)
+    if err == nil {
+        err = tx.Commit()
+    }
+    if err != nil {
+        // Conflicts and missing todos are not the database's fault
+        if err != errConflict && err != errNotFound {
+            log.Printf("Warning: Failed to write to database: %s", err)
+        }
+        return false
+    }
+
+    // Changes to todos may have been recorded
+    publishTodoEvents()
+    return true
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "log"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// How often the trash is checked for todos that have been in there too long
+const trashPurgeInterval = time.Hour
+
+// Take a todo out of the trash, back into its state column. Returns true on
+// success, false on error.
+func (todo *Todo) RestoreFromTrash() bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 {
+        return false
+    }
+
+    return inTransaction(func(tx *sql.Tx) error {
+        return todo.restoreFromTrash(tx)
+    })
+}
+
+// Take a todo out of the trash and record it in the history
+func (todo *Todo) restoreFromTrash(db dbHandle) error {
+    // Keep the trashed version around for the history
+    before := Todo{
+        Id:     todo.Id,
+    }
+    if !before.readValues(db) || before.DeletedAt == nil {
+        return errNotFound
+    }
+    after := before
+    after.Editor = todo.Editor
+    after.DeletedAt = nil
+    after.Version++
+
+    // Execute update statement
+    _, err := db.Exec("UPDATE todos SET deleted_at = NULL, version = ? WHERE id = ?", after.Version, todo.Id)
+    if err != nil {
+        return err
+    }
+    err = recordTodoEvent(db, EventRestore, &before, &after)
+    if err != nil {
+        return err
+    }
+
+    *todo = after
+    return nil
+}
+
+// Remove a todo in the trash from the database for good, along with its
+// history, comments and attachments. Only a purge event is kept, to remember
+// who owned it. Returns true on success.
+func (todo *Todo) Purge() bool {
+    // Check that there is an input Id
+    if todo.Id <= 0 {
+        return false
+    }
+
+    // The content of attachments can only go once the rest is gone
+    var keys []string
+    for _, attachment := range ListAttachments(todo.Id) {
+        keys = append(keys, attachment.StorageKey)
+    }
+
+    ok := inTransaction(func(tx *sql.Tx) error {
+        return todo.purge(tx)
+    })
+    if ok {
+        deleteBlobs(keys)
+    }
+    return ok
+}
+
+// Remove a todo in the trash and everything about it, leaving a tombstone
+func (todo *Todo) purge(db dbHandle) error {
+    // Only todos in the trash can be purged
+    before := Todo{
+        Id:     todo.Id,
+    }
+    if !before.readValues(db) || before.DeletedAt == nil {
+        return errNotFound
+    }
+    tombstone := Todo{
+        Id:         before.Id,
+        OwnerId:    before.OwnerId,
+        BoardId:    before.BoardId,
+        DeletedAt:  before.DeletedAt,
+        Editor:     todo.Editor,
+    }
+
+    // Execute delete statements
+    _, err := db.Exec("DELETE FROM todo_tags WHERE todo_id = ?", todo.Id)
+    if err == nil {
+        _, err = db.Exec("DELETE FROM todo_events WHERE todo_id = ?", todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM comments WHERE todo_id = ?", todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM attachments WHERE todo_id = ?", todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM todo_dependencies WHERE todo_id = ? OR blocker_id = ?", todo.Id, todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM todo_assignees WHERE todo_id = ?", todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM todo_shares WHERE todo_id = ?", todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM share_links WHERE todo_id = ?", todo.Id)
+    }
+    if err == nil {
+        _, err = db.Exec("DELETE FROM todos WHERE id = ?", todo.Id)
+    }
+    if err != nil {
+        return err
+    }
+
+    // Nothing changes in the tombstone itself
+    return recordTodoEvent(db, EventPurge, &tombstone, &tombstone)
+}
+
+// List the todos in the trash that owner_id owns, most recently removed first.
+// Those on boards are the ones owner_id created and can still change, and all
+// of them on the boards owner_id is an admin of.
+func ListDeletedTodos(owner_id int) []Todo {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare read statement
+    stmt, err := conn.Prepare("SELECT id,state,owner_id,board_id,name,duedate,rrule,priority,estimate,rank,deleted_at,version," + todoTagsColumn + " FROM todos WHERE " + todoOwnedCondition + " AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer stmt.Close()
+
+    // Execute read statement
+    res, err := stmt.Query(owner_id, owner_id, owner_id)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Create new slice for storing output
+    var r []Todo
+
+    // Check results
+    for res.Next() {
+        // Read in the values from the database
+        var todo Todo
+        var tagIds string
+        err = res.Scan(&todo.Id, &todo.State, &todo.OwnerId, &todo.BoardId, &todo.Name, &todo.DueDate, &todo.RRule, &todo.Priority, &todo.Estimate, &todo.Rank, &todo.DeletedAt, &todo.Version, &tagIds)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        todo.TagIds = parseTagIds(tagIds)
+        todo.NormalizeTags()
+
+        // No errors, append to slice
+        r = append(r, todo)
+    }
+
+    // Done
+    return r
+}
+
+// Purge all todos that were moved to the trash before the given time. Returns
+// the number of todos purged.
+func PurgeDeletedBefore(before time.Time) int {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // prepare read statement
+    stmt, err := conn.Prepare("SELECT id FROM todos WHERE deleted_at IS NOT NULL AND deleted_at < ?")
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return 0
+    }
+    defer stmt.Close()
+
+    // Execute read statement
+    res, err := stmt.Query(before.UTC())
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return 0
+    }
+
+    // Collect the ids first, purging writes to the same table
+    var ids []int
+    for res.Next() {
+        var id int
+        err = res.Scan(&id)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            break
+        }
+        ids = append(ids, id)
+    }
+    res.Close()
+
+    // Purge them one by one, by the server
+    purged := 0
+    for _, id := range ids {
+        todo := Todo{
+            Id:     id,
+        }
+        if todo.Purge() {
+            purged++
+        }
+    }
+
+    return purged
+}
+
+// Keep purging todos that have been in the trash for longer than age. Meant to
+// be run in the background for as long as the server runs.
+func PurgeTrashForever(age time.Duration) {
+    for {
+        if purged := PurgeDeletedBefore(time.Now().Add(-age)); purged > 0 {
+            log.Printf("Info: Purged %d todos from the trash", purged)
+        }
+        time.Sleep(trashPurgeInterval)
+    }
+}
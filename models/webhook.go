@@ -0,0 +1,333 @@
+package models
+
+import (
+    // Standard library
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "log"
+    "net"
+    "net/url"
+    "strings"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent an address that is told about changes to the todos of its
+    // owner as they happen
+    Webhook struct {
+        Id          int         `json:"id"`
+        OwnerId     int         `json:"owner_id"`
+        // Board the webhook is limited to, 0 if it isn't
+        BoardId     int         `json:"board_id"`
+        Url         string      `json:"url"`
+        // Kinds of changes to deliver, all of them if empty
+        Events      []string    `json:"events"`
+        // Only deliver changes to todos with this tag or one nested under it,
+        // 0 for all todos
+        TagId       int         `json:"tag_id"`
+        // Only given when creating or changing a webhook. Payloads are signed
+        // with it.
+        Secret      string      `json:"secret,omitempty"`
+        Active      bool        `json:"active"`
+        Created     time.Time   `json:"created"`
+        // Last change to todos the webhook was considered for
+        LastEventId int         `json:"-"`
+        secret      string
+    }
+)
+
+// Longest URL a webhook may have
+const maxWebhookUrl = 2048
+
+// Number of random bytes in a secret made up by the server
+const webhookSecretBytes = 24
+
+// Whether webhooks may be sent to private, loopback and link-local addresses,
+// such as services running next to the server
+var WebhookAllowPrivate = false
+
+// Check whether webhooks may be sent to an address. Addresses inside the
+// network of the server are refused unless WebhookAllowPrivate is set, so that
+// webhooks can't be used to reach services that aren't meant to be reachable
+// from the outside.
+func WebhookAddressAllowed(ip net.IP) bool {
+    if WebhookAllowPrivate {
+        return true
+    }
+    return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+        !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Check that a webhook can be saved. Returns "" if it can, or a friendly error
+// message.
+func (hook *Webhook) Validate() string {
+    hook.Url = strings.TrimSpace(hook.Url)
+    u, err := url.Parse(hook.Url)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+        return "Webhook URL must be an http or https address"
+    }
+    if len(hook.Url) > maxWebhookUrl {
+        return "Webhook URL is too long"
+    }
+
+    // Every address the host resolves to has to be allowed. This is checked
+    // again for every delivery, as the host may resolve to others later.
+    ips := []net.IP{net.ParseIP(u.Hostname())}
+    if ips[0] == nil {
+        ips, err = net.LookupIP(u.Hostname())
+        if err != nil || len(ips) == 0 {
+            return "Webhook URL host could not be found"
+        }
+    }
+    for _, ip := range ips {
+        if !WebhookAddressAllowed(ip) {
+            return "Webhook URL must not point to a private address"
+        }
+    }
+    return ""
+}
+
+// Inserts a new webhook, which is told about the changes made from now on. A
+// secret is made up if none is given. Returns true on success, false on error.
+func (hook *Webhook) InsertValues() bool {
+    // Check that there is no input Id
+    if hook.Id > 0 || hook.OwnerId <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    if len(hook.Secret) == 0 {
+        b := make([]byte, webhookSecretBytes)
+        rand.Read(b)
+        hook.Secret = base64.RawURLEncoding.EncodeToString(b)
+    }
+    hook.secret = hook.Secret
+    hook.LastEventId = LatestTodoEventId()
+    hook.Created = time.Now().UTC()
+
+    // Execute insert statement
+    res, err := conn.Exec("INSERT INTO webhooks(owner_id, board_id, url, events, tag_id, secret, active, last_event_id, created) values(?,?,?,?,?,?,?,?,?)",
+        hook.OwnerId, hook.BoardId, hook.Url, strings.Join(hook.Events, ","), hook.TagId, hook.secret, hook.Active, hook.LastEventId, hook.Created)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // Find out the new id
+    id, err := res.LastInsertId()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    hook.Id = int(id)
+
+    // No error
+    return true
+}
+
+// Updates a webhook based on Id, keeping its secret unless a new one is given.
+// A webhook that is turned back on is only told about the changes made from
+// then on. Returns true on success, false on error.
+func (hook *Webhook) WriteValues() bool {
+    // Check that there is an input Id
+    if hook.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    if len(hook.Secret) != 0 {
+        hook.secret = hook.Secret
+    }
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE webhooks SET url = ?, events = ?, tag_id = ?, secret = ?, last_event_id = CASE WHEN active = 0 AND ? THEN ? ELSE last_event_id END, active = ? WHERE id = ?",
+        hook.Url, strings.Join(hook.Events, ","), hook.TagId, hook.secret, hook.Active, LatestTodoEventId(), hook.Active, hook.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// The columns of webhooks read into a webhook by scan
+const webhookColumns = "id, owner_id, board_id, url, events, tag_id, secret, active, last_event_id, created"
+
+// Read in a webhook from a row of webhookColumns
+func (hook *Webhook) scan(row interface{ Scan(dest ...interface{}) error }) error {
+    var events string
+    err := row.Scan(&hook.Id, &hook.OwnerId, &hook.BoardId, &hook.Url, &events, &hook.TagId, &hook.secret, &hook.Active, &hook.LastEventId, &hook.Created)
+    if err != nil {
+        return err
+    }
+    hook.Events = []string{}
+    if len(events) != 0 {
+        hook.Events = strings.Split(events, ",")
+    }
+    hook.Secret = ""
+    return nil
+}
+
+// Read in the values of a webhook based on Id. Returns true if values were
+// read.
+func (hook *Webhook) ReadValues() bool {
+    // Check that there is an input Id
+    if hook.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := hook.scan(conn.QueryRow("SELECT " + webhookColumns + " FROM webhooks WHERE id = ?", hook.Id))
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    return true
+}
+
+// Remove a webhook based on Id, along with its deliveries. Returns true on
+// success.
+func (hook *Webhook) Remove() bool {
+    // Check that there is an input Id
+    if hook.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    tx, err := conn.Begin()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    defer tx.Rollback()
+
+    // Execute delete statements
+    _, err = tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", hook.Id)
+    if err == nil {
+        _, err = tx.Exec("DELETE FROM webhooks WHERE id = ?", hook.Id)
+    }
+    if err == nil {
+        err = tx.Commit()
+    }
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Check whether a webhook wants to be told about a kind of change
+func (hook *Webhook) Wants(event string) bool {
+    if len(hook.Events) == 0 {
+        return true
+    }
+    for _, e := range hook.Events {
+        if e == event {
+            return true
+        }
+    }
+    return false
+}
+
+// Sign a payload with the secret of a webhook, as the hex HMAC-SHA256 of it
+// prefixed with "sha256="
+func (hook *Webhook) Sign(payload []byte) string {
+    mac := hmac.New(sha256.New, []byte(hook.secret))
+    mac.Write(payload)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Queue up deliveries for a webhook, and remember that the changes up to
+// last_event_id were considered for it. Returns true on success.
+func (hook *Webhook) Enqueue(deliveries []WebhookDelivery, last_event_id int) bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    tx, err := conn.Begin()
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+    defer tx.Rollback()
+
+    now := time.Now().UTC()
+    for i := range deliveries {
+        err = deliveries[i].insert(tx, now)
+        if err != nil {
+            break
+        }
+    }
+    if err == nil {
+        _, err = tx.Exec("UPDATE webhooks SET last_event_id = ? WHERE id = ?", last_event_id, hook.Id)
+    }
+    if err == nil {
+        err = tx.Commit()
+    }
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    hook.LastEventId = last_event_id
+    return true
+}
+
+// List the webhooks of owner_id, oldest first
+func ListWebhooks(owner_id int) []Webhook {
+    return listWebhooks("owner_id = ? ORDER BY id", owner_id)
+}
+
+// List all webhooks that are active
+func ListActiveWebhooks() []Webhook {
+    return listWebhooks("active = 1 ORDER BY id")
+}
+
+func listWebhooks(condition string, args ...interface{}) []Webhook {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT " + webhookColumns + " FROM webhooks WHERE " + condition, args...)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []Webhook
+    for res.Next() {
+        var hook Webhook
+        err = hook.scan(res)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, hook)
+    }
+
+    // Done
+    return r
+}
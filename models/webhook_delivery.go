@@ -0,0 +1,267 @@
+package models
+
+import (
+    // Standard library
+    "database/sql"
+    "encoding/json"
+    "log"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+type (
+    // Represent the delivery of a change to a todo to a webhook
+    WebhookDelivery struct {
+        Id          int             `json:"id"`
+        WebhookId   int             `json:"webhook_id"`
+        // The change to a todo in its history, and what kind of change it was
+        EventId     int             `json:"event_id"`
+        Event       string          `json:"event"`
+        // What is sent to the webhook
+        Payload     json.RawMessage `json:"payload"`
+        Status      string          `json:"status"`
+        Attempts    int             `json:"attempts"`
+        // When to try again, nil unless the delivery is pending
+        NextAttempt *time.Time      `json:"next_attempt,omitempty"`
+        // HTTP status of the response to the last attempt, 0 if there was none
+        ResponseStatus int          `json:"response_status"`
+        // Why the last attempt failed
+        Error       string          `json:"error,omitempty"`
+        Created     time.Time       `json:"created"`
+        LastAttempt *time.Time      `json:"last_attempt,omitempty"`
+    }
+)
+
+// The states a delivery can be in
+const (
+    DeliveryPending     = "pending"
+    DeliveryDelivered   = "delivered"
+    DeliveryFailed      = "failed"
+)
+
+// How many times a delivery is attempted before giving up on it
+var WebhookMaxAttempts = 8
+
+// How long to wait before attempting a delivery again the first time. The wait
+// doubles with every failed attempt.
+var WebhookRetryDelay = 30 * time.Second
+
+// Insert a new pending delivery, due right away
+func (delivery *WebhookDelivery) insert(db dbHandle, now time.Time) error {
+    delivery.Status = DeliveryPending
+    delivery.Attempts = 0
+    delivery.NextAttempt = &now
+    delivery.ResponseStatus = 0
+    delivery.Error = ""
+    delivery.Created = now
+    delivery.LastAttempt = nil
+
+    // Execute insert statement
+    res, err := db.Exec("INSERT INTO webhook_deliveries(webhook_id, event_id, event, payload, status, attempts, next_attempt, created) values(?,?,?,?,?,?,?,?)",
+        delivery.WebhookId, delivery.EventId, delivery.Event, string(delivery.Payload), delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.Created)
+    if err != nil {
+        return err
+    }
+
+    // Find out the new id
+    id, err := res.LastInsertId()
+    if err != nil {
+        return err
+    }
+    delivery.Id = int(id)
+    return nil
+}
+
+// Queue up the delivery again as a new one, which the delivery then becomes.
+// Returns true on success, false on error.
+func (delivery *WebhookDelivery) Redeliver() bool {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    delivery.Id = 0
+    err := delivery.insert(conn, time.Now().UTC())
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Record an attempt at a delivery, which succeeded if the webhook responded
+// with a 2xx status. Failed deliveries are tried again later, waiting longer
+// every time, until WebhookMaxAttempts is reached. Returns true on success,
+// false on error.
+func (delivery *WebhookDelivery) Attempted(status int, message string) bool {
+    // Check that there is an input Id
+    if delivery.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    now := time.Now().UTC()
+    delivery.Attempts++
+    delivery.LastAttempt = &now
+    delivery.ResponseStatus = status
+    delivery.Error = message
+    delivery.NextAttempt = nil
+    switch {
+    case status >= 200 && status < 300:
+        delivery.Status = DeliveryDelivered
+        delivery.Error = ""
+    case delivery.Attempts >= WebhookMaxAttempts:
+        delivery.Status = DeliveryFailed
+    default:
+        next := now.Add(WebhookRetryDelay << uint(delivery.Attempts - 1))
+        delivery.Status = DeliveryPending
+        delivery.NextAttempt = &next
+    }
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, response_status = ?, error = ?, last_attempt = ? WHERE id = ?",
+        delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.ResponseStatus, delivery.Error, delivery.LastAttempt, delivery.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// Give up on a delivery without attempting it again. Returns true on success,
+// false on error.
+func (delivery *WebhookDelivery) Abandon(message string) bool {
+    // Check that there is an input Id
+    if delivery.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    delivery.Status = DeliveryFailed
+    delivery.NextAttempt = nil
+    delivery.Error = message
+
+    // Execute update statement
+    _, err := conn.Exec("UPDATE webhook_deliveries SET status = ?, next_attempt = ?, error = ? WHERE id = ?",
+        delivery.Status, delivery.NextAttempt, delivery.Error, delivery.Id)
+    if err != nil {
+        log.Printf("Warning: Failed to write to database: %s", err)
+        return false
+    }
+
+    // No error
+    return true
+}
+
+// The columns of webhook_deliveries read into a delivery by scan
+const webhookDeliveryColumns = "id, webhook_id, event_id, event, payload, status, attempts, next_attempt, response_status, error, created, last_attempt"
+
+// Read in a delivery from a row of webhookDeliveryColumns
+func (delivery *WebhookDelivery) scan(row interface{ Scan(dest ...interface{}) error }) error {
+    var payload string
+    err := row.Scan(&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttempt, &delivery.ResponseStatus, &delivery.Error, &delivery.Created, &delivery.LastAttempt)
+    if err != nil {
+        return err
+    }
+    delivery.Payload = json.RawMessage(payload)
+    return nil
+}
+
+// Read in the values of a delivery based on Id. Returns true if values were
+// read.
+func (delivery *WebhookDelivery) ReadValues() bool {
+    // Check that there is an input Id
+    if delivery.Id <= 0 {
+        return false
+    }
+
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Only care about the 1st result
+    err := delivery.scan(conn.QueryRow("SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE id = ?", delivery.Id))
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Warning: Failed to read database: %s", err)
+        }
+        return false
+    }
+
+    return true
+}
+
+// List the latest deliveries to a webhook, newest first, at most limit of them
+func ListWebhookDeliveries(webhook_id int, limit int) []WebhookDelivery {
+    return listWebhookDeliveries("webhook_id = ? ORDER BY id DESC LIMIT ?", webhook_id, limit)
+}
+
+// List the pending deliveries to a webhook that are due by now, oldest first,
+// at most limit of them
+func ListDueWebhookDeliveries(webhook_id int, now time.Time, limit int) []WebhookDelivery {
+    return listWebhookDeliveries("webhook_id = ? AND status = ? AND next_attempt <= ? ORDER BY id LIMIT ?", webhook_id, DeliveryPending, now.UTC(), limit)
+}
+
+// List the ids of the webhooks with pending deliveries that are due by now
+func ListDueWebhooks(now time.Time) []int {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT DISTINCT webhook_id FROM webhook_deliveries WHERE status = ? AND next_attempt <= ? ORDER BY webhook_id", DeliveryPending, now.UTC())
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []int
+    for res.Next() {
+        var id int
+        err = res.Scan(&id)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, id)
+    }
+
+    // Done
+    return r
+}
+
+func listWebhookDeliveries(condition string, args ...interface{}) []WebhookDelivery {
+    // Get connection handle
+    conn := database.GetConnection()
+
+    // Execute read statement
+    res, err := conn.Query("SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE " + condition, args...)
+    if err != nil {
+        log.Printf("Warning: Failed to read database: %s", err)
+        return nil
+    }
+    defer res.Close()
+
+    // Check results
+    var r []WebhookDelivery
+    for res.Next() {
+        var delivery WebhookDelivery
+        err = delivery.scan(res)
+        if err != nil {
+            log.Printf("Warning: Failed to read database: %s", err)
+            return nil
+        }
+        r = append(r, delivery)
+    }
+
+    // Done
+    return r
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+    // Standard library
+    "testing"
+    "time"
+
+    // Own stuff
+    "github.com/ohnx/gotodo/database"
+)
+
+// Start a test with a pending delivery
+func testWebhookDelivery(t *testing.T) *WebhookDelivery {
+    t.Helper()
+    testDatabase(t)
+
+    delivery := WebhookDelivery{
+        WebhookId:  1,
+        EventId:    1,
+        Event:      "created",
+        Payload:    []byte("{}"),
+    }
+    err := delivery.insert(database.GetConnection(), time.Now().UTC())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &delivery
+}
+
+// Failed deliveries wait twice as long every time, until they are given up on
+func TestWebhookDeliveryAttemptedBackoff(t *testing.T) {
+    delivery := testWebhookDelivery(t)
+
+    tests := []struct {
+        status  int
+        wait    time.Duration
+    }{
+        {500, 30 * time.Second},
+        {0, time.Minute},
+        {404, 2 * time.Minute},
+        {302, 4 * time.Minute},
+        {500, 8 * time.Minute},
+        {500, 16 * time.Minute},
+        {500, 32 * time.Minute},
+        // Given up on
+        {500, 0},
+    }
+    for i, test := range tests {
+        if !delivery.Attempted(test.status, "failed") {
+            t.Fatal("failed to record attempt")
+        }
+
+        stored := WebhookDelivery{
+            Id:         delivery.Id,
+        }
+        if !stored.ReadValues() {
+            t.Fatal("failed to read delivery")
+        }
+        for _, d := range []*WebhookDelivery{delivery, &stored} {
+            if d.Attempts != i + 1 || d.ResponseStatus != test.status || d.Error != "failed" || d.LastAttempt == nil {
+                t.Fatalf("attempt %d recorded as %+v", i + 1, d)
+            }
+            if test.wait == 0 {
+                if d.Status != DeliveryFailed || d.NextAttempt != nil {
+                    t.Errorf("attempt %d: status %s, next attempt %v, want it given up on", i + 1, d.Status, d.NextAttempt)
+                }
+                continue
+            }
+            if d.Status != DeliveryPending || d.NextAttempt == nil {
+                t.Fatalf("attempt %d: status %s, next attempt %v, want pending", i + 1, d.Status, d.NextAttempt)
+            }
+            if wait := d.NextAttempt.Sub(*d.LastAttempt); wait != test.wait {
+                t.Errorf("attempt %d: waits %s, want %s", i + 1, wait, test.wait)
+            }
+        }
+    }
+}
+
+func TestWebhookDeliveryAttemptedDelivered(t *testing.T) {
+    delivery := testWebhookDelivery(t)
+
+    if !delivery.Attempted(500, "failed") || !delivery.Attempted(204, "") {
+        t.Fatal("failed to record attempts")
+    }
+    stored := WebhookDelivery{
+        Id:         delivery.Id,
+    }
+    if !stored.ReadValues() {
+        t.Fatal("failed to read delivery")
+    }
+    if stored.Status != DeliveryDelivered || stored.Attempts != 2 || stored.NextAttempt != nil || stored.Error != "" || stored.ResponseStatus != 204 {
+        t.Errorf("delivered attempt recorded as %+v", stored)
+    }
+
+    // Nothing left to deliver
+    if due := ListDueWebhooks(time.Now().Add(time.Hour)); len(due) != 0 {
+        t.Errorf("webhooks %v still have deliveries due", due)
+    }
+}
+
+// Deliveries that aren't due yet are left alone
+func TestListDueWebhookDeliveries(t *testing.T) {
+    delivery := testWebhookDelivery(t)
+
+    now := time.Now()
+    due := ListDueWebhookDeliveries(1, now, 10)
+    if len(due) != 1 || due[0].Id != delivery.Id {
+        t.Fatalf("due deliveries %+v, want delivery %d", due, delivery.Id)
+    }
+    if !delivery.Attempted(500, "failed") {
+        t.Fatal("failed to record attempt")
+    }
+    if due := ListDueWebhookDeliveries(1, now, 10); len(due) != 0 {
+        t.Errorf("due deliveries %+v right after a failed attempt", due)
+    }
+    if due := ListDueWebhooks(now.Add(time.Minute)); len(due) != 1 || due[0] != 1 {
+        t.Errorf("webhooks %v due a minute later, want [1]", due)
+    }
+}
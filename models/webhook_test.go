@@ -0,0 +1,142 @@
+package models
+
+import (
+    // Standard library
+    "net"
+    "strings"
+    "testing"
+)
+
+func TestWebhookSign(t *testing.T) {
+    tests := []struct {
+        secret  string
+        payload string
+        want    string
+    }{
+        {"", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+        {"key", "The quick brown fox jumps over the lazy dog", "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+    }
+    for _, test := range tests {
+        hook := Webhook{
+            secret:     test.secret,
+        }
+        if got := hook.Sign([]byte(test.payload)); got != test.want {
+            t.Errorf("Sign(%q) with secret %q = %s, want %s", test.payload, test.secret, got, test.want)
+        }
+    }
+}
+
+// Deliveries are signed with the secret given to the webhook, or the one the
+// server made up for it, not with the secret sent back to clients
+func TestWebhookSignSecret(t *testing.T) {
+    testDatabase(t)
+
+    hook := Webhook{
+        OwnerId:    1,
+        Url:        "https://example.com/hook",
+        Secret:     "key",
+        Active:     true,
+    }
+    if !hook.InsertValues() {
+        t.Fatal("failed to insert webhook")
+    }
+    stored := Webhook{
+        Id:         hook.Id,
+    }
+    if !stored.ReadValues() {
+        t.Fatal("failed to read webhook")
+    }
+    payload := []byte("The quick brown fox jumps over the lazy dog")
+    want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+    if got := stored.Sign(payload); got != want {
+        t.Errorf("Sign with stored secret = %s, want %s", got, want)
+    }
+
+    // A secret made up by the server
+    hook.Secret = ""
+    hook.Id = 0
+    if !hook.InsertValues() || len(hook.Secret) == 0 {
+        t.Fatal("failed to insert webhook with a secret made up by the server")
+    }
+    stored = Webhook{
+        Id:         hook.Id,
+    }
+    if !stored.ReadValues() {
+        t.Fatal("failed to read webhook")
+    }
+    made := Webhook{
+        secret:     hook.Secret,
+    }
+    if stored.Sign(payload) != made.Sign(payload) {
+        t.Error("deliveries aren't signed with the secret made up by the server")
+    }
+}
+
+func TestWebhookAddressAllowed(t *testing.T) {
+    tests := []struct {
+        ip      string
+        want    bool
+    }{
+        {"93.184.216.34", true},
+        {"8.8.8.8", true},
+        {"2606:4700::1111", true},
+        {"127.0.0.1", false},
+        {"127.1.2.3", false},
+        {"::1", false},
+        {"10.0.0.1", false},
+        {"172.16.5.4", false},
+        {"192.168.1.1", false},
+        {"169.254.169.254", false},
+        {"fe80::1", false},
+        {"fd00::1", false},
+        {"0.0.0.0", false},
+        {"::", false},
+        {"224.0.0.1", false},
+        {"::ffff:127.0.0.1", false},
+        {"::ffff:10.0.0.1", false},
+    }
+    for _, test := range tests {
+        if got := WebhookAddressAllowed(net.ParseIP(test.ip)); got != test.want {
+            t.Errorf("WebhookAddressAllowed(%s) = %v, want %v", test.ip, got, test.want)
+        }
+    }
+}
+
+func TestWebhookValidate(t *testing.T) {
+    tests := []struct {
+        url     string
+        want    string
+    }{
+        {"https://93.184.216.34/hook", ""},
+        {" http://93.184.216.34:8080/hook?a=b ", ""},
+        {"ftp://93.184.216.34/hook", "Webhook URL must be an http or https address"},
+        {"http:///hook", "Webhook URL must be an http or https address"},
+        {"https://93.184.216.34/" + strings.Repeat("a", maxWebhookUrl), "Webhook URL is too long"},
+        {"http://127.0.0.1/hook", "Webhook URL must not point to a private address"},
+        {"http://[::1]:8080/hook", "Webhook URL must not point to a private address"},
+        {"http://169.254.169.254/latest/meta-data", "Webhook URL must not point to a private address"},
+        {"http://192.168.0.10/hook", "Webhook URL must not point to a private address"},
+        {"http://localhost/hook", "Webhook URL must not point to a private address"},
+        {"http://host.invalid/hook", "Webhook URL host could not be found"},
+    }
+    for _, test := range tests {
+        hook := Webhook{
+            Url:    test.url,
+        }
+        if got := hook.Validate(); got != test.want {
+            t.Errorf("Validate() of %q = %q, want %q", test.url, got, test.want)
+        }
+    }
+
+    // Unless the server allows it
+    WebhookAllowPrivate = true
+    defer func() {
+        WebhookAllowPrivate = false
+    }()
+    hook := Webhook{
+        Url:    "http://127.0.0.1/hook",
+    }
+    if got := hook.Validate(); got != "" {
+        t.Errorf("Validate() of a private address that is allowed = %q", got)
+    }
+}
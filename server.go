@@ -2,9 +2,16 @@ package main
 
 import (
     // standard library
+    "crypto/tls"
     "net/http"
     "log"
     "os"
+    "strconv"
+    "strings"
+    "time"
+
+    // time zone database, for recurring todos on systems without one
+    _ "time/tzdata"
 
     // http router from julienschmidt
     "github.com/julienschmidt/httprouter"
@@ -12,9 +19,14 @@ import (
     // cors
     "github.com/rs/cors"
 
+    // SMTP server, for mail that becomes todos
+    "github.com/emersion/go-smtp"
+
     // own stuff
     "github.com/ohnx/gotodo/endpoints"
     "github.com/ohnx/gotodo/database"
+    "github.com/ohnx/gotodo/models"
+    "github.com/ohnx/gotodo/storage"
 )
 
 func main() {
@@ -27,6 +39,97 @@ func main() {
     database.Connect(filename)
     defer database.Disconnect()
 
+    // Get the number of days removed todos stay in the trash, 0 to keep them
+    trashDays := 30
+    if env := os.Getenv("TRASH_DAYS"); len(env) != 0 {
+        var err error
+        trashDays, err = strconv.Atoi(env)
+        if err != nil || trashDays < 0 {
+            log.Fatalf("Invalid TRASH_DAYS `%s`", env)
+        }
+    }
+    if trashDays > 0 {
+        log.Printf("Server purging todos from the trash after %d days", trashDays)
+        go models.PurgeTrashForever(time.Duration(trashDays) * 24 * time.Hour)
+    }
+
+    // Get the directory attachments are kept in
+    attachmentDir := os.Getenv("ATTACHMENT_DIR")
+    if len(attachmentDir) == 0 {
+        attachmentDir = "attachments"
+    }
+    store, err := storage.NewLocalStore(attachmentDir)
+    if err != nil {
+        log.Fatalf("Failed to open attachment directory: %s", err)
+    }
+    log.Printf("Server keeping attachments in `%s`", attachmentDir)
+    storage.Connect(store)
+
+    // Get the limits on attachments
+    if env := os.Getenv("ATTACHMENT_MAX_MB"); len(env) != 0 {
+        mb, err := strconv.Atoi(env)
+        if err != nil || mb <= 0 {
+            log.Fatalf("Invalid ATTACHMENT_MAX_MB `%s`", env)
+        }
+        models.MaxAttachmentSize = int64(mb) << 20
+    }
+    if env := os.Getenv("ATTACHMENT_TYPES"); len(env) != 0 {
+        models.AttachmentTypes = strings.Split(env, ",")
+    }
+
+    // Get the number of hours idempotency keys are remembered for
+    if env := os.Getenv("IDEMPOTENCY_HOURS"); len(env) != 0 {
+        hours, err := strconv.Atoi(env)
+        if err != nil || hours <= 0 {
+            log.Fatalf("Invalid IDEMPOTENCY_HOURS `%s`", env)
+        }
+        models.IdempotencyKeyLifetime = time.Duration(hours) * time.Hour
+    }
+
+    // Check whether webhooks may be sent to addresses next to the server
+    if env := os.Getenv("WEBHOOK_ALLOW_PRIVATE"); len(env) != 0 {
+        allow, err := strconv.ParseBool(env)
+        if err != nil {
+            log.Fatalf("Invalid WEBHOOK_ALLOW_PRIVATE `%s`", env)
+        }
+        models.WebhookAllowPrivate = allow
+    }
+
+    // Tell webhooks about changes to todos in the background
+    go endpoints.DeliverWebhooksForever()
+
+    // Receive mail that becomes todos, if asked to
+    if smtpAddr := os.Getenv("SMTP_ADDR"); len(smtpAddr) != 0 {
+        endpoints.MailDomain = os.Getenv("SMTP_DOMAIN")
+        if len(endpoints.MailDomain) == 0 {
+            endpoints.MailDomain = "localhost"
+        }
+        s := smtp.NewServer(endpoints.NewMailBackend())
+        s.Addr = smtpAddr
+        s.Domain = endpoints.MailDomain
+        s.MaxMessageBytes = 25 << 20
+        s.MaxRecipients = 10
+        s.ReadTimeout = time.Minute
+        s.WriteTimeout = time.Minute
+        if cert := os.Getenv("SMTP_TLS_CERT"); len(cert) != 0 {
+            pair, err := tls.LoadX509KeyPair(cert, os.Getenv("SMTP_TLS_KEY"))
+            if err != nil {
+                log.Fatalf("Failed to load SMTP certificate: %s", err)
+            }
+            s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+        } else {
+            // Tokens would be sent in the clear, as they are over plain HTTP
+            s.AllowInsecureAuth = true
+        }
+        log.Printf("Server receiving mail for @%s on %s", endpoints.MailDomain, smtpAddr)
+        go func() {
+            err := s.ListenAndServe()
+            if err != nil {
+                log.Fatalf("Failed to listen for mail: %s", err)
+            }
+        }()
+    }
+
     // Create a new router
     r := httprouter.New()
 
@@ -35,17 +138,95 @@ func main() {
     todoEndpoint := endpoints.NewTodoEndpoint()
     todosEndpoint := endpoints.NewTodosEndpoint()
     tagsEndpoint := endpoints.NewTagsEndpoint()
+    tagEndpoint := endpoints.NewTagEndpoint()
+    trashEndpoint := endpoints.NewTrashEndpoint()
+    commentEndpoint := endpoints.NewCommentEndpoint()
+    attachmentEndpoint := endpoints.NewAttachmentEndpoint()
+    dependencyEndpoint := endpoints.NewDependencyEndpoint()
+    boardEndpoint := endpoints.NewBoardEndpoint()
+    shareLinkEndpoint := endpoints.NewShareLinkEndpoint()
+    profileEndpoint := endpoints.NewProfileEndpoint()
+    streamEndpoint := endpoints.NewStreamEndpoint()
+    socketEndpoint := endpoints.NewSocketEndpoint()
+    syncEndpoint := endpoints.NewSyncEndpoint()
+    webhookEndpoint := endpoints.NewWebhookEndpoint()
+    mailEndpoint := endpoints.NewMailEndpoint()
 
     // Create a handler for endpoints
     r.POST("/api/token/type", tokenEndpoint.Type)
     r.POST("/api/token/new", tokenEndpoint.New)
     r.POST("/api/token/invalidate", tokenEndpoint.Invalidate)
     r.POST("/api/todo/update", todoEndpoint.Update)
+    r.POST("/api/todo/patch", todoEndpoint.Patch)
+    r.PATCH("/api/todo/patch", todoEndpoint.Patch)
     r.POST("/api/todo/remove", todoEndpoint.Remove)
     r.POST("/api/todo/info", todoEndpoint.Info)
+    r.POST("/api/todo/move", todoEndpoint.Move)
+    r.POST("/api/todo/assign", todoEndpoint.Assign)
+    r.GET("/api/todo/shares", todoEndpoint.Shares)
+    r.POST("/api/todo/shares", todoEndpoint.Shares)
+    r.POST("/api/todo/share", todoEndpoint.Share)
+    r.POST("/api/todo/occurrences", todoEndpoint.Occurrences)
+    r.GET("/api/todo/history", todoEndpoint.History)
+    r.POST("/api/todo/history", todoEndpoint.History)
+    r.POST("/api/todo/restore", todoEndpoint.Restore)
     r.GET("/api/todos/list", todosEndpoint.List)
     r.POST("/api/todos/list", todosEndpoint.List)
+    r.POST("/api/todos/batch", todosEndpoint.Batch)
     r.GET("/api/tags/list", tagsEndpoint.List)
+    r.POST("/api/tags/list", tagsEndpoint.List)
+    r.POST("/api/tag/update", tagEndpoint.Update)
+    r.POST("/api/tag/merge", tagEndpoint.Merge)
+    r.POST("/api/tag/remove", tagEndpoint.Remove)
+    r.GET("/api/trash/list", trashEndpoint.List)
+    r.POST("/api/trash/list", trashEndpoint.List)
+    r.POST("/api/trash/restore", trashEndpoint.Restore)
+    r.POST("/api/trash/purge", trashEndpoint.Purge)
+    r.GET("/api/comment/list", commentEndpoint.List)
+    r.POST("/api/comment/list", commentEndpoint.List)
+    r.POST("/api/comment/update", commentEndpoint.Update)
+    r.POST("/api/comment/remove", commentEndpoint.Remove)
+    r.GET("/api/attachment/list", attachmentEndpoint.List)
+    r.POST("/api/attachment/list", attachmentEndpoint.List)
+    r.POST("/api/attachment/upload", attachmentEndpoint.Upload)
+    r.GET("/api/attachment/download", attachmentEndpoint.Download)
+    r.POST("/api/attachment/download", attachmentEndpoint.Download)
+    r.POST("/api/attachment/remove", attachmentEndpoint.Remove)
+    r.POST("/api/dependency/add", dependencyEndpoint.Add)
+    r.POST("/api/dependency/remove", dependencyEndpoint.Remove)
+    r.GET("/api/dependency/graph", dependencyEndpoint.Graph)
+    r.POST("/api/dependency/graph", dependencyEndpoint.Graph)
+    r.GET("/api/boards/list", boardEndpoint.List)
+    r.POST("/api/boards/list", boardEndpoint.List)
+    r.POST("/api/board/update", boardEndpoint.Update)
+    r.POST("/api/board/remove", boardEndpoint.Remove)
+    r.GET("/api/board/members", boardEndpoint.Members)
+    r.POST("/api/board/members", boardEndpoint.Members)
+    r.POST("/api/board/member", boardEndpoint.Member)
+    r.POST("/api/link/new", shareLinkEndpoint.New)
+    r.GET("/api/links/list", shareLinkEndpoint.List)
+    r.POST("/api/links/list", shareLinkEndpoint.List)
+    r.POST("/api/link/revoke", shareLinkEndpoint.Revoke)
+    r.GET("/api/link/view/:slug", shareLinkEndpoint.View)
+    r.POST("/api/link/view/:slug", shareLinkEndpoint.View)
+    r.POST("/api/profile/info", profileEndpoint.Info)
+    r.POST("/api/profile/update", profileEndpoint.Update)
+    r.GET("/api/profile/todos", profileEndpoint.Todos)
+    r.POST("/api/profile/todos", profileEndpoint.Todos)
+    r.GET("/api/profile/page/:name", profileEndpoint.Page)
+    r.GET("/api/stream/todos", streamEndpoint.Todos)
+    r.GET("/api/socket", socketEndpoint.Connect)
+    r.GET("/api/sync/changes", syncEndpoint.Changes)
+    r.POST("/api/sync/changes", syncEndpoint.Changes)
+    r.POST("/api/sync/upload", syncEndpoint.Upload)
+    r.POST("/api/webhook/update", webhookEndpoint.Update)
+    r.GET("/api/webhooks/list", webhookEndpoint.List)
+    r.POST("/api/webhooks/list", webhookEndpoint.List)
+    r.POST("/api/webhook/remove", webhookEndpoint.Remove)
+    r.GET("/api/webhook/deliveries", webhookEndpoint.Deliveries)
+    r.POST("/api/webhook/deliveries", webhookEndpoint.Deliveries)
+    r.POST("/api/webhook/redeliver", webhookEndpoint.Redeliver)
+    r.POST("/api/mail/address", mailEndpoint.Address)
 
     // Get the port
     port := os.Getenv("PORT")
@@ -54,9 +235,15 @@ func main() {
     }
 
     // Start server
-    handler := cors.Default().Handler(r)
+    handler := cors.New(cors.Options{
+        AllowedMethods: []string{"GET", "POST", "HEAD", "PATCH"},
+        // Let browsers use entity tags for todo versions and idempotency keys,
+        // and resume streams of changes
+        AllowedHeaders: []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "If-Match", "Idempotency-Key", "Last-Event-ID"},
+        ExposedHeaders: []string{"ETag", "Idempotent-Replayed"},
+    }).Handler(r)
     log.Printf("Server listening on 0.0.0.0:%s", port)
-    err := http.ListenAndServe(":" + port, handler)
+    err = http.ListenAndServe(":" + port, handler)
     if err != nil {
         log.Fatalf("Failed to listen: %s", err)
     }
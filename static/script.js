@@ -11,16 +11,23 @@ let converter = new showdown.Converter({
 // LocalStorage helper
 const LOCALSTORAGE_KEYS = {
   TOKEN: 1,
-  USERNAME: 2,
-  TAGS: 3
+  USERNAME: 2
 };
 const API_ROOT = "http://nuc.int.masonx.ca:8080/api";
 
 // Global variables
 var todos = [];
 var tags = [];
+var boards = [];
 var focus_id = -1;
 var focus_values = {};
+var focus_comments = [];
+var editing_comment_id = -1;
+var owner_id = 0;
+var stream = null;
+var socket = null;
+var presence_todo = 0;
+var lock_timer = null;
 
 // Helper functions
 function post(url, data, callback) {
@@ -44,6 +51,27 @@ function post(url, data, callback) {
   xmlhttp.send(JSON.stringify(data));
 }
 
+function upload(url, form, callback) {
+  var xmlhttp = new XMLHttpRequest();
+  xmlhttp.open("POST", API_ROOT + url, true);
+
+  xmlhttp.onreadystatechange = function() {
+    if (xmlhttp.readyState == 4) {
+      // Nice reasons why errors occur
+      if (xmlhttp.status >= 500) {
+        callback("API server error");
+      } else if (xmlhttp.status == 404) {
+        callback("Incorrect server configuration");
+      } else {
+        callback(xmlhttp.responseText);
+      }
+    }
+  };
+
+  // the browser sets the multipart content type itself
+  xmlhttp.send(form);
+}
+
 function get(url, callback) {
   var xmlhttp = new XMLHttpRequest();
   xmlhttp.open("GET", API_ROOT + url, true);
@@ -86,6 +114,10 @@ function tomorrowAtNineAm() {
   return tomorrow.toISOString().slice(0,16);
 }
 
+function browserTimeZone() {
+  return Intl.DateTimeFormat().resolvedOptions().timeZone || "";
+}
+
 function browserDateToServer(browser_date) {
   return new Date(browser_date).toISOString();
 }
@@ -147,6 +179,11 @@ function showModal(name) {
 }
 
 function hideModal(dont_hide_backdrop) {
+  // stop telling others we are looking at a todo
+  if (modal_showing == "modal-detailedtodo" || modal_showing == "modal-edittodo") {
+    leaveTodo();
+  }
+
   // hide the view
   document.getElementById(modal_showing).style.display = "none";
 
@@ -181,43 +218,6 @@ function notify(msg, isBad) {
 }
 
 // Own functions
-function genTagColors() {
-  var possibleColors = ["#07457E", "#FFC914", "#7C077E", "#17BEBB", "#F17300", "#76B041", "#8C0000"];
-  var curColors = possibleColors.slice();
-  var q;
-
-  // load existing colours
-  var oldColours = localStorage.getItem(LOCALSTORAGE_KEYS.TAGS);
-  if (oldColours) {
-    var oldColors = JSON.parse(oldColours);
-    for (var i = 0; i < oldColors.length; i++) {
-      for (var j = 0; j < tags.length; j++) {
-        if (oldColors[i].id == tags[j].id) {
-          // restore the existing color
-          tags[j].color = oldColors[i].color;
-          q = curColors.indexOf(tags[j].color);
-          if (q > -1) curColors.splice(q, 1);
-          break;
-        }
-      }
-    }
-  }
-
-  // only generate for those tags that don't already have colours
-  for (var i = 0; i < tags.length; i++) {
-    if (!tags[i].hasOwnProperty("color")) {
-      // Ran out of colors
-      if (curColors.length == 0) curColors = possibleColors.slice();
-      tags[i].color = curColors[Math.floor(Math.random()*curColors.length)];
-      q = curColors.indexOf(tags[i].color);
-      if (q > -1) curColors.splice(q, 1);
-    }
-  }
-
-  // store the results
-  localStorage.setItem(LOCALSTORAGE_KEYS.TAGS, JSON.stringify(tags));
-}
-
 function tagToColor(tag) {
   for (var i = 0; i < tags.length; i++) {
     if (tag == tags[i].id) return tags[i].color;
@@ -248,15 +248,82 @@ function hookTodos() {
   }
 }
 var selected = [];
+var known_tags = [];
+function todoTagIds(todo) {
+  if (todo.tag_ids) return todo.tag_ids;
+  return todo.tag_id ? [todo.tag_id] : [];
+}
+function todoSelected(todo) {
+  var tagIds = todoTagIds(todo);
+  // untagged todos are always shown
+  if (tagIds.length == 0) return true;
+  for (var j = 0; j < tagIds.length; j++) {
+    if (selected.indexOf(tagIds[j]) > -1) return true;
+  }
+  return false;
+}
+function todoTagNames(todo) {
+  var tagIds = todoTagIds(todo);
+  var names = [];
+  for (var j = 0; j < tagIds.length; j++) {
+    for (var k = 0; k < tags.length; k++) {
+      if (tags[k].id == tagIds[j]) names.push(tags[k].path);
+    }
+  }
+  return names.join(", ");
+}
+function todoAssignedTo(todo, user_id) {
+  var assignees = todo.assignees || [];
+  for (var j = 0; j < assignees.length; j++) {
+    if (assignees[j].id == user_id) return true;
+  }
+  return false;
+}
+function assigneeNames(todo) {
+  var names = [];
+  var assignees = todo.assignees || [];
+  for (var j = 0; j < assignees.length; j++) {
+    names.push(assignees[j].name);
+  }
+  return names;
+}
+function initials(name) {
+  var parts = name.split(/[\s._-]+/).filter(function (part) { return part; });
+  if (parts.length > 1) return (parts[0][0] + parts[1][0]).toUpperCase();
+  return name.substring(0, 2).toUpperCase();
+}
+
 function updateFilter() {
   var strs = ["", "", "", "", ""];
+  var mine = document.getElementById("mgmnt-mine").checked;
+  var board = document.getElementById("mgmnt-board").value;
   for (var i = 0; i < todos.length; i++) {
-    // first check if this todo is selected
-    if (selected.indexOf(todos[i].tag_id) < 0) continue;
+    // first check if any of this todo's tags are selected
+    if (!todoSelected(todos[i])) continue;
+    // and if it is on the board being looked at
+    if (board !== "" && (todos[i].board_id || 0) != parseInt(board)) continue;
+    // and if it is assigned to us, when only those are wanted
+    if (mine && !todoAssignedTo(todos[i], owner_id)) continue;
+    // done todos are not shown on the board
+    if (todos[i].state >= strs.length) continue;
 
     // it is, append the data
-    strs[todos[i].state] += "<li style=\"color: " + tagToColor(todos[i].tag_id) + "\" ";
-    strs[todos[i].state] += "class=\"todo-item\" data-id=\"" + todos[i].id + "\" draggable=\"true\">" + todos[i].name;
+    strs[todos[i].state] += "<li style=\"color: " + tagToColor(todos[i].tag_id) + "\" title=\"" + todoTagNames(todos[i]) + "\" ";
+    strs[todos[i].state] += "class=\"todo-item\" data-id=\"" + todos[i].id + "\" draggable=\"true\">";
+    if (todos[i].priority) {
+      strs[todos[i].state] += "<span class=\"priority\" data-id=\"" + todos[i].id + "\">" + todos[i].priority + "</span> ";
+    }
+    if (todos[i].blocked) {
+      strs[todos[i].state] += "<span class=\"blocked\" data-id=\"" + todos[i].id + "\" title=\"Waiting on todos that aren't done yet\">[blocked]</span> ";
+    }
+    strs[todos[i].state] += todos[i].name;
+    if (todos[i].comment_count) {
+      strs[todos[i].state] += " <span class=\"comment-count\" data-id=\"" + todos[i].id + "\">(" + todos[i].comment_count + " comment" + (todos[i].comment_count == 1 ? "" : "s") + ")</span>";
+    }
+    var assignees = todos[i].assignees || [];
+    for (var j = 0; j < assignees.length; j++) {
+      strs[todos[i].state] += "<span class=\"assignee\" data-id=\"" + todos[i].id + "\" title=\"" + assignees[j].name + "\">" + initials(assignees[j].name) + "</span>";
+    }
     let dueStr = prettyPrintDue(todos[i].due_date);
     if (dueStr) {
       strs[todos[i].state] += "<div class=\"due-date\"\" data-id=\"" + todos[i].id + "\">(due " + dueStr + ")</div>";
@@ -298,33 +365,123 @@ function hookTags() {
 function syncTags() {
   var str = "";
   var str2 = "";
-
-  genTagColors();
+  var str3 = "<option value=\"-1\">New tag</option>";
+  var str4 = "<option value=\"0\">No other tag</option>";
+  var str5 = "<option value=\"0\">Top level</option>";
 
   for (var i = 0; i < tags.length; i++) {
-    selected.push(tags[i].id);
-    str += "<option value=\"" + tags[i].id + "\" style=\"color: " + tags[i].color + ";\">" + tags[i].name + "</option>";
-    str2 += "<li class=\"tag-list-item\" data-value=\"" + tags[i].id + "\" style=\"background-color: " + tags[i].color + "; border: 1px solid " + tags[i].color + "; color: #fff;\">" + tags[i].name + "</li>";
+    // newly seen tags start out selected
+    if (known_tags.indexOf(tags[i].id) < 0) {
+      known_tags.push(tags[i].id);
+      selected.push(tags[i].id);
+    }
+    let isSelected = selected.indexOf(tags[i].id) > -1;
+    str += "<option value=\"" + tags[i].id + "\" style=\"color: " + tags[i].color + ";\">" + tags[i].path + "</option>";
+    str2 += "<li class=\"tag-list-item\" data-value=\"" + tags[i].id + "\" title=\"" + tags[i].description + "\" style=\"background-color: " + (isSelected ? tags[i].color : "#fff") + "; border: 1px solid " + tags[i].color + "; color: " + (isSelected ? "#fff" : tags[i].color) + ";\">" + tags[i].path + "</li>";
+    if (tags[i].board_id ? onBoard(tags[i].board_id) : tags[i].owner_id == owner_id) {
+      str3 += "<option value=\"" + tags[i].id + "\">" + tags[i].path + "</option>";
+      str4 += "<option value=\"" + tags[i].id + "\">" + tags[i].path + "</option>";
+      str5 += "<option value=\"" + tags[i].id + "\">" + tags[i].path + "</option>";
+    }
   }
 
   document.getElementById("me-tagid").innerHTML = str;
   document.getElementById("mgmnt-tags").innerHTML = str2;
+  document.getElementById("mtag-tagid").innerHTML = str3;
+  document.getElementById("mtag-target").innerHTML = str4;
+  document.getElementById("mtag-parent").innerHTML = str5;
+  fillTagForm();
   setTimeout(hookTags, 50);
 }
 
+function fillTagForm() {
+  var id = parseInt(document.getElementById("mtag-tagid").value);
+  var tag = {name: "", color: "#07457e", description: "", parent_id: 0};
+  for (var i = 0; i < tags.length; i++) {
+    if (tags[i].id == id) tag = tags[i];
+  }
+  document.getElementById("mtag-name").value = tag.name;
+  document.getElementById("mtag-color").value = tag.color.toLowerCase();
+  document.getElementById("mtag-desc").value = tag.description;
+  document.getElementById("mtag-parent").value = tag.parent_id;
+}
+
+function tagChanged(what) {
+  return function (text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to " + what + " tag: " + json.error, true);
+      } else {
+        notify("Successfully " + what + "d tag");
+        fetchTags();
+        updateTodos();
+      }
+    } catch (e) {
+      notify("Failed to " + what + " tag: " + text, true);
+    }
+  };
+}
+
+function saveTag() {
+  post("/tag/update", {
+    tag: {
+      id: parseInt(document.getElementById("mtag-tagid").value),
+      name: document.getElementById("mtag-name").value,
+      color: document.getElementById("mtag-color").value,
+      description: document.getElementById("mtag-desc").value,
+      parent_id: parseInt(document.getElementById("mtag-parent").value),
+      // new tags go on the board being looked at
+      board_id: selectedBoardId(),
+    },
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, tagChanged("save"));
+}
+
+function mergeTag() {
+  post("/tag/merge", {
+    source: {id: parseInt(document.getElementById("mtag-tagid").value)},
+    target: {id: parseInt(document.getElementById("mtag-target").value)},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, tagChanged("merge"));
+}
+
+function deleteTag() {
+  post("/tag/remove", {
+    tag: {id: parseInt(document.getElementById("mtag-tagid").value)},
+    reassign_to: parseInt(document.getElementById("mtag-target").value),
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, tagChanged("delete"));
+}
+
 function loginOk() {
   document.getElementById("login-password").value = "";
   document.getElementById("mgmnt-panel-username").innerHTML = localStorage.getItem(LOCALSTORAGE_KEYS.USERNAME);
   document.getElementById("login-panel").style.display = "none";
   document.getElementById("mgmnt-panel").style.display = "block";
   document.getElementById("md-edit").style.display = "inline-block";
-
+  document.getElementById("md-comment-form").style.display = "block";
+  document.getElementById("md-attach-form").style.display = "block";
+  document.getElementById("md-attach").style.display = "inline-block";
+  document.getElementById("md-comment-post").style.display = "inline-block";
+
+  fetchBoards();
+  fetchProfile();
+  fetchMailAddress(false);
   updateTodos();
+  openStream();
+  openSocket();
 }
 function logoutOk() {
+  closeStream();
+  closeSocket();
   document.getElementById("login-panel").style.display = "block";
   document.getElementById("mgmnt-panel").style.display = "none";
   document.getElementById("md-edit").style.display = "none";
+  document.getElementById("md-comment-form").style.display = "none";
+  document.getElementById("md-attach-form").style.display = "none";
+  document.getElementById("md-attach").style.display = "none";
+  document.getElementById("md-comment-post").style.display = "none";
   updateTodos();
 }
 
@@ -360,7 +517,7 @@ function login() {
         // All good!
         localStorage.setItem(LOCALSTORAGE_KEYS.TOKEN, json.token);
         localStorage.setItem(LOCALSTORAGE_KEYS.USERNAME, document.getElementById("login-username").value);
-        loginOk();
+        checkLogin();
       }
     } catch (e) {
       notify("Failed to authenticate: " + text, true);
@@ -400,6 +557,8 @@ function checkLogin() {
         // Session invalid
       } else {
         // All good!
+        owner_id = json.owner_id;
+        syncTags();
         loginOk();
       }
     } catch (e) {
@@ -447,6 +606,103 @@ function createToken() {
   });
 }
 
+function fetchBoards() {
+  post("/boards/list", {
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function (text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch list of boards: " + json.error, true);
+        return;
+      }
+      boards = json.boards;
+      var select = document.getElementById("mgmnt-board");
+      var current = select.value;
+      var str = "<option value=\"\">All boards</option><option value=\"0\">Personal</option>";
+      for (var i = 0; i < boards.length; i++) {
+        str += "<option value=\"" + boards[i].id + "\">" + boards[i].name + " (" + boards[i].role + ")</option>";
+      }
+      select.innerHTML = str;
+      select.value = current;
+      // board tags can only be managed once the boards are known
+      syncTags();
+    } catch (e) {
+      notify("Failed to fetch list of boards: " + text, true);
+    }
+  });
+}
+
+function showProfile(text) {
+  try {
+    var json = JSON.parse(text);
+    if (json.error) {
+      notify("Failed to fetch profile: " + json.error, true);
+      return false;
+    }
+    document.getElementById("mgmnt-display-name").value = json.profile.display_name;
+    document.getElementById("mgmnt-profile-public").checked = json.profile.public;
+    document.getElementById("mgmnt-profile-link").href = API_ROOT + "/profile/page/" + encodeURIComponent(json.profile.name);
+    return true;
+  } catch (e) {
+    notify("Failed to fetch profile: " + text, true);
+    return false;
+  }
+}
+
+function fetchProfile() {
+  post("/profile/info", {
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, showProfile);
+}
+
+function saveProfile() {
+  post("/profile/update", {
+    profile: {
+      display_name: document.getElementById("mgmnt-display-name").value,
+      public: document.getElementById("mgmnt-profile-public").checked,
+    },
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function (text) {
+    if (showProfile(text)) notify("Profile saved.");
+  });
+}
+
+// the address mail that becomes a todo goes to, hidden if the server doesn't
+// receive mail
+function fetchMailAddress(reset) {
+  post("/mail/address", {
+    reset: reset,
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function (text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        document.getElementById("mgmnt-mail").style.display = "none";
+        if (reset) notify("Failed to make up a new address: " + json.error, true);
+        return;
+      }
+      document.getElementById("mgmnt-mail-address").textContent = json.address;
+      document.getElementById("mgmnt-mail").style.display = "block";
+      if (reset) notify("Mail sent to the old address is no longer accepted.");
+    } catch (e) {
+      document.getElementById("mgmnt-mail").style.display = "none";
+    }
+  });
+}
+
+function onBoard(board_id) {
+  for (var i = 0; i < boards.length; i++) {
+    if (boards[i].id == board_id) return true;
+  }
+  return false;
+}
+
+// the board new todos and tags go on, 0 for personal ones
+function selectedBoardId() {
+  return parseInt(document.getElementById("mgmnt-board").value) || 0;
+}
+
 function updateTodos() {
   var obj = {};
   var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
@@ -469,28 +725,243 @@ function updateTodos() {
   });
 }
 
-function updateTodo() {
-  post("/todo/update", {
-    todo: {
-      id: focus_id,
-      state: parseInt(document.getElementById("me-state").value),
-      tag_id: parseInt(document.getElementById("me-tagid").value),
-      public: document.getElementById("me-public").value == "yes",
-      name: document.getElementById("me-name").value,
-      due_date: browserDateToServer(document.getElementById("me-duedate").value),
-      description: document.getElementById("me-description").value,
-    },
+function storeTodo(todo) {
+  // the server sends back the todo as it was stored
+  for (var i = 0; i < todos.length; i++) {
+    if (todos[i].id == todo.id) {
+      todos[i] = todo;
+      updateFilter();
+      return;
+    }
+  }
+  // new todos go at the bottom of their column
+  todos.push(todo);
+  updateFilter();
+}
+
+// the server orders todos by rank within their column
+function sortTodos() {
+  todos.sort(function (a, b) {
+    if (a.rank != b.rank) return a.rank < b.rank ? -1 : 1;
+    return a.id - b.id;
+  });
+}
+
+function applyTodoEvent(e) {
+  var data = JSON.parse(e.data);
+  for (var i = 0; i < todos.length; i++) {
+    if (todos[i].id == data.todo_id) break;
+  }
+  if (e.type == "deleted") {
+    if (i < todos.length) todos.splice(i, 1);
+  } else if (i < todos.length) {
+    // keep what only the list has, such as the comment count
+    Object.assign(todos[i], data.todo);
+  } else {
+    todos.push(data.todo);
+  }
+  sortTodos();
+  updateFilter();
+}
+
+// follow the changes made by everyone else, the browser resuming the stream
+// where it left off if the connection drops
+function openStream() {
+  closeStream();
+  var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
+  if (!token || !window.EventSource) return;
+  stream = new EventSource(API_ROOT + "/stream/todos?authority=" + encodeURIComponent(token));
+  ["created", "updated", "moved", "deleted"].forEach(function (type) {
+    stream.addEventListener(type, applyTodoEvent, false);
+  });
+}
+
+function closeStream() {
+  if (stream) {
+    stream.close();
+    stream = null;
+  }
+}
+
+// the socket tells others who is looking at which todo
+function openSocket() {
+  closeSocket();
+  var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
+  if (!token || !window.WebSocket) return;
+  var ws = new WebSocket(API_ROOT.replace(/^http/, "ws") + "/socket?authority=" + encodeURIComponent(token));
+  ws.onmessage = function (e) {
+    var msg = JSON.parse(e.data);
+    if (msg.type == "presence" && msg.todo_id == presence_todo) {
+      showPresence(msg.users);
+    } else if (msg.type == "response" && msg.error && msg.id == "lock") {
+      notify(msg.error + ", your changes may conflict", true);
+    }
+  };
+  ws.onclose = function () {
+    // try again in a bit, unless we signed off
+    if (socket != ws) return;
+    socket = null;
+    setTimeout(function () {
+      if (!socket && localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN)) openSocket();
+    }, 5000);
+  };
+  ws.onopen = function () {
+    // let the others know again after reconnecting
+    if (presence_todo) {
+      socketSend({type: "presence", todo_id: presence_todo, activity: lock_timer ? "editing" : "viewing"});
+    }
+  };
+  socket = ws;
+}
+
+function closeSocket() {
+  if (socket) {
+    var ws = socket;
+    socket = null;
+    ws.close();
+  }
+}
+
+function socketSend(msg) {
+  if (socket && socket.readyState == WebSocket.OPEN) {
+    socket.send(JSON.stringify(msg));
+  }
+}
+
+function showPresence(users) {
+  var str = users.filter(function (user) {
+    return user.user_id != owner_id;
+  }).map(function (user) {
+    return user.name + " is " + user.activity + " this todo";
+  }).join(", ");
+  document.getElementById("md-presence").innerText = str;
+  document.getElementById("me-presence").innerText = str;
+}
+
+function announceTodo(id, activity) {
+  presence_todo = id;
+  socketSend({type: "presence", todo_id: id, activity: activity});
+}
+
+function lockTodo() {
+  socketSend({id: "lock", type: "lock", todo_id: presence_todo});
+}
+
+function leaveTodo() {
+  if (lock_timer) {
+    clearInterval(lock_timer);
+    lock_timer = null;
+    socketSend({type: "unlock", todo_id: presence_todo});
+  }
+  if (presence_todo) {
+    socketSend({type: "presence", todo_id: presence_todo, activity: ""});
+    presence_todo = 0;
+  }
+  showPresence([]);
+}
+
+function selectedTagIds() {
+  var options = document.getElementById("me-tagid").options;
+  var ids = [];
+  for (var i = 0; i < options.length; i++) {
+    if (options[i].selected) ids.push(parseInt(options[i].value));
+  }
+  return ids;
+}
+
+function selectTagIds(ids) {
+  var options = document.getElementById("me-tagid").options;
+  for (var i = 0; i < options.length; i++) {
+    options[i].selected = ids.indexOf(parseInt(options[i].value)) > -1;
+  }
+}
+
+function changedFields(todo, original) {
+  var changed = {};
+  for (var key in todo) {
+    var a = todo[key];
+    var b = original[key];
+    // the same date can be written in more than one way
+    if (key == "due_date") {
+      a = new Date(a).getTime();
+      b = new Date(b).getTime();
+    }
+    if (JSON.stringify(a) != JSON.stringify(b)) changed[key] = todo[key];
+  }
+  return changed;
+}
+
+// ask before starting a todo that other todos still block
+function confirmBlocked(blocked_by) {
+  var names = [];
+  for (var i = 0; i < blocked_by.length; i++) {
+    names.push(todoName(blocked_by[i]));
+  }
+  return confirm("This todo is still waiting on: " + names.join(", ") + ".\n\n" +
+                 "Press OK to start it anyway.");
+}
+
+function todoName(id) {
+  for (var i = 0; i < todos.length; i++) {
+    if (todos[i].id == id) return todos[i].name;
+  }
+  return "#" + id;
+}
+
+function updateTodo(force) {
+  var url = "/todo/update";
+  var todo = {
+    id: focus_id,
+    state: parseInt(document.getElementById("me-state").value),
+    tag_ids: selectedTagIds(),
+    public: document.getElementById("me-public").checked,
+    name: document.getElementById("me-name").value,
+    due_date: browserDateToServer(document.getElementById("me-duedate").value),
+    description: document.getElementById("me-description").value,
+    rrule: document.getElementById("me-rrule").value,
+    priority: document.getElementById("me-priority").value,
+    estimate: parseInt(document.getElementById("me-estimate").value) || 0,
+    timezone: (focus_id != -1 && focus_values.timezone) ? focus_values.timezone : browserTimeZone(),
+  };
+  var assignees = document.getElementById("me-assignees").value.split(",").map(function (name) {
+    return name.trim();
+  }).filter(function (name) { return name; }).join(", ");
+  if (focus_id == -1) {
+    // new todos go on the board being looked at
+    todo.board_id = selectedBoardId();
+  } else {
+    // only send what was changed, so nothing else gets overwritten
+    url = "/todo/patch";
+    todo = changedFields(todo, focus_values);
+    todo.id = focus_id;
+    todo.version = focus_values.version;
+  }
+
+  post(url, {
+    todo: todo,
     authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+    force: !!force,
   }, function(text) {
     try {
       var json = JSON.parse(text);
-      if (json.error) {
+      if (json.error && json.blocked_by) {
+        if (confirmBlocked(json.blocked_by)) updateTodo(true);
+      } else if (json.error && json.todo) {
+        // changed somewhere else while it was being edited
+        resolveConflict(json.todo);
+      } else if (json.error) {
         notify("Failed to " + (focus_id == -1 ? "create" : "update") + " todo: " + json.error, true);
       } else {
         notify("Successfully " + (focus_id == -1 ? "created" : "updated") + " todo");
         // Hide modal by default
         hideModal();
-        updateTodos();
+        if (json.todo && assignees != assigneeNames(focus_values).join(", ")) {
+          assignTodo(json.todo.id, assignees);
+        } else if (json.todo) {
+          storeTodo(json.todo);
+        } else {
+          updateTodos();
+        }
       }
     } catch (e) {
       notify("Failed to " + (focus_id == -1 ? "create" : "update") + " todo: " + text, true);
@@ -498,6 +969,60 @@ function updateTodo() {
   });
 }
 
+function assignTodo(id, assignees) {
+  post("/todo/assign", {
+    todo: {id: id},
+    assignees: assignees ? assignees.split(", ") : [],
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to assign todo: " + json.error, true);
+      }
+    } catch (e) {
+      notify("Failed to assign todo: " + text, true);
+    }
+    updateTodos();
+  });
+}
+
+function resolveConflict(server_todo) {
+  focus_values = server_todo;
+  if (confirm("This todo was changed somewhere else while you were editing it.\n\n" +
+              "Press OK to save your changes over theirs, or Cancel to load their version.")) {
+    // the form is left as is, only the version is brought up to date
+    updateTodo();
+  } else {
+    notify("Loaded the latest version of the todo", true);
+    startEditingTodo();
+  }
+}
+
+function moveTodo(id, state, position, force) {
+  post("/todo/move", {
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+    todo: {id: id},
+    state: state,
+    position: position,
+    force: force,
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error && json.blocked_by) {
+        if (confirmBlocked(json.blocked_by)) moveTodo(id, state, position, true);
+      } else if (json.error) {
+        notify("Failed to move todo: " + json.error, true);
+      } else {
+        notify("Successfully moved todo");
+        updateTodos();
+      }
+    } catch (e) {
+      notify("Failed to move todo: " + text, true);
+    }
+  });
+}
+
 function deleteTodo() {
   post("/todo/remove", {
     todo: {
@@ -510,7 +1035,7 @@ function deleteTodo() {
       if (json.error) {
         notify("Failed to delete todo: " + json.error, true);
       } else {
-        notify("Successfully deleted todo");
+        notify("Moved todo to the trash");
         hideModal();
         updateTodos();
       }
@@ -520,41 +1045,492 @@ function deleteTodo() {
   });
 }
 
-function infoTodo() {
-  var obj = {};
-  var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
-  if (token) {
-    obj.authority = token;
-  }
-  obj.todo = {id: focus_id};
-  post("/todo/info", obj, function(text) {
+function fetchTrash() {
+  post("/trash/list", {
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
     try {
       var json = JSON.parse(text);
       if (json.error) {
-        notify("Failed to fetch information for todo: " + json.error, true);
+        notify("Failed to fetch trash: " + json.error, true);
       } else {
-        focus_values = json.todo;
-        document.getElementById("md-name").innerHTML = focus_values.name;
-        document.getElementById("md-duedate").innerHTML = serverDateToPretty(focus_values.due_date);
-        document.getElementById("md-desc").innerHTML = converter.makeHtml(focus_values.description);
-        showModal("detailedtodo");
+        var trashed = json.todos ? json.todos : [];
+        var str = "";
+        for (var i = 0; i < trashed.length; i++) {
+          str += "<li style=\"color: " + tagToColor(trashed[i].tag_id) + "\">" + trashed[i].name;
+          str += "<div class=\"due-date\">(deleted " + serverDateToPretty(trashed[i].deleted_at) + ")</div>";
+          str += "<a href=\"#\" class=\"trash-restore\" data-id=\"" + trashed[i].id + "\">Restore</a> ";
+          str += "<a href=\"#\" class=\"trash-purge\" data-id=\"" + trashed[i].id + "\">Delete forever</a></li>";
+        }
+        if (!str) str = "<li>The trash is empty.</li>";
+        document.getElementById("mtr-todos").innerHTML = str;
       }
     } catch (e) {
-      notify("Failed to fetch information for todo: " + text, true);
+      notify("Failed to fetch trash: " + text, true);
     }
   });
 }
 
-function startEditingTodo(is_new) {
-  showModal("edittodo");
-  document.getElementById("me-name").value = focus_values.name;
-  document.getElementById("me-duedate").value = serverDateToBrowser(focus_values.due_date);
-  document.getElementById("me-description").value = focus_values.description;
-  document.getElementById("me-state").selectedIndex = focus_values.state - 1;
-  document.getElementById("me-tagid").selectedIndex = focus_values.tag_id - 1;
-  document.getElementById("me-public").checked = focus_values.public;
-
-  setTimeout(function () {
+function trashAction(url, what, id) {
+  post(url, {
+    todo: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to " + what + " todo: " + json.error, true);
+      } else {
+        notify("Successfully " + what + "d todo");
+        fetchTrash();
+        updateTodos();
+      }
+    } catch (e) {
+      notify("Failed to " + what + " todo: " + text, true);
+    }
+  });
+}
+
+function fetchWebhooks() {
+  post("/webhooks/list", {
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch webhooks: " + json.error, true);
+      } else {
+        var str = "";
+        for (var i = 0; i < json.webhooks.length; i++) {
+          var hook = json.webhooks[i];
+          str += "<li>" + hook.url;
+          str += "<div class=\"due-date\">(" + (hook.events.length ? hook.events.join(", ") : "all changes");
+          str += hook.active ? ")" : ", turned off)";
+          str += "</div><a href=\"#\" class=\"webhook-deliveries\" data-id=\"" + hook.id + "\">Deliveries</a> ";
+          str += "<a href=\"#\" class=\"webhook-remove\" data-id=\"" + hook.id + "\">Remove</a></li>";
+        }
+        if (!str) str = "<li>No webhooks.</li>";
+        document.getElementById("mwh-webhooks").innerHTML = str;
+      }
+    } catch (e) {
+      notify("Failed to fetch webhooks: " + text, true);
+    }
+  });
+}
+
+function addWebhook() {
+  post("/webhook/update", {
+    webhook: {
+      url: document.getElementById("mwh-url").value,
+      secret: document.getElementById("mwh-secret").value,
+    },
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to add webhook: " + json.error, true);
+      } else {
+        notify("Added webhook, its secret is " + json.webhook.secret);
+        document.getElementById("mwh-url").value = "";
+        document.getElementById("mwh-secret").value = "";
+        fetchWebhooks();
+      }
+    } catch (e) {
+      notify("Failed to add webhook: " + text, true);
+    }
+  });
+}
+
+function removeWebhook(id) {
+  post("/webhook/remove", {
+    webhook: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to remove webhook: " + json.error, true);
+      } else {
+        document.getElementById("mwh-deliveries").innerHTML = "";
+        fetchWebhooks();
+      }
+    } catch (e) {
+      notify("Failed to remove webhook: " + text, true);
+    }
+  });
+}
+
+function fetchDeliveries(id) {
+  post("/webhook/deliveries", {
+    webhook: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch deliveries: " + json.error, true);
+      } else {
+        var str = "";
+        for (var i = 0; i < json.deliveries.length; i++) {
+          var delivery = json.deliveries[i];
+          str += "<li>" + delivery.event + " todo " + delivery.payload.todo_id + " <span class=\"comment-meta\">";
+          str += delivery.status + " after " + delivery.attempts + " attempts";
+          if (delivery.error) str += " (" + delivery.error + ")";
+          str += ", " + serverDateToPretty(delivery.created);
+          str += " <a href=\"#\" class=\"webhook-redeliver\" data-id=\"" + delivery.id + "\" data-webhook=\"" + id + "\">Redeliver</a></span></li>";
+        }
+        if (!str) str = "<li>Nothing was delivered yet.</li>";
+        document.getElementById("mwh-deliveries").innerHTML = str;
+      }
+    } catch (e) {
+      notify("Failed to fetch deliveries: " + text, true);
+    }
+  });
+}
+
+function redeliver(id, webhook_id) {
+  post("/webhook/redeliver", {
+    delivery: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to redeliver: " + json.error, true);
+      } else {
+        notify("Delivering again");
+        fetchDeliveries(webhook_id);
+      }
+    } catch (e) {
+      notify("Failed to redeliver: " + text, true);
+    }
+  });
+}
+
+function infoTodo() {
+  var obj = {};
+  var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
+  if (token) {
+    obj.authority = token;
+  }
+  obj.todo = {id: focus_id};
+  post("/todo/info", obj, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch information for todo: " + json.error, true);
+      } else {
+        focus_values = json.todo;
+        document.getElementById("md-name").innerHTML = focus_values.name;
+        document.getElementById("md-duedate").innerHTML = serverDateToPretty(focus_values.due_date);
+        document.getElementById("md-desc").innerHTML = converter.makeHtml(focus_values.description);
+        document.getElementById("md-rrule").style.display = focus_values.rrule ? "block" : "none";
+        document.getElementById("md-rrule-rule").innerText = focus_values.rrule;
+        document.getElementById("md-blocked").style.display = focus_values.blocked_by ? "block" : "none";
+        document.getElementById("md-blocked-by").innerText = (focus_values.blocked_by || []).map(todoName).join(", ");
+        fetchAttachments();
+        fetchShares();
+        fetchComments();
+        showModal("detailedtodo");
+        announceTodo(focus_id, "viewing");
+      }
+    } catch (e) {
+      notify("Failed to fetch information for todo: " + text, true);
+    }
+  });
+}
+
+function fetchAttachments() {
+  var obj = {todo: {id: focus_id}};
+  var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
+  if (token) {
+    obj.authority = token;
+  }
+  document.getElementById("md-attach-file").value = "";
+  post("/attachment/list", obj, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch attachments: " + json.error, true);
+      } else {
+        var str = "";
+        for (var i = 0; i < json.attachments.length; i++) {
+          var attachment = json.attachments[i];
+          var link = API_ROOT + "/attachment/download?id=" + attachment.id;
+          if (token) link += "&authority=" + encodeURIComponent(token);
+          str += "<li><a href=\"" + link + "\" target=\"_blank\">" + attachment.name + "</a>";
+          str += " <span class=\"comment-meta\">" + Math.ceil(attachment.size / 1024) + " KB, " + serverDateToPretty(attachment.created);
+          if (focus_values.owner_id == owner_id) {
+            str += " <a href=\"#\" class=\"attachment-delete\" data-id=\"" + attachment.id + "\">Delete</a>";
+          }
+          str += "</span></li>";
+        }
+        if (!str) str = "<li>No attachments.</li>";
+        document.getElementById("md-attachments").innerHTML = str;
+      }
+    } catch (e) {
+      notify("Failed to fetch attachments: " + text, true);
+    }
+  });
+}
+
+function fetchShares() {
+  // only the owner gets to see and change who the todo is shared with
+  document.getElementById("md-sharing").style.display = "none";
+  document.getElementById("md-share-name").value = "";
+  if (focus_values.owner_id != owner_id) return;
+  post("/todo/shares", {
+    todo: {id: focus_id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, showShares);
+}
+
+function showShares(text) {
+  try {
+    var json = JSON.parse(text);
+    if (json.error) {
+      notify("Failed to fetch shares: " + json.error, true);
+    } else {
+      var str = "";
+      for (var i = 0; i < json.shares.length; i++) {
+        var share = json.shares[i];
+        str += "<li>" + share.name + " <span class=\"comment-meta\">" + (share.level == "write" ? "can edit" : "can read");
+        str += " <a href=\"#\" class=\"share-delete\" data-name=\"" + share.name + "\">Stop sharing</a></span></li>";
+      }
+      if (!str) str = "<li>Not shared with anyone.</li>";
+      document.getElementById("md-shares").innerHTML = str;
+      document.getElementById("md-sharing").style.display = "block";
+      fetchLinks();
+    }
+  } catch (e) {
+    notify("Failed to fetch shares: " + text, true);
+  }
+}
+
+function shareTodo(name, level) {
+  post("/todo/share", {
+    todo: {id: focus_id},
+    share: {name: name, level: level},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    document.getElementById("md-share-name").value = "";
+    showShares(text);
+  });
+}
+
+function fetchLinks() {
+  post("/links/list", {
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch links: " + json.error, true);
+      } else {
+        var str = "";
+        for (var i = 0; i < json.links.length; i++) {
+          var link = json.links[i];
+          if (link.todo_id != focus_id) continue;
+          var url = API_ROOT + "/link/view/" + link.slug;
+          str += "<li><a href=\"" + url + "\" target=\"_blank\">" + url + "</a> <span class=\"comment-meta\">";
+          if (link.has_password) str += "password, ";
+          str += link.expires_at ? "expires " + serverDateToPretty(link.expires_at) : "never expires";
+          str += " <a href=\"#\" class=\"link-revoke\" data-id=\"" + link.id + "\">Revoke</a></span></li>";
+        }
+        if (!str) str = "<li>No links.</li>";
+        document.getElementById("md-links").innerHTML = str;
+      }
+    } catch (e) {
+      notify("Failed to fetch links: " + text, true);
+    }
+  });
+}
+
+function createLink() {
+  var link = {todo_id: focus_id, password: document.getElementById("md-link-password").value};
+  var expiry = document.getElementById("md-link-expiry").value;
+  if (expiry) link.expires_at = browserDateToServer(expiry);
+  post("/link/new", {
+    link: link,
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to create link: " + json.error, true);
+      } else {
+        document.getElementById("md-link-password").value = "";
+        document.getElementById("md-link-expiry").value = "";
+        fetchLinks();
+      }
+    } catch (e) {
+      notify("Failed to create link: " + text, true);
+    }
+  });
+}
+
+function revokeLink(id) {
+  post("/link/revoke", {
+    link: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to revoke link: " + json.error, true);
+      } else {
+        fetchLinks();
+      }
+    } catch (e) {
+      notify("Failed to revoke link: " + text, true);
+    }
+  });
+}
+
+function attachFile() {
+  var files = document.getElementById("md-attach-file").files;
+  if (!files.length) {
+    notify("Pick a file to attach first", true);
+    return;
+  }
+  var form = new FormData();
+  form.append("authority", localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN));
+  form.append("todo_id", focus_id);
+  form.append("file", files[0]);
+  upload("/attachment/upload", form, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to attach file: " + json.error, true);
+      } else {
+        notify("Successfully attached file");
+        fetchAttachments();
+      }
+    } catch (e) {
+      notify("Failed to attach file: " + text, true);
+    }
+  });
+}
+
+function deleteAttachment(id) {
+  post("/attachment/remove", {
+    attachment: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to delete attachment: " + json.error, true);
+      } else {
+        notify("Successfully deleted attachment");
+        fetchAttachments();
+      }
+    } catch (e) {
+      notify("Failed to delete attachment: " + text, true);
+    }
+  });
+}
+
+function fetchComments() {
+  var obj = {todo: {id: focus_id}};
+  var token = localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN);
+  if (token) {
+    obj.authority = token;
+  }
+  editing_comment_id = -1;
+  document.getElementById("md-comment").value = "";
+  post("/comment/list", obj, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to fetch comments: " + json.error, true);
+      } else {
+        var str = "";
+        for (var i = 0; i < json.comments.length; i++) {
+          var comment = json.comments[i];
+          str += "<li><div class=\"comment-meta\">" + comment.author_name + ", " + serverDateToPretty(comment.created);
+          if (comment.edited) str += " (edited)";
+          if (comment.author_id == owner_id) {
+            str += " <a href=\"#\" class=\"comment-edit\" data-id=\"" + comment.id + "\">Edit</a>";
+            str += " <a href=\"#\" class=\"comment-delete\" data-id=\"" + comment.id + "\">Delete</a>";
+          }
+          str += "</div>" + converter.makeHtml(comment.body) + "</li>";
+        }
+        if (!str) str = "<li>No comments yet.</li>";
+        document.getElementById("md-comments").innerHTML = str;
+        focus_comments = json.comments;
+      }
+    } catch (e) {
+      notify("Failed to fetch comments: " + text, true);
+    }
+  });
+}
+
+function postComment() {
+  post("/comment/update", {
+    comment: {
+      id: editing_comment_id,
+      todo_id: focus_id,
+      body: document.getElementById("md-comment").value,
+    },
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to save comment: " + json.error, true);
+      } else {
+        notify("Successfully saved comment");
+        fetchComments();
+        updateTodos();
+      }
+    } catch (e) {
+      notify("Failed to save comment: " + text, true);
+    }
+  });
+}
+
+function deleteComment(id) {
+  post("/comment/remove", {
+    comment: {id: id},
+    authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
+  }, function(text) {
+    try {
+      var json = JSON.parse(text);
+      if (json.error) {
+        notify("Failed to delete comment: " + json.error, true);
+      } else {
+        notify("Successfully deleted comment");
+        fetchComments();
+        updateTodos();
+      }
+    } catch (e) {
+      notify("Failed to delete comment: " + text, true);
+    }
+  });
+}
+
+function startEditingTodo(is_new) {
+  showModal("edittodo");
+  document.getElementById("me-name").value = focus_values.name;
+  document.getElementById("me-duedate").value = serverDateToBrowser(focus_values.due_date);
+  document.getElementById("me-description").value = focus_values.description;
+  document.getElementById("me-rrule").value = focus_values.rrule;
+  document.getElementById("me-priority").value = focus_values.priority;
+  document.getElementById("me-estimate").value = focus_values.estimate ? focus_values.estimate : "";
+  document.getElementById("me-state").selectedIndex = focus_values.state - 1;
+  selectTagIds(todoTagIds(focus_values));
+  document.getElementById("me-public").checked = focus_values.public;
+  document.getElementById("me-assignees").value = assigneeNames(focus_values).join(", ");
+  if (!is_new) {
+    announceTodo(focus_id, "editing");
+    lockTodo();
+    // the soft lock runs out unless it is taken again
+    lock_timer = setInterval(lockTodo, 60 * 1000);
+  }
+
+  setTimeout(function () {
     this.focus();
     this.setSelectionRange(this.value.length, this.value.length);
   }.bind(document.getElementById('me-description')), 10);
@@ -573,6 +1549,37 @@ function registerUIButtons() {
     }
   });
 
+  // Assigned to me filter
+  document.getElementById("mgmnt-mine").addEventListener('change', function(e) {
+    updateFilter();
+  }, false);
+
+  // Board filter
+  document.getElementById("mgmnt-board").addEventListener('change', function(e) {
+    updateFilter();
+  }, false);
+
+  // Tag management button
+  document.getElementById("mgmnt-tagmgmt").addEventListener('click', function(e) {
+    showModal("tags");
+    e.preventDefault();
+  }, false);
+
+  // Trash button
+  document.getElementById("mgmnt-trash").addEventListener('click', function(e) {
+    fetchTrash();
+    showModal("trash");
+    e.preventDefault();
+  }, false);
+
+  // Webhooks button
+  document.getElementById("mgmnt-webhooks").addEventListener('click', function(e) {
+    fetchWebhooks();
+    document.getElementById("mwh-deliveries").innerHTML = "";
+    showModal("webhooks");
+    e.preventDefault();
+  }, false);
+
   // Token management button
   document.getElementById("mgmnt-token").addEventListener('click', function(e) {
     showModal("token");
@@ -582,12 +1589,17 @@ function registerUIButtons() {
   // New todo button
   document.getElementById("mgmnt-newtodo").addEventListener('click', function(e) {
     focus_id = -1;
+    focus_values = {};
     document.getElementById("me-name").value = "";
     document.getElementById("me-duedate").value = tomorrowAtNineAm();
     document.getElementById("me-description").value = "";
+    document.getElementById("me-rrule").value = "";
+    document.getElementById("me-priority").value = "";
+    document.getElementById("me-estimate").value = "";
     document.getElementById("me-state").selectedIndex = "0";
-    document.getElementById("me-tagid").selectedIndex = "0";
+    selectTagIds(tags.length ? [tags[0].id] : []);
     document.getElementById("me-public").checked = false;
+    document.getElementById("me-assignees").value = "";
     showModal("edittodo");
     setTimeout(function () {
       this.focus();
@@ -596,6 +1608,17 @@ function registerUIButtons() {
     e.preventDefault();
   }, false);
 
+  // Save profile button
+  document.getElementById("mgmnt-profile-save").addEventListener('click', function(e) {
+    saveProfile();
+    e.preventDefault();
+  }, false);
+
+  document.getElementById("mgmnt-mail-reset").addEventListener('click', function(e) {
+    if (confirm("Mail sent to the current address will no longer be accepted. Continue?")) fetchMailAddress(true);
+    e.preventDefault();
+  }, false);
+
   // Logout button
   document.getElementById("mgmnt-logout").addEventListener('click', function(e) {
     logout();
@@ -608,6 +1631,77 @@ function registerUIButtons() {
     e.preventDefault();
   }, false);
 
+  // Modal - detailed todo - attach a file
+  document.getElementById("md-attach").addEventListener('click', function (e) {
+    attachFile();
+    e.preventDefault();
+  }, false);
+
+  // Modal - detailed todo - delete an attachment
+  document.getElementById("md-attachments").addEventListener('click', function (e) {
+    if (e.target.classList.contains("attachment-delete")) {
+      if (confirm("Delete this attachment?")) {
+        deleteAttachment(parseInt(e.target.dataset.id));
+      }
+      e.preventDefault();
+    }
+  }, false);
+
+  // Modal - detailed todo - share with someone or stop sharing
+  document.getElementById("md-share").addEventListener('click', function (e) {
+    var name = document.getElementById("md-share-name").value.trim();
+    if (name) {
+      shareTodo(name, document.getElementById("md-share-level").value);
+    }
+    e.preventDefault();
+  }, false);
+  document.getElementById("md-shares").addEventListener('click', function (e) {
+    if (e.target.classList.contains("share-delete")) {
+      shareTodo(e.target.dataset.name, "");
+      e.preventDefault();
+    }
+  }, false);
+
+  // Modal - detailed todo - create or revoke a link anyone can read it with
+  document.getElementById("md-link").addEventListener('click', function (e) {
+    createLink();
+    e.preventDefault();
+  }, false);
+  document.getElementById("md-links").addEventListener('click', function (e) {
+    if (e.target.classList.contains("link-revoke")) {
+      if (confirm("Revoke this link?")) {
+        revokeLink(parseInt(e.target.dataset.id));
+      }
+      e.preventDefault();
+    }
+  }, false);
+
+  // Modal - detailed todo - post a comment
+  document.getElementById("md-comment-post").addEventListener('click', function (e) {
+    postComment();
+    e.preventDefault();
+  }, false);
+
+  // Modal - detailed todo - edit or delete own comment
+  document.getElementById("md-comments").addEventListener('click', function (e) {
+    var id = parseInt(e.target.dataset.id);
+    if (e.target.classList.contains("comment-edit")) {
+      for (var i = 0; i < focus_comments.length; i++) {
+        if (focus_comments[i].id == id) {
+          editing_comment_id = id;
+          document.getElementById("md-comment").value = focus_comments[i].body;
+          document.getElementById("md-comment").focus();
+        }
+      }
+      e.preventDefault();
+    } else if (e.target.classList.contains("comment-delete")) {
+      if (confirm("Delete this comment?")) {
+        deleteComment(id);
+      }
+      e.preventDefault();
+    }
+  }, false);
+
   // Modal - token management - invalidate token
   document.getElementById("mt-invalidate").addEventListener('click', function (e) {
     invalidateToken();
@@ -619,6 +1713,58 @@ function registerUIButtons() {
     e.preventDefault();
   }, false);
 
+  // Modal - tag management - pick tag
+  document.getElementById("mtag-tagid").addEventListener('change', fillTagForm, false);
+  // Modal - tag management - save tag
+  document.getElementById("mtag-save").addEventListener('click', function (e) {
+    saveTag();
+    e.preventDefault();
+  }, false);
+  // Modal - tag management - merge tag
+  document.getElementById("mtag-merge").addEventListener('click', function (e) {
+    mergeTag();
+    e.preventDefault();
+  }, false);
+  // Modal - tag management - delete tag
+  document.getElementById("mtag-delete").addEventListener('click', function (e) {
+    deleteTag();
+    e.preventDefault();
+  }, false);
+
+  // Modal - trash - restore or purge todo
+  document.getElementById("mtr-todos").addEventListener('click', function (e) {
+    if (e.target.classList.contains("trash-restore")) {
+      trashAction("/trash/restore", "restore", parseInt(e.target.dataset.id));
+      e.preventDefault();
+    } else if (e.target.classList.contains("trash-purge")) {
+      trashAction("/trash/purge", "purge", parseInt(e.target.dataset.id));
+      e.preventDefault();
+    }
+  }, false);
+
+  // Modal - webhooks - add or remove a webhook, and look at its deliveries
+  document.getElementById("mwh-add").addEventListener('click', function (e) {
+    addWebhook();
+    e.preventDefault();
+  }, false);
+  document.getElementById("mwh-webhooks").addEventListener('click', function (e) {
+    if (e.target.classList.contains("webhook-deliveries")) {
+      fetchDeliveries(parseInt(e.target.dataset.id));
+      e.preventDefault();
+    } else if (e.target.classList.contains("webhook-remove")) {
+      if (confirm("Remove this webhook?")) {
+        removeWebhook(parseInt(e.target.dataset.id));
+      }
+      e.preventDefault();
+    }
+  }, false);
+  document.getElementById("mwh-deliveries").addEventListener('click', function (e) {
+    if (e.target.classList.contains("webhook-redeliver")) {
+      redeliver(parseInt(e.target.dataset.id), parseInt(e.target.dataset.webhook));
+      e.preventDefault();
+    }
+  }, false);
+
   // Modal - edit todo - delete todo
   document.getElementById("me-delete").addEventListener('click', function (e) {
     deleteTodo();
@@ -702,36 +1848,17 @@ function registerUIButtons() {
       }
     }
 
-    post("/todo/info", {
-      authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
-      todo: {id: parseInt(data)},
-    }, function(text) {
-      try {
-        var json = JSON.parse(text);
-        if (json.error) {
-          notify("Failed to fetch information for todo: " + json.error, true);
-        } else {
-          json.todo.state = parseInt(destList);
-          post("/todo/update", {
-            todo: json.todo,
-            authority: localStorage.getItem(LOCALSTORAGE_KEYS.TOKEN),
-          }, function(text) {
-            try {
-              var json = JSON.parse(text);
-              if (json.error) {
-                notify("Failed to update todo: " + json.error, true);
-              } else {
-                notify("Successfully updated todo");
-                updateTodos();
-              }
-            } catch (e) {
-              notify("Failed to update todo: " + text, true);
-            }
-          });
-        }
-      } catch (e) {
-        notify("Failed to fetch information for todo: " + text, true);
+    // figure out where in the box, counting the todos above the drop point
+    let position = 0;
+    let items = document.getElementById("todos-" + destList).getElementsByClassName("todo-item");
+    for (var i = 0; i < items.length; i++) {
+      if (items[i].dataset.id == data) continue;
+      let rect = items[i].getBoundingClientRect();
+      if (e.clientY > rect.top + rect.height / 2) {
+        position++;
       }
-    });
+    }
+
+    moveTodo(parseInt(data), parseInt(destList), position, false);
   });
 })();
@@ -0,0 +1,64 @@
+package storage
+
+import (
+    // standard library
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// Keeps content in files in a directory on the local filesystem
+type LocalStore struct {
+    dir     string
+}
+
+// Store content in the given directory, creating it if needed
+func NewLocalStore(dir string) (*LocalStore, error) {
+    err := os.MkdirAll(dir, 0700)
+    if err != nil {
+        return nil, err
+    }
+    return &LocalStore{
+        dir:    dir,
+    }, nil
+}
+
+func (ls *LocalStore) path(key string) string {
+    // Keys never have separators in them, but better safe than sorry
+    return filepath.Join(ls.dir, filepath.Base(key))
+}
+
+func (ls *LocalStore) Put(key string, r io.Reader) error {
+    // Write to a temporary file first, so a failed upload leaves nothing behind
+    f, err := os.CreateTemp(ls.dir, ".upload-*")
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(f, r)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), ls.path(key))
+    }
+    if err != nil {
+        os.Remove(f.Name())
+    }
+    return err
+}
+
+func (ls *LocalStore) Get(key string) (io.ReadCloser, error) {
+    f, err := os.Open(ls.path(key))
+    if os.IsNotExist(err) {
+        return nil, ErrNotFound
+    }
+    return f, err
+}
+
+func (ls *LocalStore) Delete(key string) error {
+    err := os.Remove(ls.path(key))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+    // standard library
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "io"
+)
+
+// Where the content of attachments is kept. Keys are made up by NewKey, so
+// stores don't have to worry about odd characters in them.
+type Store interface {
+    // Store the content read from r under key, replacing anything already there
+    Put(key string, r io.Reader) error
+    // Read the content stored under key, which the caller has to close
+    Get(key string) (io.ReadCloser, error)
+    // Remove the content stored under key, if there is any
+    Delete(key string) error
+}
+
+// Returned by stores when there is nothing stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+var (
+    // store in use
+    store Store
+)
+
+// Make up a new random key to store content under
+func NewKey() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// Use the given store from now on
+func Connect(s Store) {
+    store = s
+}
+
+func GetStore() Store {
+    return store
+}